@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/backfill"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+// admin allows the request only with a valid admin key in the X-Api-Key header. The key is never
+// taken from the query, where it would end up in logs and browser history
+func (s *Server) admin(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if s.cfg.AdminKey == "" {
+			s.fail(w, r, http.StatusForbidden, CodeForbidden, "admin endpoints are disabled")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Api-Key")), []byte(s.cfg.AdminKey)) != 1 {
+			s.fail(w, r, http.StatusUnauthorized, CodeUnauthorized, "invalid api key")
+			return
+		}
+		h(w, r, ps)
+	}
+}
+
+// jobs tracks backfill jobs running in the process
+type jobs struct {
+	mu      sync.Mutex
+	running map[int64]bool
+}
+
+// start marks the job as running, returns false if it is running already
+func (j *jobs) start(id int64) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.running[id] {
+		return false
+	}
+	if j.running == nil {
+		j.running = map[int64]bool{}
+	}
+	j.running[id] = true
+	return true
+}
+
+func (j *jobs) finish(id int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.running, id)
+}
+
+func (s *Server) backfillRunner() *backfill.Runner {
+	return &backfill.Runner{
+		Store:       ingestStore{Storer: s.db, s: s},
+		Fetcher:     s.client,
+		Symbols:     s.cfg.Currencies,
+		Workers:     s.cfg.BackfillWorkers,
+		MaxRequests: s.cfg.BackfillMaxRequests,
+	}
+}
+
+// runBackfill runs the backfill job unless it is running already
+func (s *Server) runBackfill(id int64) error {
+	if !s.jobs.start(id) {
+		return nil
+	}
+	defer s.jobs.finish(id)
+
+	err := s.backfillRunner().Run(s.ctx, id)
+	if err != nil {
+		log.Printf("[WARN] backfill job %d stopped: %v", id, err)
+	}
+	return err
+}
+
+// resumeBackfills resumes backfill jobs interrupted by a restart or paused by the quota
+func (s *Server) resumeBackfills() {
+	ids, err := s.db.UnfinishedJobs(s.ctx)
+	if err != nil {
+		log.Printf("[ERROR] failed to read unfinished backfill jobs: %v", err)
+		return
+	}
+	for _, id := range ids {
+		log.Printf("[INFO] resuming backfill job %d", id)
+		go s.runBackfill(id)
+	}
+}
+
+// maxBackfillDays limits the period of a backfill job, every day is an upstream request
+const maxBackfillDays = 366
+
+// parseDateRange parses and validates the backfill date range
+func parseDateRange(v *validator.Validator, startStr, endStr string) (start, end time.Time) {
+	start, err := time.Parse("2006-01-02", startStr)
+	v.CheckCode(err == nil, "start", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	end, err = time.Parse("2006-01-02", endStr)
+	v.CheckCode(err == nil, "end", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	if v.Valid() {
+		v.CheckCode(!start.After(end), "start", CodeInvalidDate, "start should not be after end")
+		v.CheckCode(end.Before(time.Now()), "end", CodeInvalidDate, "end should be in the past")
+		v.CheckCode(end.Sub(start) < maxBackfillDays*24*time.Hour, "end", CodeInvalidDate, fmt.Sprintf("period should not exceed %d days", maxBackfillDays))
+	}
+	return start, end
+}
+
+// CreateBackfill creates a backfill job for the date range and starts it
+// POST /v1/admin/backfill {"start": "2024-01-01", "end": "2024-01-31"}
+func (s *Server) CreateBackfill(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	req := struct {
+		Start string `json:"start"`
+		End   string `json:"end"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.fail(w, r, http.StatusBadRequest, CodeInvalidBody, "invalid request body: "+err.Error())
+		return
+	}
+
+	valid := validator.New()
+	start, end := parseDateRange(valid, req.Start, req.End)
+	if !valid.Valid() {
+		s.failValidation(w, r, valid)
+		return
+	}
+
+	job, err := s.db.CreateJob(r.Context(), start, end)
+	if err != nil {
+		s.failInternal(w, r, "failed to create backfill job", err)
+		return
+	}
+	go s.runBackfill(job.ID)
+
+	err = s.respond(w, r, http.StatusAccepted, job)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
+
+// Backfill returns the progress of the backfill job
+// GET /v1/admin/backfill/:id
+func (s *Server) Backfill(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		s.fail(w, r, http.StatusBadRequest, CodeInvalidRequest, "invalid job id")
+		return
+	}
+
+	job, err := s.db.Job(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		s.fail(w, r, http.StatusNotFound, CodeNotFound, "backfill job not found")
+		return
+	} else if err != nil {
+		s.failInternal(w, r, "failed to read backfill job", err)
+		return
+	}
+
+	err = s.respond(w, r, http.StatusOK, job)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
+
+// ResumeBackfill restarts a paused backfill job or retries the failed days of a finished one,
+// other jobs are running or have nothing to resume
+// POST /v1/admin/backfill/:id/resume
+func (s *Server) ResumeBackfill(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		s.fail(w, r, http.StatusBadRequest, CodeInvalidRequest, "invalid job id")
+		return
+	}
+
+	job, err := s.db.Job(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		s.fail(w, r, http.StatusNotFound, CodeNotFound, "backfill job not found")
+		return
+	} else if err != nil {
+		s.failInternal(w, r, "failed to read backfill job", err)
+		return
+	}
+	switch {
+	case job.Status == data.JobPaused:
+	case job.Status == data.JobDone && job.Failed > 0:
+		if err = s.db.RetryFailedDays(r.Context(), job.ID); err != nil {
+			s.failInternal(w, r, "failed to retry backfill job", err)
+			return
+		}
+		if job, err = s.db.Job(r.Context(), job.ID); err != nil {
+			s.failInternal(w, r, "failed to read backfill job", err)
+			return
+		}
+	default:
+		s.fail(w, r, http.StatusConflict, CodeConflict, "backfill job is "+job.Status+", only paused jobs and jobs with failed days can be resumed")
+		return
+	}
+	go s.runBackfill(job.ID)
+
+	err = s.respond(w, r, http.StatusAccepted, job)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Admin(t *testing.T) {
+	db, err := store.NewSQLite(context.Background(), ":memory:")
+	assert.Nil(t, err)
+
+	s := NewServer(Options{}, db, context.Background())
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/admin/backfill/1", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code, "admin endpoints are disabled without a key")
+
+	s = NewServer(Options{AdminKey: "secret"}, db, context.Background())
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v1/admin/backfill/1", nil)
+	r.Header.Set("X-Api-Key", "wrong")
+	s.router().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	r.Header.Set("X-Api-Key", "secret")
+	s.router().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestServer_Backfill(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+
+	var failing atomic.Bool
+	failing.Store(true)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() && r.URL.Query().Get("date") == "2024-02-03" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"date":"` + r.URL.Query().Get("date") + ` 00:00:00+00","base":"USD","rates":{"UAH":"40","EUR":"0.9"}}`))
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{AdminKey: "secret", Currencies: "UAH,EUR", BackfillWorkers: 2}, db, ctx)
+	s.client.ApiUrl["historical"] = upstream.URL
+
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("X-Api-Key", "secret")
+		s.router().ServeHTTP(w, r)
+		return w
+	}
+
+	// validation
+	w := request(http.MethodPost, "/v1/admin/backfill", `{"start":"2024-02-10","end":"2024-02-01"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "start should not be after end")
+	w = request(http.MethodPost, "/v1/admin/backfill", `{"start":"2024-02-01","end":"tomorrow"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid date format")
+	w = request(http.MethodPost, "/v1/admin/backfill", `{"start":"2020-01-01","end":"2024-02-10"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "period should not exceed 366 days")
+
+	w = request(http.MethodPost, "/v1/admin/backfill", `{"start":"2024-02-01","end":"2024-02-10"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	job := data.Job{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &job))
+	assert.Equal(t, 10, job.Pending)
+
+	assert.Eventually(t, func() bool {
+		w := request(http.MethodGet, "/v1/admin/backfill/1", "")
+		job = data.Job{}
+		json.Unmarshal(w.Body.Bytes(), &job)
+		return job.Status == data.JobDone
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 9, job.Done)
+	assert.Equal(t, 1, job.Failed)
+
+	// failed days are retried on resume
+	failing.Store(false)
+	w = request(http.MethodPost, "/v1/admin/backfill/1/resume", "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Eventually(t, func() bool {
+		w := request(http.MethodGet, "/v1/admin/backfill/1", "")
+		job = data.Job{}
+		json.Unmarshal(w.Body.Bytes(), &job)
+		return job.Status == data.JobDone && job.Pending == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 10, job.Done)
+	assert.Equal(t, 0, job.Failed)
+
+	// nothing to resume
+	w = request(http.MethodPost, "/v1/admin/backfill/1/resume", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"conflict"`)
+
+	rates, err := db.Read(ctx, time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, data.FloatRate(40), rates.Rates["UAH"])
+}
@@ -1,15 +1,17 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/parmaster/currency-api/internal/client"
+	"github.com/parmaster/currency-api/internal/currency"
 	"github.com/parmaster/currency-api/internal/data"
 	"github.com/parmaster/currency-api/internal/store"
 	"github.com/parmaster/currency-api/internal/validator"
@@ -18,24 +20,96 @@ import (
 func (s *Server) router() http.Handler {
 
 	router := httprouter.New()
+	router.NotFound = http.HandlerFunc(s.notFound)
+	router.MethodNotAllowed = http.HandlerFunc(s.methodNotAllowed)
+	router.PanicHandler = s.panicHandler
 	router.GET("/", s.Index)
 	router.GET("/v1/status", s.Status)
+	router.GET("/healthz", s.Healthz)
+	router.GET("/readyz", s.Readyz)
+	router.GET("/v1/openapi.json", s.OpenAPI)
+	router.GET("/docs", s.Docs)
+
+	// requests to the metered routes are limited by the tenant of the API key and logged with the route
+	metered := func(method, path string, h httprouter.Handle) {
+		router.Handle(method, path, withRoute(method+" "+path, s.tenant(h)))
+	}
 
-	router.GET("/v1/rates", s.Rates)
+	metered(http.MethodGet, "/v1/rates", s.Rates)
 	// date format: 2006-02-01
-	router.GET("/v1/rates/:date", s.Rates)
+	metered(http.MethodGet, "/v1/rates/:date", s.Rates)
 
 	// pair format: USD-UAH (1 USD = x UAH)
-	router.GET("/v1/pair/:pair", s.Pair)
+	metered(http.MethodGet, "/v1/pair/:pair", s.Pair)
+	metered(http.MethodGet, "/v1/stats/:pair", s.Stats)
+	metered(http.MethodGet, "/v1/ohlc/:pair", s.OHLC)
+	metered(http.MethodPost, "/v1/batch", s.Batch)
+
+	metered(http.MethodGet, "/v1/currencies", s.Currencies)
+
+	metered(http.MethodGet, "/v1/graphql", s.GraphQL)
+	metered(http.MethodPost, "/v1/graphql", s.GraphQL)
+
+	metered(http.MethodGet, "/v1/stream", s.Stream)
+	metered(http.MethodGet, "/v1/ws", s.WebSocket)
+
+	metered(http.MethodGet, "/v1/export", s.Export)
+	router.POST("/v1/import", s.admin(s.Import))
+
+	router.POST("/v1/subscriptions", s.admin(s.CreateSubscription))
+	router.GET("/v1/subscriptions", s.admin(s.Subscriptions))
+	router.GET("/v1/subscriptions/:id", s.admin(s.Subscription))
+	router.PUT("/v1/subscriptions/:id", s.admin(s.UpdateSubscription))
+	router.DELETE("/v1/subscriptions/:id", s.admin(s.DeleteSubscription))
+	router.GET("/v1/subscriptions/:id/deliveries", s.admin(s.Deliveries))
 
-	return router
+	router.POST("/v1/admin/backfill", s.admin(s.CreateBackfill))
+	router.GET("/v1/admin/backfill/:id", s.admin(s.Backfill))
+	router.POST("/v1/admin/backfill/:id/resume", s.admin(s.ResumeBackfill))
+
+	router.GET("/v1/admin/usage", s.admin(s.Usage))
+
+	timeout := withTimeout(time.Duration(s.cfg.Timeout)*time.Second, router)
+	return compress(s.cfg.CompressMin, withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamPaths[r.URL.Path] {
+			router.ServeHTTP(w, r)
+			return
+		}
+		timeout.ServeHTTP(w, r)
+	})))
+}
+
+// withTimeout sets the deadline of the request context, so database and upstream
+// requests of a slow request are canceled before the server write timeout
+func withTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
 
 type StatusResponse struct {
-	Status  string   `json:"status"`
-	Version string   `json:"version"`
-	Config  Options  `json:"config"`
-	Logs    []string `json:"logs"`
+	Status     string         `json:"status"`
+	Version    string         `json:"version"`
+	Config     Options        `json:"config"`
+	Logs       []string       `json:"logs"`
+	Quarantine []data.Anomaly `json:"quarantine"`
+}
+
+// statusQuarantine is the number of recently quarantined rates shown in the status
+const statusQuarantine = 10
+
+// MarshalCSV returns the recent logs as a table
+func (s StatusResponse) MarshalCSV() [][]string {
+	res := [][]string{{"datetime", "type", "request"}}
+	for _, l := range s.Logs {
+		res = append(res, strings.SplitN(l, " | ", 3))
+	}
+	return res
 }
 
 func (s *Server) Status(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -45,15 +119,20 @@ func (s *Server) Status(w http.ResponseWriter, r *http.Request, _ httprouter.Par
 		Config:  s.cfg,
 	}
 	var err error
-	status.Logs, err = s.db.ReadLogs()
+	status.Logs, err = s.db.ReadLogs(r.Context())
+	if err != nil {
+		s.failInternal(w, r, "failed to read logs", err)
+		return
+	}
+	status.Quarantine, err = s.db.Quarantined(r.Context(), statusQuarantine)
 	if err != nil {
-		http.Error(w, "failed to read logs: "+err.Error(), http.StatusInternalServerError)
+		s.failInternal(w, r, "failed to read quarantined rates", err)
 		return
 	}
 
-	err = s.writeJSON(w, http.StatusOK, status, nil)
+	err = s.respond(w, r, http.StatusOK, status)
 	if err != nil {
-		http.Error(w, "failed to write response: "+err.Error(), http.StatusInternalServerError)
+		s.failInternal(w, r, "failed to write response", err)
 	}
 }
 
@@ -61,78 +140,124 @@ func (s *Server) Index(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 	w.Write([]byte("Welcome!\n"))
 }
 
-// Rates returns exchange rates, stored in the database
-// GET /v1/rates[/date]
+// Rates returns exchange rates, stored in the database,
+// optionally filtered by the comma-separated list of symbols. Rates of a tenant
+// are limited to its currencies and relative to its default base
+// GET /v1/rates[/date][?symbols=EUR,UAH]
 func (s *Server) Rates(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 	dateStr := ps.ByName("date")
 	date, err := time.Parse("2006-01-02", dateStr)
 
 	validator := validator.New()
-	validator.Check(dateStr == "" || err == nil, "date", "invalid date format, use 2006-01-02")
+	validator.CheckCode(dateStr == "" || err == nil, "date", CodeInvalidDate, "invalid date format, use 2006-01-02")
+
+	symbols := splitSymbols(r.URL.Query().Get("symbols"))
+	for _, symbol := range symbols {
+		s.checkCurrency(r.Context(), validator, "symbols."+symbol, symbol)
+	}
 
 	if !validator.Valid() {
-		errors := struct {
-			Error   string            `json:"error"`
-			Message map[string]string `json:"message"`
-		}{Error: "validation errors", Message: validator.Errors}
-		s.writeJSON(w, http.StatusBadRequest, errors, nil)
+		s.failValidation(w, r, validator)
 		return
 	}
 
-	err = s.db.Log("rates", fmt.Sprintf("date: %s", dateStr))
-	if err != nil {
-		log.Printf("[ERROR] failed to log request: %v", err)
-	}
+	s.logRequest(r.Context(), "rates", fmt.Sprintf("date: %s, symbols: %s", dateStr, strings.Join(symbols, ",")))
 
-	rates, err := s.GetUpdateRates(date)
-	if err != nil && err != ErrNoContent {
+	rates, err := s.GetUpdateRates(r.Context(), date)
+	if err != nil && err != ErrNoContent && r.Context().Err() == nil {
 		date = time.Now().AddDate(0, 0, -1)
-		rates, err = s.GetUpdateRates(date)
+		rates, err = s.GetUpdateRates(r.Context(), date)
 	}
-	if err != nil && errors.Is(err, ErrNoContent) {
-		http.Error(w, "no rates available", http.StatusNotFound)
+	if err != nil {
+		s.failRates(w, r, err)
 		return
-	} else if err != nil {
-		http.Error(w, "failed to get rates: "+err.Error(), http.StatusInternalServerError)
+	}
+
+	rates, err = s.scopeRates(r.Context(), rates, symbols)
+	if err != nil {
+		s.fail(w, r, http.StatusNotFound, CodeRatesUnavailable, "no rates available for the requested symbols")
 		return
 	}
 
-	err = s.writeJSON(w, http.StatusOK, rates, nil)
+	err = s.respond(w, r, http.StatusOK, rates)
 	if err != nil {
-		http.Error(w, "failed to write response: "+err.Error(), http.StatusInternalServerError)
+		s.failInternal(w, r, "failed to write response", err)
 	}
 
 }
 
 var ErrNoContent = errors.New("no rates available")
 
-func (s *Server) GetUpdateRates(date time.Time) (data.Rates, error) {
+// splitSymbols splits the comma-separated list of symbols, symbols are trimmed and empty ones are skipped,
+// e.g. of a trailing comma. Returns nil if there are no symbols
+func splitSymbols(list string) []string {
+	var res []string
+	for _, symbol := range strings.Split(list, ",") {
+		if symbol = strings.TrimSpace(symbol); symbol != "" {
+			res = append(res, symbol)
+		}
+	}
+	return res
+}
+
+// checkCurrency validates that the code is a known ISO 4217 code
+// and the currency is enabled in the configuration and for the tenant of the request
+func (s *Server) checkCurrency(ctx context.Context, v *validator.Validator, key, code string) {
+	if !currency.Known(code) {
+		v.AddErrorCode(key, CodeUnknownCurrency, "unknown currency code: "+code)
+		return
+	}
+	enabled, use := validator.PermittedValue(code, s.currencies...) || s.onDemandAllowed(code), s.cfg.Currencies
+	if t, ok := tenantFrom(ctx); ok && len(t.Currencies) > 0 {
+		enabled, use = enabled && t.Allowed(code), strings.Join(t.Currencies, ",")
+	}
+	v.CheckCode(enabled, key, CodeUnsupportedCurrency, "currency is not enabled: "+code+", use these: "+use)
+}
+
+func (s *Server) GetUpdateRates(ctx context.Context, date time.Time) (data.Rates, error) {
 	// prefer data from the database
 	var rates data.Rates
 	var err error
 	if date.IsZero() {
-		rates, err = s.db.Read(time.Now())
+		rates, err = s.db.Read(ctx, time.Now())
 	} else {
-		rates, err = s.db.Read(date)
+		rates, err = s.db.Read(ctx, date)
+	}
+	if err == store.ErrNotFound && !date.IsZero() {
+		// historical rates of requests over their fetch limit are read from the database only
+		if err := checkFetch(ctx, date); err != nil {
+			return data.Rates{}, err
+		}
 	}
 	if err == store.ErrNotFound {
 		// if not found, use the API
-		client := client.New(s.cfg.ApiKey)
+		symbols := strings.Join(append(s.currencies, s.dynamic.list()...), ",")
+		uctx, cancel := s.upstreamContext(ctx)
 		if date.IsZero() {
-			rates, err = client.GetLatest(s.cfg.Currencies)
+			rates, err = s.client.GetLatest(uctx, symbols)
 		} else {
-			rates, err = client.GetHistorical(s.cfg.Currencies, date)
+			rates, err = s.client.GetHistorical(uctx, symbols, date)
+		}
+		cancel()
+		if ctx.Err() != nil {
+			// the request is gone, not a failure of the upstream
+			return data.Rates{}, ctx.Err()
 		}
+		s.upstream.record(err)
 		if err == nil {
-			// and store in the database
-			err = s.db.Write(rates)
+			// and store in the database, even if the request is canceled meanwhile
+			if date.IsZero() {
+				rates, err = s.storeLatest(context.WithoutCancel(ctx), rates)
+			} else {
+				rates, err = s.ingest(context.WithoutCancel(ctx), rates)
+			}
 			if err != nil {
 				log.Printf("[ERROR] failed to write rates: %v", err)
 			}
 		} else {
 			log.Printf("[ERROR] failed to get rates: %v", err)
-			return data.Rates{}, err
+			return data.Rates{}, fmt.Errorf("%w: %w", ErrUpstream, err)
 		}
 	} else if err != nil {
 		log.Printf("[ERROR] failed to read rates: %v", err)
@@ -140,65 +265,149 @@ func (s *Server) GetUpdateRates(date time.Time) (data.Rates, error) {
 	}
 	// historical data can be unavailable - /historical endpoint requires a paid subscription
 
+	// never serve values which are not positive finite numbers, even if stored before the checks
+	rates = rates.Valid()
 	if len(rates.Rates) == 0 {
 		return data.Rates{}, ErrNoContent
 	}
 	return rates, nil
 }
 
-func (s *Server) Pair(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	pair := strings.Split(ps.ByName("pair"), "-")
-
-	valid := validator.New()
+// checkPair validates the pair in the USD-UAH format and returns its currencies
+func (s *Server) checkPair(ctx context.Context, v *validator.Validator, pairStr string) []string {
+	pair := strings.Split(pairStr, "-")
 
 	// basic validation
-	validPair := len(pair) == 2 && len(pair[0]) == 3 && len(pair[1]) == 3
-	valid.Check(validPair, "pair", "invalid pair format, use USD-UAH")
+	validPair := len(pair) == 2
+	v.CheckCode(validPair, "pair", CodeInvalidPair, "invalid pair format, use USD-UAH")
+
+	// check if both currencies are known and enabled
+	if validPair {
+		s.checkCurrency(ctx, v, "pair", pair[0])
+		s.checkCurrency(ctx, v, "pair", pair[1])
+	}
+	return pair
+}
+
+// crossRate returns the rate of the pair: 1 from = rate to, reports false
+// if either rate is missing or the result is not a positive finite number
+func crossRate(rates map[string]data.FloatRate, from, to string) (data.FloatRate, bool) {
+	rate := rates[to] / rates[from]
+	return rate, rates[from].Valid() && rates[to].Valid() && rate.Valid()
+}
 
-	// check if the pair is in the list of supported currencies
-	permittedValue := validator.PermittedValue(pair[0], strings.Split(s.cfg.Currencies, ",")...) &&
-		validator.PermittedValue(pair[1], strings.Split(s.cfg.Currencies, ",")...)
-	valid.Check(permittedValue, "pair", "invalid currency, use these: "+s.cfg.Currencies)
+// ErrNoPairRate is returned when the rates of either currency of the pair are missing
+var ErrNoPairRate = errors.New("no rates available for the pair")
 
-	if !valid.Valid() {
-		errors := struct {
-			Error   string            `json:"error"`
-			Message map[string]string `json:"message"`
-		}{Error: "validation errors", Message: valid.Errors}
-		s.writeJSON(w, http.StatusBadRequest, errors, nil)
-		return
+// latestRates returns today's rates, yesterday's if today's rates are not available
+func (s *Server) latestRates(ctx context.Context) (data.Rates, error) {
+	rates, err := s.GetUpdateRates(ctx, time.Time{})
+	if err != nil && err != ErrNoContent && ctx.Err() == nil {
+		rates, err = s.GetUpdateRates(ctx, time.Now().AddDate(0, 0, -1))
 	}
+	return rates, err
+}
 
-	err := s.db.Log("pair", fmt.Sprintf("pair: %s", strings.Join(pair, "-")))
-	if err != nil {
-		log.Printf("[ERROR] failed to log request: %v", err)
+// pairRate returns the latest rate of the pair
+func (s *Server) pairRate(ctx context.Context, pair []string) (data.PairResponse, error) {
+	return s.pairOn(ctx, pair, time.Time{})
+}
+
+// ratesOn returns the rates of the date, the latest rates if the date is zero
+func (s *Server) ratesOn(ctx context.Context, date time.Time) (data.Rates, error) {
+	if date.IsZero() {
+		return s.latestRates(ctx)
 	}
+	return s.GetUpdateRates(ctx, date)
+}
 
-	date := time.Time{}
-	rates, err := s.GetUpdateRates(date)
-	if err != nil && err != ErrNoContent {
-		date = time.Now().AddDate(0, 0, -1)
-		rates, err = s.GetUpdateRates(date)
+// pairOn returns the rate of the validated pair on the date
+func (s *Server) pairOn(ctx context.Context, pair []string, date time.Time) (data.PairResponse, error) {
+	rates, err := s.ratesOn(ctx, date)
+	if err != nil {
+		return data.PairResponse{}, err
 	}
-	if err != nil && errors.Is(err, ErrNoContent) {
-		http.Error(w, "no rates available", http.StatusNotFound)
-		return
-	} else if err != nil {
-		http.Error(w, "failed to get rates: "+err.Error(), http.StatusInternalServerError)
-		return
+	rates = s.fetchOnDemand(ctx, rates, pair)
+	res, ok := pairResponse(rates, pair)
+	if !ok {
+		return res, ErrNoPairRate
 	}
+	return res, nil
+}
 
-	rate := data.FloatRate(rates.Rates[pair[1]] / rates.Rates[pair[0]])
-
-	pairResponse := data.PairResponse{
+// pairResponse returns the rate of the pair, reports false if it is not available
+func pairResponse(rates data.Rates, pair []string) (data.PairResponse, bool) {
+	rate, ok := crossRate(rates.Rates, pair[0], pair[1])
+	return data.PairResponse{
 		Date: rates.Date.String(),
 		Pair: strings.Join(pair, "-"),
 		Rate: rate,
+	}, ok
+}
+
+func (s *Server) Pair(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	valid := validator.New()
+	pair := s.checkPair(r.Context(), valid, ps.ByName("pair"))
+	if !valid.Valid() {
+		s.failValidation(w, r, valid)
+		return
+	}
+
+	s.logRequest(r.Context(), "pair", fmt.Sprintf("pair: %s", strings.Join(pair, "-")))
+
+	pairResponse, err := s.pairRate(r.Context(), pair)
+	if errors.Is(err, ErrNoPairRate) {
+		s.fail(w, r, http.StatusNotFound, CodeRatesUnavailable, "no rates available for the pair")
+		return
+	}
+	if err != nil {
+		s.failRates(w, r, err)
+		return
 	}
 
-	err = s.writeJSON(w, http.StatusOK, pairResponse, nil)
+	err = s.respond(w, r, http.StatusOK, pairResponse)
 	if err != nil {
-		http.Error(w, "failed to write response: "+err.Error(), http.StatusInternalServerError)
+		s.failInternal(w, r, "failed to write response", err)
 	}
 
 }
+
+// CurrencyResponse is a currency catalog entry
+type CurrencyResponse struct {
+	currency.Currency
+	Enabled bool `json:"enabled"`
+}
+
+type CurrencyList []CurrencyResponse
+
+// MarshalCSV returns the currencies as a table
+func (l CurrencyList) MarshalCSV() [][]string {
+	res := [][]string{{"code", "name", "numeric", "minor_units", "symbol", "enabled"}}
+	for _, c := range l {
+		res = append(res, []string{c.Code, c.Name, c.Numeric, strconv.Itoa(c.MinorUnits), c.Symbol, strconv.FormatBool(c.Enabled)})
+	}
+	return res
+}
+
+// Currencies returns metadata of the enabled currencies, limited to the currencies
+// of the tenant of the request, or of all known ISO 4217 currencies with ?all=true
+// GET /v1/currencies
+func (s *Server) Currencies(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	all := r.URL.Query().Get("all") == "true"
+	t, _ := tenantFrom(r.Context())
+
+	s.logRequest(r.Context(), "currencies", fmt.Sprintf("all: %t", all))
+
+	res := CurrencyList{}
+	for _, c := range currency.All() {
+		enabled := validator.PermittedValue(c.Code, s.currencies...) && t.Allowed(c.Code)
+		if enabled || all {
+			res = append(res, CurrencyResponse{Currency: c, Enabled: enabled})
+		}
+	}
+
+	err := s.respond(w, r, http.StatusOK, res)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
@@ -55,6 +55,7 @@ func getApiKey() string {
 func TestServer_Rates(t *testing.T) {
 	db, err := store.NewSQLite(context.Background(), fmt.Sprintf("file:%s/test.db?cache=shared&mode=rwc", os.TempDir()))
 	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(context.Background(), store.DemoData))
 
 	apiKey := getApiKey()
 	if apiKey == "" {
@@ -112,3 +113,138 @@ func Test_ServerPairs(t *testing.T) {
 	assert.Equal(t, "USD-UAH", pair.Pair, "base currency should be USD")
 	assert.NotEmpty(t, pair.Rate, "rate should not be empty")
 }
+
+func TestServer_PairValidation(t *testing.T) {
+	db, err := store.NewSQLite(context.Background(), ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	s := NewServer(Options{Currencies: "USD,UAH,RON,EUR"}, db, context.Background())
+
+	tbl := []struct {
+		pair, message string
+	}{
+		{"USD", "invalid pair format, use USD-UAH"},
+		{"USD-UAH-EUR", "invalid pair format, use USD-UAH"},
+		{"USD-XYZ", "unknown currency code: XYZ"},
+		{"usd-UAH", "unknown currency code: usd"},
+		{"GBP-UAH", "currency is not enabled: GBP, use these: USD,UAH,RON,EUR"},
+	}
+	for _, tt := range tbl {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/pair/"+tt.pair, nil)
+		s.Pair(w, r, httprouter.Params{httprouter.Param{Key: "pair", Value: tt.pair}})
+		assert.Equal(t, http.StatusBadRequest, w.Code, tt.pair)
+		assert.Contains(t, w.Body.String(), tt.message, tt.pair)
+	}
+}
+
+func TestServer_Currencies(t *testing.T) {
+	db, err := store.NewSQLite(context.Background(), ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	s := NewServer(Options{Currencies: "USD,UAH"}, db, context.Background())
+
+	w := httptest.NewRecorder()
+	s.Currencies(w, httptest.NewRequest(http.MethodGet, "/v1/currencies", nil), httprouter.Params{})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resp := []CurrencyResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp, 2)
+	assert.Equal(t, "UAH", resp[0].Code)
+	assert.Equal(t, "Hryvnia", resp[0].Name)
+	assert.Equal(t, "980", resp[0].Numeric)
+	assert.True(t, resp[0].Enabled)
+	assert.Equal(t, "USD", resp[1].Code)
+
+	w = httptest.NewRecorder()
+	s.Currencies(w, httptest.NewRequest(http.MethodGet, "/v1/currencies?all=true", nil), httprouter.Params{})
+	resp = []CurrencyResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Greater(t, len(resp), 100)
+	enabled := 0
+	for _, c := range resp {
+		if c.Enabled {
+			enabled++
+		}
+	}
+	assert.Equal(t, 2, enabled)
+}
+
+func TestServer_RatesSymbols(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+	s := NewServer(Options{Currencies: "USD,UAH,RON,EUR,GBP"}, db, ctx)
+
+	rates := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/rates/2024-04-20"+query, nil)
+		s.Rates(w, r, httprouter.Params{httprouter.Param{Key: "date", Value: "2024-04-20"}})
+		return w
+	}
+
+	// filtered, empty symbols are skipped
+	for _, query := range []string{"?symbols=EUR,UAH", "?symbols=EUR,UAH,", "?symbols=,EUR,,%20UAH"} {
+		w := rates(query)
+		assert.Equal(t, http.StatusOK, w.Code, query)
+		resp := data.RateResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, map[string]data.FloatRate{"EUR": 0.8, "UAH": 39.4}, resp.Rates, query)
+	}
+
+	// partially available
+	w := rates("?symbols=EUR,GBP")
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := data.RateResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, map[string]data.FloatRate{"EUR": 0.8}, resp.Rates)
+
+	// none available
+	w = rates("?symbols=GBP")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// per-symbol validation errors
+	w = rates("?symbols=EUR,XYZ,JPY")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	errResp := ErrorResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, map[string]string{
+		"symbols.XYZ": "unknown currency code: XYZ",
+		"symbols.JPY": "currency is not enabled: JPY, use these: USD,UAH,RON,EUR,GBP",
+	}, errResp.Error.Details)
+}
+
+func TestServer_RequestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+
+	release := make(chan struct{})
+	defer close(release)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH"}, db, ctx)
+	s.client.ApiUrl["latest"] = upstream.URL
+	s.client.ApiUrl["historical"] = upstream.URL
+
+	// slow upstream is abandoned at the request deadline
+	reqCtx, reqCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer reqCancel()
+	w := httptest.NewRecorder()
+	start := time.Now()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/rates", nil).WithContext(reqCtx))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), CodeUpstreamError)
+
+	failures, _, _ := s.upstream.get()
+	assert.Equal(t, 0, failures, "canceled requests are not upstream failures")
+}
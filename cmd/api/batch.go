@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+// BatchItem is a conversion of a batch request, the latest rates are used if the date is empty,
+// the amount is 1 if omitted
+type BatchItem struct {
+	Pair   string   `json:"pair"`
+	Date   string   `json:"date,omitempty"`
+	Amount *float64 `json:"amount,omitempty"`
+}
+
+// BatchResult is the conversion of a batch item or its error. The date is the date of the rates,
+// the requested date if the item failed
+type BatchResult struct {
+	Pair   string    `json:"pair"`
+	Date   string    `json:"date,omitempty"`
+	Amount float64   `json:"amount"`
+	Rate   *float64  `json:"rate,omitempty"`
+	Result *float64  `json:"result,omitempty"`
+	Error  *APIError `json:"error,omitempty"`
+}
+
+// BatchResponse has the results in the order of the items
+type BatchResponse struct {
+	Items []BatchResult `json:"items"`
+}
+
+// MarshalCSV returns the results as a table, failed items have the error code
+func (b BatchResponse) MarshalCSV() [][]string {
+	format := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+	res := [][]string{{"pair", "date", "amount", "rate", "result", "error"}}
+	for _, item := range b.Items {
+		code := ""
+		if item.Error != nil {
+			code = item.Error.Code
+		}
+		res = append(res, []string{item.Pair, item.Date, format(&item.Amount), format(item.Rate), format(item.Result), code})
+	}
+	return res
+}
+
+// Batch converts the amounts of the items by the rates of their pairs on their dates. The rates are read
+// once per distinct date, up to BatchFetchMax historical dates missing in the database are fetched from the
+// upstream, the rest of the dates are read from the database only. Invalid items and items without rates
+// get their errors, the rest is converted
+// POST /v1/batch {"items": [{"pair": "USD-UAH", "date": "2024-04-20", "amount": 100}]}
+func (s *Server) Batch(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	req := struct {
+		Items []BatchItem `json:"items"`
+	}{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&req); err != nil {
+		s.fail(w, r, http.StatusBadRequest, CodeInvalidBody, "invalid request body: "+err.Error())
+		return
+	}
+
+	valid := validator.New()
+	valid.Check(len(req.Items) > 0, "items", "items should not be empty")
+	valid.Check(s.cfg.BatchMax <= 0 || len(req.Items) <= s.cfg.BatchMax, "items",
+		fmt.Sprintf("too many items, up to %d are allowed", s.cfg.BatchMax))
+	if !valid.Valid() {
+		s.failValidation(w, r, valid)
+		return
+	}
+
+	res := BatchResponse{Items: make([]BatchResult, len(req.Items))}
+	pairs := make([][]string, len(req.Items))
+	// indexes of the valid items by the date, the zero date is the latest
+	dates := map[time.Time][]int{}
+	for i, item := range req.Items {
+		res.Items[i] = BatchResult{Pair: item.Pair, Date: item.Date, Amount: 1}
+		if item.Amount != nil {
+			res.Items[i].Amount = *item.Amount
+		}
+
+		iv := validator.New()
+		pairs[i] = s.checkPair(r.Context(), iv, item.Pair)
+		var date time.Time
+		if item.Date != "" {
+			var err error
+			date, err = time.Parse("2006-01-02", item.Date)
+			iv.CheckCode(err == nil, "date", CodeInvalidDate, "invalid date format, use 2006-01-02")
+		}
+		amount := res.Items[i].Amount
+		iv.Check(!math.IsNaN(amount) && !math.IsInf(amount, 0), "amount", "amount should be a finite number")
+		if !iv.Valid() {
+			res.Items[i].Error = &APIError{Code: iv.Code(CodeInvalidRequest), Message: "validation errors", Details: iv.Errors}
+			continue
+		}
+		dates[date] = append(dates[date], i)
+	}
+
+	s.logRequest(r.Context(), "batch", fmt.Sprintf("items: %d, dates: %d", len(req.Items), len(dates)))
+
+	// sorted, so the dates fetched from the upstream don't depend on the map order
+	keys := make([]time.Time, 0, len(dates))
+	for date := range dates {
+		keys = append(keys, date)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Before(keys[j]) })
+	historical := 0
+	for _, date := range keys {
+		fetch := date.IsZero() || historical < s.cfg.BatchFetchMax
+		if !date.IsZero() {
+			historical++
+		}
+		s.convertBatch(r, date, dates[date], pairs, res.Items, fetch)
+	}
+
+	err := s.respond(w, r, http.StatusOK, res)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
+
+// convertBatch converts the items of the date by the rates read once for all of them.
+// Rates and on-demand currencies missing in the database are fetched from the upstream only if fetch is set
+func (s *Server) convertBatch(r *http.Request, date time.Time, items []int, pairs [][]string, results []BatchResult, fetch bool) {
+	var rates data.Rates
+	var err error
+	var apiErr APIError
+	if fetch {
+		rates, err = s.ratesOn(r.Context(), date)
+		if err != nil {
+			_, apiErr = ratesError(r.Context(), err)
+		}
+	} else {
+		rates, err = s.db.Read(r.Context(), date)
+		if rates = rates.Valid(); err == nil && len(rates.Rates) == 0 {
+			err = store.ErrNotFound
+		}
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			apiErr = APIError{Code: CodeRatesUnavailable, Message: fmt.Sprintf("no stored rates, up to %d dates of a batch are fetched from the upstream", s.cfg.BatchFetchMax)}
+		case err != nil:
+			_, apiErr = ratesError(r.Context(), err)
+		}
+	}
+	if err != nil {
+		for _, i := range items {
+			itemErr := apiErr
+			results[i].Error = &itemErr
+		}
+		return
+	}
+
+	if fetch {
+		// currencies missing in the rates are fetched on demand at once
+		symbols := map[string]bool{}
+		for _, i := range items {
+			symbols[pairs[i][0]], symbols[pairs[i][1]] = true, true
+		}
+		list := make([]string, 0, len(symbols))
+		for symbol := range symbols {
+			list = append(list, symbol)
+		}
+		sort.Strings(list)
+		rates = s.fetchOnDemand(r.Context(), rates, list)
+	}
+
+	for _, i := range items {
+		pair, ok := pairResponse(rates, pairs[i])
+		if !ok {
+			results[i].Error = &APIError{Code: CodeRatesUnavailable, Message: "no rates available for the pair"}
+			continue
+		}
+		rate := float64(pair.Rate)
+		result := results[i].Amount * rate
+		if math.IsInf(result, 0) {
+			results[i].Error = &APIError{Code: CodeInvalidRequest, Message: "validation errors",
+				Details: map[string]string{"amount": "amount is too large, the result is not a finite number"}}
+			continue
+		}
+		results[i].Date, results[i].Rate, results[i].Result = pair.Date, &rate, &result
+	}
+}
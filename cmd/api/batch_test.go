@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// readCounter counts the reads of the rates by the date
+type readCounter struct {
+	store.Storer
+	mu    sync.Mutex
+	reads map[string]int
+}
+
+func (c *readCounter) Read(ctx context.Context, date time.Time) (data.Rates, error) {
+	c.mu.Lock()
+	c.reads[date.Format("2006-01-02")]++
+	c.mu.Unlock()
+	return c.Storer.Read(ctx, date)
+}
+
+func TestServer_Batch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var historical atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("date") != "" {
+			historical.Add(1)
+			// no historical rates beyond the stored ones
+			w.Write([]byte(`{"date":"` + r.URL.Query().Get("date") + ` 00:00:00+00","base":"USD","rates":{}}`))
+			return
+		}
+		w.Write([]byte(`{"date":"` + now.Format("2006-01-02 15:04:05") + `+00","base":"USD","rates":{"USD":"1","UAH":"40","EUR":"0.8","RON":"4.7"}}`))
+	}))
+	defer upstream.Close()
+
+	counter := &readCounter{Storer: db, reads: map[string]int{}}
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", BatchMax: 10, BatchFetchMax: 2}, counter, ctx)
+	s.client.ApiUrl["latest"] = upstream.URL
+	s.client.ApiUrl["historical"] = upstream.URL
+	ts := httptest.NewServer(s.router())
+	defer ts.Close()
+
+	post := func(url, body string) *http.Response {
+		resp, err := http.Post(ts.URL+url, "application/json", strings.NewReader(body))
+		assert.Nil(t, err)
+		return resp
+	}
+
+	resp := post("/v1/batch", `{"items": [
+		{"pair": "EUR-RON", "date": "2024-04-20", "amount": 100},
+		{"pair": "EUR-UAH", "date": "2024-04-21"},
+		{"pair": "USD-GBP", "date": "2024-04-20"},
+		{"pair": "EUR-RON", "date": "2024-04-21", "amount": 1},
+		{"pair": "EUR-UAH", "amount": 2.5},
+		{"pair": "UAH", "date": "2024-04-20"},
+		{"pair": "UAH-EUR", "date": "20.04.2024", "amount": 5},
+		{"pair": "RON-EUR", "date": "2024-04-20", "amount": 0},
+		{"pair": "EUR-RON", "date": "2024-01-01"}
+	]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	res := BatchResponse{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&res))
+	resp.Body.Close()
+
+	value := func(v float64) *float64 { return &v }
+	assert.Equal(t, []BatchResult{
+		{Pair: "EUR-RON", Date: "2024-04-20", Amount: 100, Rate: value(5.875), Result: value(587.5)},
+		{Pair: "EUR-UAH", Date: "2024-04-21", Amount: 1, Rate: value(39.5 / 0.9), Result: value(39.5 / 0.9)},
+		{Pair: "USD-GBP", Date: "2024-04-20", Amount: 1, Error: &APIError{
+			Code: CodeUnsupportedCurrency, Message: "validation errors",
+			Details: map[string]string{"pair": "currency is not enabled: GBP, use these: USD,UAH,EUR,RON"},
+		}},
+		{Pair: "EUR-RON", Date: "2024-04-21", Amount: 1, Rate: value(4.8 / 0.9), Result: value(4.8 / 0.9)},
+		{Pair: "EUR-UAH", Date: now.Format("2006-01-02"), Amount: 2.5, Rate: value(50), Result: value(125)},
+		{Pair: "UAH", Date: "2024-04-20", Amount: 1, Error: &APIError{
+			Code: CodeInvalidPair, Message: "validation errors",
+			Details: map[string]string{"pair": "invalid pair format, use USD-UAH"},
+		}},
+		{Pair: "UAH-EUR", Date: "20.04.2024", Amount: 5, Error: &APIError{
+			Code: CodeInvalidDate, Message: "validation errors",
+			Details: map[string]string{"date": "invalid date format, use 2006-01-02"},
+		}},
+		{Pair: "RON-EUR", Date: "2024-04-20", Amount: 0, Rate: value(0.8 / 4.7), Result: value(0)},
+		{Pair: "EUR-RON", Date: "2024-01-01", Amount: 1, Error: &APIError{Code: CodeRatesUnavailable, Message: "no rates available"}},
+	}, res.Items)
+	// the rates are read once per distinct date
+	assert.Equal(t, map[string]int{"2024-04-20": 1, "2024-04-21": 1, now.Format("2006-01-02"): 1, "2024-01-01": 1}, counter.reads)
+
+	// up to BatchFetchMax missing dates are fetched from the upstream, the rest is read from the database
+	historical.Store(0)
+	resp = post("/v1/batch", `{"items": [
+		{"pair": "EUR-RON", "date": "2023-01-03"},
+		{"pair": "EUR-RON", "date": "2023-01-02"},
+		{"pair": "EUR-RON", "date": "2024-04-20"},
+		{"pair": "EUR-RON", "date": "2023-01-01"}
+	]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	res = BatchResponse{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&res))
+	resp.Body.Close()
+	assert.Equal(t, int32(2), historical.Load())
+	assert.Equal(t, []BatchResult{
+		{Pair: "EUR-RON", Date: "2023-01-03", Amount: 1, Error: &APIError{Code: CodeRatesUnavailable, Message: "no stored rates, up to 2 dates of a batch are fetched from the upstream"}},
+		{Pair: "EUR-RON", Date: "2023-01-02", Amount: 1, Error: &APIError{Code: CodeRatesUnavailable, Message: "no rates available"}},
+		{Pair: "EUR-RON", Date: "2024-04-20", Amount: 1, Rate: value(5.875), Result: value(5.875)},
+		{Pair: "EUR-RON", Date: "2023-01-01", Amount: 1, Error: &APIError{Code: CodeRatesUnavailable, Message: "no rates available"}},
+	}, res.Items)
+
+	// results which are not finite numbers are item errors, not failures of the batch
+	resp = post("/v1/batch", `{"items": [
+		{"pair": "EUR-RON", "date": "2024-04-20", "amount": 1e308},
+		{"pair": "EUR-RON", "date": "2024-04-20", "amount": 2}
+	]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	res = BatchResponse{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&res))
+	resp.Body.Close()
+	assert.Equal(t, []BatchResult{
+		{Pair: "EUR-RON", Date: "2024-04-20", Amount: 1e308, Error: &APIError{
+			Code: CodeInvalidRequest, Message: "validation errors",
+			Details: map[string]string{"amount": "amount is too large, the result is not a finite number"},
+		}},
+		{Pair: "EUR-RON", Date: "2024-04-20", Amount: 2, Rate: value(5.875), Result: value(11.75)},
+	}, res.Items)
+
+	// results are available as a table
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/batch?format=csv", strings.NewReader(`{"items": [{"pair": "EUR-RON", "date": "2024-04-20", "amount": 100}, {"pair": "EUR-XYZ"}]}`))
+	assert.Nil(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "pair,date,amount,rate,result,error\nEUR-RON,2024-04-20,100,5.875,587.5,\nEUR-XYZ,,1,,,unknown_currency\n", string(body))
+
+	// the batch is validated as a whole
+	tbl := []struct {
+		body string
+		code string
+	}{
+		{`{"items": []}`, CodeInvalidRequest},
+		{`{"items": [` + strings.Repeat(`{"pair": "USD-UAH"},`, 10) + `{"pair": "USD-UAH"}]}`, CodeInvalidRequest},
+		{`[{"pair": "USD-UAH"}]`, CodeInvalidBody},
+		{`{"items": [{"pair": "USD-UAH", "amount": "10"}]}`, CodeInvalidBody},
+	}
+	for _, tt := range tbl {
+		resp = post("/v1/batch", tt.body)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, tt.body)
+		errResp := ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&errResp))
+		resp.Body.Close()
+		assert.Equal(t, tt.code, errResp.Error.Code, tt.body)
+	}
+}
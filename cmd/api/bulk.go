@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/bulk"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+// maxImportSize limits the size of the import request body
+const maxImportSize = 64 << 20
+
+const (
+	// exportChunk is the number of exported rows written within exportWriteWait
+	exportChunk     = 1000
+	exportWriteWait = 30 * time.Second
+	// exportErrorTrailer marks a truncated export, the response is complete if it is not set
+	exportErrorTrailer = "X-Export-Error"
+)
+
+// Export streams stored rates for the date range, rates exported with a tenant key are limited
+// to the currencies of the tenant and relative to its default base like /v1/rates. Exports are not
+// limited by the request timeout, the write deadline is extended for every chunk of rows. A failed
+// export has the X-Export-Error trailer, and NDJSON ends with an error record
+// GET /v1/export?start=2024-01-01&end=2024-01-31&format=csv|json|ndjson
+func (s *Server) Export(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+
+	valid := validator.New()
+	var start, end time.Time
+	var err error
+	if query.Get("start") != "" {
+		start, err = time.Parse("2006-01-02", query.Get("start"))
+		valid.CheckCode(err == nil, "start", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	}
+	if query.Get("end") != "" {
+		end, err = time.Parse("2006-01-02", query.Get("end"))
+		valid.CheckCode(err == nil, "end", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = bulk.JSON
+	}
+	valid.CheckCode(validator.PermittedValue(format, bulk.Formats...), "format", CodeInvalidFormat, "invalid format, use one of: "+strings.Join(bulk.Formats, ", "))
+
+	if !valid.Valid() {
+		s.failValidation(w, r, valid)
+		return
+	}
+
+	s.logRequest(r.Context(), "export", fmt.Sprintf("start: %s, end: %s, format: %s", query.Get("start"), query.Get("end"), format))
+
+	rc := http.NewResponseController(w)
+	extendDeadline := func() {
+		if err := rc.SetWriteDeadline(time.Now().Add(exportWriteWait)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("[WARN] request %s: failed to extend write deadline: %v", requestID(r.Context()), err)
+		}
+	}
+	extendDeadline()
+	w.Header().Set("Content-Type", bulk.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="rates.%s"`, format))
+	w.Header().Set("Trailer", exportErrorTrailer)
+
+	enc := bulk.NewEncoder(w, format)
+	written := 0
+	encode := func(row data.Row) error {
+		if written++; written%exportChunk == 0 {
+			extendDeadline()
+		}
+		return enc.Encode(row)
+	}
+	symbols, base := tenantScope(r.Context(), nil)
+	if len(symbols) == 0 && base == "" {
+		err = s.db.Export(r.Context(), start, end, encode)
+	} else {
+		rs := &rowScope{symbols: symbols, base: base, next: encode}
+		if err = s.db.Export(r.Context(), start, end, rs.add); err == nil {
+			err = rs.flush()
+		}
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		// headers are sent already, the truncated response is marked by the trailer
+		log.Printf("[ERROR] request %s: failed to export rates: %v", requestID(r.Context()), err)
+		w.Header().Set(exportErrorTrailer, "export failed, the response is truncated")
+		if format == bulk.NDJSON {
+			json.NewEncoder(w).Encode(ErrorResponse{Error: APIError{Code: CodeInternalError, Message: "export failed, the response is truncated", RequestID: requestID(r.Context())}})
+		}
+	}
+}
+
+// rowScope limits the exported rows to the symbols and rebases them to the base like scoped.
+// Rows are exported in the date order, so the rows of a date are collected and scoped together
+type rowScope struct {
+	symbols []string
+	base    string
+	next    func(data.Row) error
+	date    string
+	rates   data.Rates
+}
+
+// add collects the row, the rows of the previous date are scoped and passed on
+func (rs *rowScope) add(row data.Row) error {
+	if row.Date != rs.date || row.Base != rs.rates.Base {
+		if err := rs.flush(); err != nil {
+			return err
+		}
+		rs.date, rs.rates = row.Date, data.Rates{Base: row.Base, Rates: map[string]data.FloatRate{}}
+	}
+	rs.rates.Rates[row.Currency] = row.Rate
+	return nil
+}
+
+// flush scopes the collected rows and passes them on sorted by currency, dates without
+// rates of the scope are skipped
+func (rs *rowScope) flush() error {
+	if len(rs.rates.Rates) == 0 {
+		return nil
+	}
+	rates, err := scoped(rs.rates.Valid(), rs.symbols, rs.base)
+	rs.rates.Rates = nil
+	if err != nil {
+		return nil
+	}
+	currencies := make([]string, 0, len(rates.Rates))
+	for c := range rates.Rates {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+	for _, c := range currencies {
+		if err = rs.next(data.Row{Date: rs.date, Base: rates.Base, Currency: c, Rate: rates.Rates[c]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importFormat detects the import format by the format query parameter or the content type
+func importFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return bulk.CSV
+	case "application/x-ndjson":
+		return bulk.NDJSON
+	}
+	return bulk.JSON
+}
+
+// Import validates and stores rates from the request body, invalid rows are reported per row
+// POST /v1/import?format=csv|json|ndjson
+func (s *Server) Import(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	res, err := bulk.Import(r.Context(), body, importFormat(r), s.cfg.ImportBatch, s.db)
+
+	status := http.StatusOK
+	resp := struct {
+		bulk.Result
+		Error *APIError `json:"error,omitempty"`
+	}{Result: res}
+	if err != nil {
+		resp.Error = &APIError{Code: CodeInvalidBody, Message: err.Error(), RequestID: requestID(r.Context())}
+		status = http.StatusBadRequest
+		if errors.Is(err, bulk.ErrStore) {
+			log.Printf("[ERROR] request %s: failed to import rates: %v", resp.Error.RequestID, err)
+			resp.Error.Code, resp.Error.Message = CodeInternalError, "failed to store rates"
+			status = http.StatusInternalServerError
+		}
+	}
+	log.Printf("[INFO] imported %d rows, %d invalid", res.Imported, len(res.Errors))
+
+	err = s.respond(w, r, status, resp)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
+
+// importFile imports rates from the file, the format is detected by the extension
+func (s *Server) importFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	res, err := bulk.Import(s.ctx, f, format, s.cfg.ImportBatch, s.db)
+	for _, e := range res.Errors {
+		log.Printf("[WARN] row %d: %s", e.Row, e.Error)
+	}
+	log.Printf("[INFO] imported %d rows from %s, %d invalid", res.Imported, path, len(res.Errors))
+	return err
+}
+
+// seeder stores imported rows as seeded rates
+type seeder struct {
+	db store.Storer
+}
+
+func (s seeder) Import(ctx context.Context, rows []data.Row) error {
+	return s.db.Seed(ctx, rows)
+}
+
+// seedFile seeds the database with rates from the file, the format is detected by the extension
+func seedFile(ctx context.Context, db store.Storer, path string, batch int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	res, err := bulk.Import(ctx, f, format, batch, seeder{db: db})
+	for _, e := range res.Errors {
+		log.Printf("[WARN] seed row %d: %s", e.Row, e.Error)
+	}
+	log.Printf("[INFO] seeded %d rows from %s, %d invalid", res.Imported, path, len(res.Errors))
+	return err
+}
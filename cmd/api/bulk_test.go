@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/bulk"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingExport fails the export after the first row
+type failingExport struct {
+	store.Storer
+}
+
+func (f failingExport) Export(ctx context.Context, start, end time.Time, fn func(data.Row) error) error {
+	if err := fn(data.Row{Date: "2024-04-20", Base: "USD", Currency: "EUR", Rate: 0.8}); err != nil {
+		return err
+	}
+	return errors.New("database is closed")
+}
+
+func TestServer_Export(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+	s := NewServer(Options{}, db, ctx)
+
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/export?start=2024-04-21&format=csv", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "date,base,currency,rate\n2024-04-21,USD,EUR,0.9\n2024-04-21,USD,RON,4.8\n2024-04-21,USD,UAH,39.5\n", w.Body.String())
+	assert.Equal(t, "", w.Result().Trailer.Get(exportErrorTrailer))
+
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/export?end=2024-04-20", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	rows := []data.Row{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &rows))
+	assert.Len(t, rows, 3)
+	assert.Equal(t, data.Row{Date: "2024-04-20", Base: "USD", Currency: "EUR", Rate: 0.8}, rows[0])
+
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/export?start=20240420&format=xls", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid format")
+	assert.Contains(t, w.Body.String(), "invalid date format")
+
+	// a failed export is marked by the trailer and the error record of NDJSON
+	failing := NewServer(Options{}, failingExport{db}, ctx)
+	for _, format := range []string{bulk.CSV, bulk.NDJSON} {
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/export?format="+format, nil)
+		r.Header.Set("X-Request-ID", "export-1")
+		failing.router().ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "export failed, the response is truncated", w.Result().Trailer.Get(exportErrorTrailer), format)
+	}
+	assert.Equal(t, `{"date":"2024-04-20","base":"USD","currency":"EUR","rate":0.8}`+"\n"+
+		`{"error":{"code":"internal_error","message":"export failed, the response is truncated","request_id":"export-1"}}`+"\n", w.Body.String())
+
+	// rates exported with a tenant key are limited to the currencies of the tenant and relative to its base
+	s.tenants = tenant.NewRegistry([]tenant.Tenant{
+		{Name: "billing", Key: "billing-key", Currencies: []string{"EUR", "RON", "USD"}, Base: "EUR"},
+		{Name: "reports", Key: "reports-key", Currencies: []string{"UAH"}},
+	}, db.Usage)
+	export := func(key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/export?start=2024-04-20&end=2024-04-21&format=csv", nil)
+		if key != "" {
+			r.Header.Set("X-Api-Key", key)
+		}
+		s.router().ServeHTTP(w, r)
+		return w
+	}
+	w = export("billing-key")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "date,base,currency,rate\n2024-04-20,EUR,EUR,1\n2024-04-20,EUR,RON,5.875\n2024-04-20,EUR,USD,1.25\n"+
+		"2024-04-21,EUR,EUR,1\n2024-04-21,EUR,RON,5.333333333333333\n2024-04-21,EUR,USD,1.1111111111111112\n", w.Body.String())
+	w = export("reports-key")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "date,base,currency,rate\n2024-04-20,USD,UAH,39.4\n2024-04-21,USD,UAH,39.5\n", w.Body.String())
+
+	// exports without a key are rejected if a key is required
+	s.cfg.RequireKey = true
+	assert.Equal(t, http.StatusUnauthorized, export("").Code)
+	assert.Equal(t, http.StatusUnauthorized, export("unknown").Code)
+}
+
+func TestServer_Import(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+	s := NewServer(Options{AdminKey: "secret", ImportBatch: 2}, db, ctx)
+
+	body := `date,base,currency,rate
+2024-03-01,USD,UAH,38.9
+2024-03-01,USD,EUR,0.92
+2024-03-01,USD,XYZ,1
+2024-03-02,USD,UAH,0
+2024-03-02,USD,EUR,0.93
+`
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/v1/import", strings.NewReader(body))
+	r.Header.Set("Content-Type", "text/csv")
+	s.router().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "import requires the admin key")
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/v1/import", strings.NewReader(body))
+	r.Header.Set("Content-Type", "text/csv")
+	r.Header.Set("X-Api-Key", "secret")
+	s.router().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	res := bulk.Result{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 3, res.Imported)
+	assert.Equal(t, []bulk.RowError{{Row: 3, Error: `unknown currency "XYZ"`}, {Row: 4, Error: "invalid rate 0"}}, res.Errors)
+
+	rates, err := db.Read(ctx, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]data.FloatRate{"UAH": 38.9, "EUR": 0.92}, rates.Rates)
+
+	// malformed input
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/v1/import?format=json", strings.NewReader(`{}`))
+	r.Header.Set("X-Api-Key", "secret")
+	s.router().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "json input should be an array of rows")
+
+	// from file
+	path := filepath.Join(t.TempDir(), "rates.ndjson")
+	err = os.WriteFile(path, []byte(`{"date":"2024-03-03","base":"USD","currency":"UAH","rate":39.1}`+"\n"), 0o600)
+	assert.Nil(t, err)
+	assert.Nil(t, s.importFile(path))
+	rates, err = db.Read(ctx, time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, data.FloatRate(39.1), rates.Rates["UAH"])
+}
+
+func Test_seed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+	db.ServeSeed = true
+
+	assert.Nil(t, seed(ctx, db, Options{Seed: "none"}))
+	_, err = db.LatestDate(ctx)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	assert.NotNil(t, seed(ctx, db, Options{Seed: "file"}), "seed file is required")
+
+	path := filepath.Join(t.TempDir(), "seed.csv")
+	err = os.WriteFile(path, []byte("date,base,currency,rate\n2023-12-31,USD,UAH,37.9\n"), 0o600)
+	assert.Nil(t, err)
+	assert.Nil(t, seed(ctx, db, Options{Seed: "file", SeedFile: path, ImportBatch: 10}))
+	latest, err := db.LatestDate(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "2023-12-31", latest.Format("2006-01-02"))
+
+	assert.Nil(t, seed(ctx, db, Options{Seed: "demo"}))
+	latest, err = db.LatestDate(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-04-21", latest.Format("2006-01-02"))
+
+	db.ServeSeed = false
+	_, err = db.LatestDate(ctx)
+	assert.Equal(t, store.ErrNotFound, err)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encodings supported by the compression middleware, in the order of preference
+var encodings = []string{"br", "gzip"}
+
+// acceptedEncoding returns the preferred encoding accepted by the client, empty if none.
+// "*" accepts the encodings not listed explicitly, so "*, br;q=0" doesn't accept br
+func acceptedEncoding(r *http.Request) string {
+	// listed codings, false if rejected with q=0
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		accepted[coding] = true
+		if v, ok := params["q"]; ok {
+			if q, err := strconv.ParseFloat(v, 64); err != nil || q == 0 {
+				accepted[coding] = false
+			}
+		}
+	}
+	for _, e := range encodings {
+		if ok, listed := accepted[e]; listed {
+			if ok {
+				return e
+			}
+			continue
+		}
+		if accepted["*"] {
+			return e
+		}
+	}
+	return ""
+}
+
+// compress compresses responses of at least minSize bytes with the encoding accepted by the client,
+// responses are compressed as they are written, so streaming works. Negative minSize disables compression
+func compress(minSize int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r)
+		if minSize < 0 || encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the response until it reaches minSize, then switches to compression.
+// Responses smaller than minSize are written as is
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status      int
+	buf         []byte
+	enc         io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	if cw.wroteHeader {
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start writes the headers and the buffered data, compressed if the response can be compressed
+func (cw *compressWriter) start() error {
+	h := cw.ResponseWriter.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if h.Get("Content-Encoding") == "" && bodyAllowed(cw.status) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		switch cw.encoding {
+		case "br":
+			cw.enc = brotli.NewWriter(cw.ResponseWriter)
+		default:
+			cw.enc = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.wroteHeader = true
+
+	buf := cw.buf
+	cw.buf = nil
+	if cw.enc != nil {
+		_, err := cw.enc.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flush compresses and sends the buffered data, used for streaming responses
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		if err := cw.start(); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close writes a small response uncompressed or finishes the compressed stream
+func (cw *compressWriter) Close() error {
+	if !cw.wroteHeader {
+		if cw.status == 0 {
+			// nothing was written by the handler
+			return nil
+		}
+		cw.ResponseWriter.WriteHeader(cw.status)
+		cw.wroteHeader = true
+		_, err := cw.ResponseWriter.Write(cw.buf)
+		return err
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_acceptedEncoding(t *testing.T) {
+	tbl := []struct {
+		accept, encoding string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0, gzip;q=0.5", "gzip"},
+		{"deflate", ""},
+		{"*", "br"},
+		{"*, br;q=0", "gzip"},
+		{"br;q=0, gzip;q=0, *", ""},
+		{"*;q=0, gzip", "gzip"},
+		{"identity", ""},
+	}
+	for _, tt := range tbl {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		assert.Equal(t, tt.encoding, acceptedEncoding(r), tt.accept)
+	}
+}
+
+func Test_compress(t *testing.T) {
+	large := strings.Repeat("USD,UAH,39.4\n", 200)
+	h := compress(100, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		if r.URL.Query().Get("size") == "small" {
+			w.Write([]byte("small"))
+			return
+		}
+		if r.URL.Query().Get("size") == "empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		// written in chunks smaller than the threshold
+		for i := 0; i < len(large); i += 50 {
+			w.Write([]byte(large[i:min(i+50, len(large))]))
+		}
+	}))
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	get := func(query, encoding string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+query, nil)
+		assert.Nil(t, err)
+		req.Header.Set("Accept-Encoding", encoding)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		assert.Nil(t, err)
+		return resp
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	for encoding, decode := range decoders {
+		resp := get("/", encoding)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, encoding, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+		assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+		r, err := decode(resp.Body)
+		assert.Nil(t, err)
+		body, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, large, string(body), encoding)
+		resp.Body.Close()
+	}
+
+	// not accepted
+	resp := get("/", "")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, large, string(body))
+	resp.Body.Close()
+
+	// below the threshold
+	resp = get("/?size=small", "gzip")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, "small", string(body))
+	resp.Body.Close()
+
+	// no body
+	resp = get("/?size=empty", "gzip")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	resp.Body.Close()
+}
+
+func Test_compressStreaming(t *testing.T) {
+	next := make(chan struct{})
+	h := compress(1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 2; i++ {
+			w.Write([]byte("data: tick\n\n"))
+			w.(http.Flusher).Flush()
+			<-next
+		}
+	}))
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	assert.Nil(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	// flushed events are readable before the handler returns
+	gz, err := gzip.NewReader(resp.Body)
+	assert.Nil(t, err)
+	lines := bufio.NewReader(gz)
+	for i := 0; i < 2; i++ {
+		done := make(chan string)
+		go func() {
+			line, _ := lines.ReadString('\n')
+			lines.ReadString('\n')
+			done <- line
+		}()
+		select {
+		case line := <-done:
+			assert.Equal(t, "data: tick\n", line)
+		case <-time.After(time.Second):
+			t.Fatal("event was not flushed")
+		}
+		next <- struct{}{}
+	}
+}
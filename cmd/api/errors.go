@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+// Error codes
+const (
+	CodeInvalidRequest      = "invalid_request"
+	CodeInvalidBody         = "invalid_body"
+	CodeInvalidDate         = "invalid_date"
+	CodeInvalidPair         = "invalid_pair"
+	CodeInvalidFormat       = "invalid_format"
+	CodeInvalidInterval     = "invalid_interval"
+	CodeUnknownCurrency     = "unknown_currency"
+	CodeUnsupportedCurrency = "unsupported_currency"
+	CodeRatesUnavailable    = "rates_unavailable"
+	CodeUpstreamError       = "upstream_error"
+	CodeTooManySubscribers  = "too_many_subscribers"
+	CodeInvalidQuery        = "invalid_query"
+	CodeQueryTooComplex     = "query_too_complex"
+	CodeRateLimited         = "rate_limited"
+	CodeQuotaExceeded       = "quota_exceeded"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeConflict            = "conflict"
+	CodeInternalError       = "internal_error"
+)
+
+// ErrUpstream wraps errors of the rates provider
+var ErrUpstream = errors.New("upstream error")
+
+// APIError is the body of every error response:
+//
+//	{"error": {"code": "invalid_date", "message": "validation errors", "details": {"date": "..."}, "request_id": "..."}}
+type APIError struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Details   map[string]string `json:"details,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// fail writes an error response
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	s.failDetails(w, r, status, code, message, nil)
+}
+
+func (s *Server) failDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]string) {
+	resp := ErrorResponse{Error: APIError{Code: code, Message: message, Details: details, RequestID: requestID(r.Context())}}
+	if err := s.respond(w, r, status, resp); err != nil {
+		http.Error(w, message, status)
+	}
+}
+
+// failValidation writes validation errors, per field messages are in the details
+func (s *Server) failValidation(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	s.failDetails(w, r, http.StatusBadRequest, v.Code(CodeInvalidRequest), "validation errors", v.Errors)
+}
+
+// failInternal logs the error and writes a response without its details
+func (s *Server) failInternal(w http.ResponseWriter, r *http.Request, message string, err error) {
+	log.Printf("[ERROR] request %s: %s: %v", requestID(r.Context()), message, err)
+	s.fail(w, r, http.StatusInternalServerError, CodeInternalError, message)
+}
+
+// failRates writes the error of getting the rates
+func (s *Server) failRates(w http.ResponseWriter, r *http.Request, err error) {
+	status, apiErr := ratesError(r.Context(), err)
+	s.fail(w, r, status, apiErr.Code, apiErr.Message)
+}
+
+// ratesError returns the status and the error of getting the rates, failures are logged
+func ratesError(ctx context.Context, err error) (int, APIError) {
+	switch {
+	case errors.Is(err, ErrNoContent):
+		return http.StatusNotFound, APIError{Code: CodeRatesUnavailable, Message: "no rates available"}
+	case errors.Is(err, ErrNoPairRate):
+		return http.StatusNotFound, APIError{Code: CodeRatesUnavailable, Message: "no rates available for the pair"}
+	case errors.Is(err, ErrFetchLimit):
+		return http.StatusNotFound, APIError{Code: CodeRatesUnavailable, Message: err.Error()}
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Printf("[ERROR] request %s: %v", requestID(ctx), err)
+		return http.StatusGatewayTimeout, APIError{Code: CodeUpstreamError, Message: "rates request timed out"}
+	case errors.Is(err, ErrUpstream):
+		log.Printf("[ERROR] request %s: %v", requestID(ctx), err)
+		return http.StatusBadGateway, APIError{Code: CodeUpstreamError, Message: "rates provider is unavailable"}
+	default:
+		log.Printf("[ERROR] request %s: failed to get rates: %v", requestID(ctx), err)
+		return http.StatusInternalServerError, APIError{Code: CodeInternalError, Message: "failed to get rates"}
+	}
+}
+
+func (s *Server) notFound(w http.ResponseWriter, r *http.Request) {
+	s.fail(w, r, http.StatusNotFound, CodeNotFound, "not found")
+}
+
+func (s *Server) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	s.fail(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+}
+
+func (s *Server) panicHandler(w http.ResponseWriter, r *http.Request, v any) {
+	log.Printf("[ERROR] request %s: panic: %v", requestID(r.Context()), v)
+	s.fail(w, r, http.StatusInternalServerError, CodeInternalError, "internal error")
+}
+
+type ctxKey int
+
+const requestIDKey ctxKey = iota
+
+var validRequestID = regexp.MustCompile(`^[\w\-.]{1,64}$`)
+
+// withRequestID takes the request id from the X-Request-ID header or generates a new one,
+// stores it in the request context and returns it in the response header
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = randomHex(8)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
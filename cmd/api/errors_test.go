@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Errors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "secret upstream failure", http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR", AdminKey: "secret"}, db, ctx)
+	s.client.ApiUrl["latest"] = upstream.URL
+	s.client.ApiUrl["historical"] = upstream.URL
+
+	request := func(method, url string) (*httptest.ResponseRecorder, ErrorResponse) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, nil)
+		r.Header.Set("X-Request-ID", "req-1")
+		s.router().ServeHTTP(w, r)
+		resp := ErrorResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp), url)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"), url)
+		assert.Equal(t, "req-1", w.Header().Get("X-Request-ID"), url)
+		assert.Equal(t, "req-1", resp.Error.RequestID, url)
+		return w, resp
+	}
+
+	tbl := []struct {
+		method, url string
+		status      int
+		code        string
+	}{
+		{http.MethodGet, "/v1/nothing", http.StatusNotFound, CodeNotFound},
+		{http.MethodDelete, "/v1/rates", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{http.MethodGet, "/v1/rates/2024-04", http.StatusBadRequest, CodeInvalidDate},
+		{http.MethodGet, "/v1/rates?symbols=XYZ", http.StatusBadRequest, CodeUnknownCurrency},
+		{http.MethodGet, "/v1/rates?symbols=JPY", http.StatusBadRequest, CodeUnsupportedCurrency},
+		{http.MethodGet, "/v1/rates?symbols=JPY,XYZ", http.StatusBadRequest, CodeInvalidRequest},
+		{http.MethodGet, "/v1/pair/USD", http.StatusBadRequest, CodeInvalidPair},
+		{http.MethodGet, "/v1/export?format=yaml", http.StatusBadRequest, CodeInvalidFormat},
+		{http.MethodGet, "/v1/admin/backfill/1", http.StatusUnauthorized, CodeUnauthorized},
+		{http.MethodGet, "/v1/rates", http.StatusBadGateway, CodeUpstreamError},
+	}
+	for _, tt := range tbl {
+		w, resp := request(tt.method, tt.url)
+		assert.Equal(t, tt.status, w.Code, tt.url)
+		assert.Equal(t, tt.code, resp.Error.Code, tt.url)
+		assert.NotEmpty(t, resp.Error.Message, tt.url)
+		assert.NotContains(t, w.Body.String(), "secret upstream failure", tt.url)
+	}
+
+	_, resp := request(http.MethodGet, "/v1/rates/2024-04")
+	assert.Equal(t, "validation errors", resp.Error.Message)
+	assert.Equal(t, map[string]string{"date": "invalid date format, use 2006-01-02"}, resp.Error.Details)
+}
+
+func TestServer_ErrorsPanic(t *testing.T) {
+	s := NewServer(Options{}, nil, context.Background())
+	router := httprouter.New()
+	router.PanicHandler = s.panicHandler
+	router.GET("/panic", func(http.ResponseWriter, *http.Request, httprouter.Params) {
+		panic("sql: connection string with password")
+	})
+
+	w := httptest.NewRecorder()
+	withRequestID(router).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	resp := ErrorResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CodeInternalError, resp.Error.Code)
+	assert.NotContains(t, w.Body.String(), "password")
+	assert.Len(t, resp.Error.RequestID, 16, "request id is generated")
+	assert.Equal(t, resp.Error.RequestID, w.Header().Get("X-Request-ID"))
+}
+
+func Test_withRequestID(t *testing.T) {
+	var got string
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = requestID(r.Context())
+	}))
+
+	for _, id := range []string{"abc-123", "bad id\n", ""} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Request-ID", id)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, got, w.Header().Get("X-Request-ID"))
+		if id == "abc-123" {
+			assert.Equal(t, id, got)
+		} else {
+			assert.Len(t, got, 16, "invalid ids are replaced")
+		}
+	}
+}
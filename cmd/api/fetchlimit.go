@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrFetchLimit is returned for historical rates missing in the database once the request
+// fetched its limit of dates from the upstream
+var ErrFetchLimit = errors.New("no stored rates")
+
+type fetchLimitKey struct{}
+
+// fetchLimit is the number of historical dates a request may fetch from the upstream, a date is counted once
+type fetchLimit struct {
+	mu    sync.Mutex
+	max   int
+	dates map[string]bool
+}
+
+// withFetchLimit limits the historical dates fetched from the upstream by the request of the context to max,
+// the rates of other dates are read from the database only
+func withFetchLimit(ctx context.Context, max int) context.Context {
+	return context.WithValue(ctx, fetchLimitKey{}, &fetchLimit{max: max, dates: map[string]bool{}})
+}
+
+// checkFetch counts the date against the limit of the request of the context and returns ErrFetchLimit
+// if the rates of the date may not be fetched from the upstream. Requests without a limit are not limited
+func checkFetch(ctx context.Context, date time.Time) error {
+	l, ok := ctx.Value(fetchLimitKey{}).(*fetchLimit)
+	if !ok {
+		return nil
+	}
+	day := date.Format("2006-01-02")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.dates[day] {
+		return nil
+	}
+	if len(l.dates) >= l.max {
+		return fmt.Errorf("%w, up to %d dates of a request are fetched from the upstream", ErrFetchLimit, l.max)
+	}
+	l.dates[day] = true
+	return nil
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/currency"
+	"github.com/parmaster/currency-api/internal/querylimit"
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+// graphqlMaxBody limits the size of the body of a GraphQL request
+const graphqlMaxBody = 1 << 16
+
+// graphqlRequest is a GraphQL request, the body of POST or the query parameters of GET
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// GraphQLResponse is the result of a GraphQL query, data is absent if the query was not executed
+type GraphQLResponse struct {
+	Data   any                        `json:"data,omitempty"`
+	Errors []gqlerrors.FormattedError `json:"errors,omitempty"`
+}
+
+// graphqlError is an error of a resolver, the code and the details are written in the extensions of the error
+type graphqlError struct {
+	Code    string
+	Message string
+	Details map[string]string
+}
+
+func (e graphqlError) Error() string {
+	return e.Message
+}
+
+func (e graphqlError) Extensions() map[string]any {
+	ext := map[string]any{"code": e.Code}
+	if len(e.Details) > 0 {
+		ext["details"] = e.Details
+	}
+	return ext
+}
+
+// graphqlInvalid returns the validation errors as a resolver error
+func graphqlInvalid(v *validator.Validator) error {
+	return graphqlError{Code: v.Code(CodeInvalidRequest), Message: "validation errors", Details: v.Errors}
+}
+
+// graphqlRatesError maps the error of getting the rates to a resolver error, like failRates does to a response
+func graphqlRatesError(ctx context.Context, err error) error {
+	_, apiErr := ratesError(ctx, err)
+	return graphqlError{Code: apiErr.Code, Message: apiErr.Message}
+}
+
+// GraphQL types, fields are resolved by their names
+type (
+	graphqlRates struct {
+		Date  string
+		Base  string
+		Rates []graphqlRate
+	}
+	graphqlRate struct {
+		Currency string
+		Rate     float64
+	}
+	graphqlPair struct {
+		Date string
+		Pair string
+		Rate float64
+	}
+	graphqlConversion struct {
+		Date   string
+		From   string
+		To     string
+		Amount float64
+		Rate   float64
+		Result float64
+	}
+	graphqlCurrency struct {
+		Code       string
+		Name       string
+		Numeric    string
+		MinorUnits int
+		Symbol     string
+		Enabled    bool
+	}
+)
+
+var graphqlSchema = mustGraphQLSchema()
+
+// mustGraphQLSchema builds the schema, resolvers get the server from the root value
+func mustGraphQLSchema() graphql.Schema {
+	currencyType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Currency",
+		Description: "ISO 4217 currency",
+		Fields: graphql.Fields{
+			"code":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"name":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"numeric":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"minorUnits": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"symbol":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"enabled":    &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Description: "currency is in the configured set"},
+		},
+	})
+
+	rateType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Rate",
+		Fields: graphql.Fields{
+			"currency": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"rate":     &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"info": &graphql.Field{
+				Type:        currencyType,
+				Description: "metadata of the currency",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					s := p.Info.RootValue.(*Server)
+					c, ok := currency.Lookup(p.Source.(graphqlRate).Currency)
+					if !ok {
+						return nil, nil
+					}
+					return s.graphqlCurrency(c), nil
+				},
+			},
+		},
+	})
+
+	ratesType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Rates",
+		Fields: graphql.Fields{
+			"date":  &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "time of the rates in the 2006-01-02 15:04:05+00 format"},
+			"base":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"rates": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(rateType))), Description: "rates sorted by currency"},
+		},
+	})
+
+	pairType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Pair",
+		Fields: graphql.Fields{
+			"date": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "date of the rates in the 2006-01-02 format"},
+			"pair": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"rate": &graphql.Field{Type: graphql.NewNonNull(graphql.Float), Description: "1 from = rate to"},
+		},
+	})
+
+	conversionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Conversion",
+		Fields: graphql.Fields{
+			"date":   &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "date of the rates in the 2006-01-02 format"},
+			"from":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"to":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"amount": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"rate":   &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"result": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+		},
+	})
+
+	dateArg := &graphql.ArgumentConfig{Type: graphql.String, Description: "date in the 2006-01-02 format, the latest rates if omitted"}
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"rates": &graphql.Field{
+				Type:        ratesType,
+				Description: "exchange rates of the date, optionally limited to the symbols",
+				Args: graphql.FieldConfigArgument{
+					"date":    dateArg,
+					"symbols": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+				},
+				Resolve: resolveRates,
+			},
+			"pair": &graphql.Field{
+				Type:        pairType,
+				Description: "exchange rate of the pair in the USD-UAH format",
+				Args: graphql.FieldConfigArgument{
+					"pair": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"date": dateArg,
+				},
+				Resolve: resolvePair,
+			},
+			"convert": &graphql.Field{
+				Type:        conversionType,
+				Description: "conversion of the amount by the exchange rate of the pair",
+				Args: graphql.FieldConfigArgument{
+					"from":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"to":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"amount": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Float)},
+					"date":   dateArg,
+				},
+				Resolve: resolveConvert,
+			},
+			"currencies": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(currencyType))),
+				Description: "enabled currencies, all known ISO 4217 currencies if all is true",
+				Args: graphql.FieldConfigArgument{
+					"all": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: resolveCurrencies,
+			},
+			"currency": &graphql.Field{
+				Type:        currencyType,
+				Description: "ISO 4217 currency by its code",
+				Args: graphql.FieldConfigArgument{
+					"code": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: resolveCurrency,
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		panic(fmt.Sprintf("invalid GraphQL schema: %v", err))
+	}
+	return schema
+}
+
+// graphqlDate validates the optional date argument, zero time means the latest rates
+func graphqlDate(v *validator.Validator, args map[string]any) time.Time {
+	dateStr, _ := args["date"].(string)
+	if dateStr == "" {
+		return time.Time{}
+	}
+	date, err := time.Parse("2006-01-02", dateStr)
+	v.CheckCode(err == nil, "date", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	return date
+}
+
+func (s *Server) graphqlCurrency(c currency.Currency) graphqlCurrency {
+	return graphqlCurrency{
+		Code:       c.Code,
+		Name:       c.Name,
+		Numeric:    c.Numeric,
+		MinorUnits: c.MinorUnits,
+		Symbol:     c.Symbol,
+		Enabled:    validator.PermittedValue(c.Code, s.currencies...),
+	}
+}
+
+func resolveRates(p graphql.ResolveParams) (any, error) {
+	s := p.Info.RootValue.(*Server)
+	valid := validator.New()
+	date := graphqlDate(valid, p.Args)
+	var symbols []string
+	if list, ok := p.Args["symbols"].([]any); ok {
+		for _, symbol := range list {
+			symbols = append(symbols, symbol.(string))
+			s.checkCurrency(p.Context, valid, "symbols."+symbol.(string), symbol.(string))
+		}
+	}
+	if !valid.Valid() {
+		return nil, graphqlInvalid(valid)
+	}
+
+	rates, err := s.ratesOn(p.Context, date)
+	if err != nil {
+		return nil, graphqlRatesError(p.Context, err)
+	}
+	if rates, err = s.scopeRates(p.Context, rates, symbols); err != nil {
+		return nil, graphqlError{Code: CodeRatesUnavailable, Message: "no rates available for the requested symbols"}
+	}
+
+	res := graphqlRates{Date: rates.Date.Format("2006-01-02 15:04:05+00"), Base: rates.Base, Rates: make([]graphqlRate, 0, len(rates.Rates))}
+	for c, rate := range rates.Rates {
+		res.Rates = append(res.Rates, graphqlRate{Currency: c, Rate: float64(rate)})
+	}
+	sort.Slice(res.Rates, func(i, j int) bool { return res.Rates[i].Currency < res.Rates[j].Currency })
+	return res, nil
+}
+
+func resolvePair(p graphql.ResolveParams) (any, error) {
+	s := p.Info.RootValue.(*Server)
+	valid := validator.New()
+	pair := s.checkPair(p.Context, valid, p.Args["pair"].(string))
+	date := graphqlDate(valid, p.Args)
+	if !valid.Valid() {
+		return nil, graphqlInvalid(valid)
+	}
+
+	res, err := s.pairOn(p.Context, pair, date)
+	if err != nil {
+		return nil, graphqlRatesError(p.Context, err)
+	}
+	return graphqlPair{Date: res.Date, Pair: res.Pair, Rate: float64(res.Rate)}, nil
+}
+
+func resolveConvert(p graphql.ResolveParams) (any, error) {
+	s := p.Info.RootValue.(*Server)
+	from, to, amount := p.Args["from"].(string), p.Args["to"].(string), p.Args["amount"].(float64)
+	valid := validator.New()
+	pair := s.checkPair(p.Context, valid, from+"-"+to)
+	valid.Check(!math.IsNaN(amount) && !math.IsInf(amount, 0), "amount", "amount should be a finite number")
+	date := graphqlDate(valid, p.Args)
+	if !valid.Valid() {
+		return nil, graphqlInvalid(valid)
+	}
+
+	res, err := s.pairOn(p.Context, pair, date)
+	if err != nil {
+		return nil, graphqlRatesError(p.Context, err)
+	}
+	rate := float64(res.Rate)
+	return graphqlConversion{Date: res.Date, From: from, To: to, Amount: amount, Rate: rate, Result: amount * rate}, nil
+}
+
+func resolveCurrencies(p graphql.ResolveParams) (any, error) {
+	s := p.Info.RootValue.(*Server)
+	all, _ := p.Args["all"].(bool)
+	res := []graphqlCurrency{}
+	for _, c := range currency.All() {
+		if gc := s.graphqlCurrency(c); gc.Enabled || all {
+			res = append(res, gc)
+		}
+	}
+	return res, nil
+}
+
+func resolveCurrency(p graphql.ResolveParams) (any, error) {
+	s := p.Info.RootValue.(*Server)
+	code := p.Args["code"].(string)
+	c, ok := currency.Lookup(code)
+	if !ok {
+		return nil, graphqlError{Code: CodeUnknownCurrency, Message: "unknown currency code: " + code}
+	}
+	return s.graphqlCurrency(c), nil
+}
+
+// GraphQL executes a query over rates, pairs, conversions and currencies. Queries deeper than
+// GraphQLMaxDepth or more complex than GraphQLMaxComplexity are rejected before execution. Up to
+// GraphQLFetchMax historical dates missing in the database are fetched from the upstream per query,
+// fields of the rest of the dates get rates_unavailable.
+// Errors of the query are in the errors of the response, their codes are in the extensions
+// POST /v1/graphql {"query": "{ pair(pair: \"USD-UAH\") { rate } }", "variables": {}}
+// GET /v1/graphql?query=...[&variables=...]
+func (s *Server) GraphQL(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	req := graphqlRequest{}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, graphqlMaxBody)).Decode(&req); err != nil {
+			s.fail(w, r, http.StatusBadRequest, CodeInvalidBody, "invalid request body: "+err.Error())
+			return
+		}
+	} else {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if vars := query.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				s.fail(w, r, http.StatusBadRequest, CodeInvalidRequest, "invalid variables: "+err.Error())
+				return
+			}
+		}
+	}
+	if req.Query == "" {
+		s.fail(w, r, http.StatusBadRequest, CodeInvalidRequest, "query is required")
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"})})
+	if err != nil {
+		s.failGraphQL(w, r, CodeInvalidQuery, gqlerrors.FormatError(err))
+		return
+	}
+	if res := graphql.ValidateDocument(&graphqlSchema, doc, nil); !res.IsValid {
+		s.failGraphQL(w, r, CodeInvalidQuery, res.Errors...)
+		return
+	}
+
+	cost := querylimit.Measure(&graphqlSchema, doc, req.OperationName)
+	if s.cfg.GraphQLMaxDepth > 0 && cost.Depth > s.cfg.GraphQLMaxDepth {
+		s.failGraphQL(w, r, CodeQueryTooComplex, gqlerrors.NewFormattedError(
+			fmt.Sprintf("query depth %d exceeds the limit of %d", cost.Depth, s.cfg.GraphQLMaxDepth)))
+		return
+	}
+	if s.cfg.GraphQLMaxComplexity > 0 && cost.Complexity > s.cfg.GraphQLMaxComplexity {
+		s.failGraphQL(w, r, CodeQueryTooComplex, gqlerrors.NewFormattedError(
+			fmt.Sprintf("query complexity %d exceeds the limit of %d", cost.Complexity, s.cfg.GraphQLMaxComplexity)))
+		return
+	}
+
+	s.logRequest(r.Context(), "graphql", fmt.Sprintf("operation: %s, depth: %d, complexity: %d", req.OperationName, cost.Depth, cost.Complexity))
+
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        graphqlSchema,
+		Root:          s,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       withFetchLimit(r.Context(), s.cfg.GraphQLFetchMax),
+	})
+
+	err = s.respond(w, r, http.StatusOK, GraphQLResponse{Data: result.Data, Errors: result.Errors})
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
+
+// failGraphQL writes the errors of a query which was not executed, the code is set in their extensions
+func (s *Server) failGraphQL(w http.ResponseWriter, r *http.Request, code string, errs ...gqlerrors.FormattedError) {
+	for i := range errs {
+		errs[i].Extensions = map[string]any{"code": code}
+	}
+	if err := s.respond(w, r, http.StatusBadRequest, GraphQLResponse{Errors: errs}); err != nil {
+		http.Error(w, "invalid query", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_GraphQL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var mu sync.Mutex
+	historical := map[string]bool{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("date") != "" {
+			mu.Lock()
+			historical[r.URL.Query().Get("date")] = true
+			mu.Unlock()
+			// no historical rates beyond the stored ones
+			w.Write([]byte(`{"date":"` + r.URL.Query().Get("date") + ` 00:00:00+00","base":"USD","rates":{}}`))
+			return
+		}
+		w.Write([]byte(`{"date":"` + now.Format("2006-01-02 15:04:05") + `+00","base":"USD","rates":{"USD":"1","UAH":"40","EUR":"0.8","RON":"4.7"}}`))
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", GraphQLMaxDepth: 4, GraphQLMaxComplexity: 200}, db, ctx)
+	s.client.ApiUrl["latest"] = upstream.URL
+	s.client.ApiUrl["historical"] = upstream.URL
+	ts := httptest.NewServer(s.router())
+	defer ts.Close()
+
+	post := func(query string, variables map[string]any) (int, map[string]any) {
+		body, err := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+		assert.Nil(t, err)
+		resp, err := http.Post(ts.URL+"/v1/graphql", "application/json", strings.NewReader(string(body)))
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		res := map[string]any{}
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	// several dates, pairs and currencies in a single request
+	status, res := post(`query ($symbols: [String!]) {
+		first: rates(date: "2024-04-20", symbols: $symbols) { date rates { currency rate } }
+		second: rates(date: "2024-04-21", symbols: $symbols) { rates { currency rate info { name minorUnits } } }
+		pair(pair: "EUR-RON", date: "2024-04-20") { date pair rate }
+		convert(from: "EUR", to: "UAH", amount: 10) { date from to amount rate result }
+		currencies { code enabled }
+	}`, map[string]any{"symbols": []string{"UAH", "EUR"}})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, res["errors"])
+	assert.Equal(t, map[string]any{
+		"first": map[string]any{"date": "2024-04-20 00:00:00+00", "rates": []any{
+			map[string]any{"currency": "EUR", "rate": 0.8},
+			map[string]any{"currency": "UAH", "rate": 39.4},
+		}},
+		"second": map[string]any{"rates": []any{
+			map[string]any{"currency": "EUR", "rate": 0.9, "info": map[string]any{"name": "Euro", "minorUnits": 2.0}},
+			map[string]any{"currency": "UAH", "rate": 39.5, "info": map[string]any{"name": "Hryvnia", "minorUnits": 2.0}},
+		}},
+		"pair":    map[string]any{"date": "2024-04-20", "pair": "EUR-RON", "rate": 5.875},
+		"convert": map[string]any{"date": now.Format("2006-01-02"), "from": "EUR", "to": "UAH", "amount": 10.0, "rate": 50.0, "result": 500.0},
+		"currencies": []any{
+			map[string]any{"code": "EUR", "enabled": true},
+			map[string]any{"code": "RON", "enabled": true},
+			map[string]any{"code": "UAH", "enabled": true},
+			map[string]any{"code": "USD", "enabled": true},
+		},
+	}, res["data"])
+
+	// resolver errors are reported per field with the codes in the extensions
+	status, res = post(`{
+		rates(date: "20.04.2024", symbols: ["XYZ"]) { date }
+		currency(code: "RON") { name }
+		pair(pair: "EUR-GBP") { rate }
+	}`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]any{"rates": nil, "currency": map[string]any{"name": "Romanian Leu"}, "pair": nil}, res["data"])
+	// fields are resolved in any order, errors are matched by their paths
+	extensions := map[string]any{}
+	for _, e := range res["errors"].([]any) {
+		e := e.(map[string]any)
+		extensions[e["path"].([]any)[0].(string)] = e["extensions"]
+	}
+	assert.Equal(t, map[string]any{
+		"rates": map[string]any{
+			"code": CodeInvalidRequest,
+			"details": map[string]any{
+				"date":        "invalid date format, use 2006-01-02",
+				"symbols.XYZ": "unknown currency code: XYZ",
+			},
+		},
+		"pair": map[string]any{
+			"code":    CodeUnsupportedCurrency,
+			"details": map[string]any{"pair": "currency is not enabled: GBP, use these: USD,UAH,EUR,RON"},
+		},
+	}, extensions)
+
+	// invalid and too expensive queries are not executed
+	tbl := []struct {
+		query  string
+		status int
+		code   string
+		msg    string
+	}{
+		{`{ rates { date `, http.StatusBadRequest, CodeInvalidQuery, "Syntax Error"},
+		{`{ rates { time } }`, http.StatusBadRequest, CodeInvalidQuery, `Cannot query field "time" on type "Rates".`},
+		{`{ rates { rates { info { code } } } }`, http.StatusOK, "", ""},
+		{`{ rates { rates { info { ...deep } } } } fragment deep on Currency { code }`, http.StatusOK, "", ""},
+		{`{ rates { rates { info { ... on Currency { code } } } } a: rates { rates { info { code } } } }`, http.StatusOK, "", ""},
+		{`{ rates { rates { info { code } } } } fragment deep on Currency { code }`, http.StatusBadRequest, CodeInvalidQuery, `Fragment "deep" is never used.`},
+		{`{ __schema { types { fields { name } } } }`, http.StatusOK, "", ""},
+		{`{ __schema { types { fields { type { ofType { name } } } } } }`, http.StatusBadRequest, CodeQueryTooComplex, "query depth 6 exceeds the limit of 4"},
+		{`{ a: currencies(all: true) { ...all } b: currencies(all: true) { ...all } c: currencies(all: true) { ...all } }
+			fragment all on Currency { code name numeric minorUnits symbol enabled }`, http.StatusOK, "", ""},
+		{`{ a: currencies(all: true) { ...all } b: currencies(all: true) { ...all } c: currencies(all: true) { ...all } d: currencies { code name } }
+			fragment all on Currency { code name numeric minorUnits symbol enabled }`,
+			http.StatusBadRequest, CodeQueryTooComplex, "query complexity 204 exceeds the limit of 200"},
+		{`{ rates { rates { info { code } } } pair(pair: "EUR-UAH") { pair { rate } } }`, http.StatusBadRequest, CodeInvalidQuery, `Field "pair" of type "String!" must not have a sub selection.`},
+	}
+	for _, tt := range tbl {
+		status, res = post(tt.query, nil)
+		assert.Equal(t, tt.status, status, tt.query)
+		if tt.status == http.StatusOK {
+			assert.Nil(t, res["errors"], tt.query)
+			continue
+		}
+		assert.NotContains(t, res, "data", tt.query)
+		if errs, ok := res["errors"].([]any); assert.True(t, ok, tt.query) {
+			err := errs[0].(map[string]any)
+			assert.Contains(t, err["message"], tt.msg, tt.query)
+			assert.Equal(t, map[string]any{"code": tt.code}, err["extensions"], tt.query)
+		}
+	}
+
+	// up to GraphQLFetchMax missing dates of a query are fetched from the upstream, the rest is read from the database
+	s.cfg.GraphQLFetchMax = 2
+	status, res = post(`{
+		a: pair(pair: "EUR-RON", date: "2023-01-01") { rate }
+		b: rates(date: "2023-01-02") { date }
+		c: convert(from: "EUR", to: "RON", amount: 2, date: "2023-01-03") { result }
+		d: pair(pair: "EUR-RON", date: "2023-01-01") { rate }
+		e: pair(pair: "EUR-RON", date: "2024-04-20") { rate }
+	}`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]any{"rate": 5.875}, res["data"].(map[string]any)["e"])
+	// fields are resolved in any order, so any two of the dates are fetched, a date is counted once
+	mu.Lock()
+	assert.Len(t, historical, 2)
+	dates := map[string]string{"a": "2023-01-01", "b": "2023-01-02", "c": "2023-01-03", "d": "2023-01-01"}
+	assert.Len(t, res["errors"], 4)
+	for _, e := range res["errors"].([]any) {
+		e := e.(map[string]any)
+		date := dates[e["path"].([]any)[0].(string)]
+		msg := "no stored rates, up to 2 dates of a request are fetched from the upstream"
+		if historical[date] {
+			msg = "no rates available"
+		}
+		assert.Equal(t, msg, e["message"], date)
+		assert.Equal(t, map[string]any{"code": CodeRatesUnavailable}, e["extensions"])
+	}
+	mu.Unlock()
+
+	// the depth is limited
+	s.cfg.GraphQLMaxDepth = 3
+	status, res = post(`{ rates { rates { info { code } } } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "query depth 4 exceeds the limit of 3", res["errors"].([]any)[0].(map[string]any)["message"])
+
+	// queries are accepted in the parameters of GET
+	resp, err := http.Get(ts.URL + "/v1/graphql?query=" + url.QueryEscape(`query ($code: String!) { currency(code: $code) { symbol } }`) +
+		"&variables=" + url.QueryEscape(`{"code":"UAH"}`))
+	assert.Nil(t, err)
+	res = map[string]any{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&res))
+	resp.Body.Close()
+	assert.Equal(t, map[string]any{"currency": map[string]any{"symbol": "₴"}}, res["data"])
+
+	// requests which are not GraphQL requests get an API error
+	for body, code := range map[string]string{`{"query": 1}`: CodeInvalidBody, `{}`: CodeInvalidRequest} {
+		resp, err = http.Post(ts.URL+"/v1/graphql", "application/json", strings.NewReader(body))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		errResp := ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&errResp))
+		resp.Body.Close()
+		assert.Equal(t, code, errResp.Error.Code)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/hub"
+	"github.com/parmaster/currency-api/internal/tenant"
+	"github.com/parmaster/currency-api/internal/validator"
+	"github.com/parmaster/currency-api/pkg/currencypb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// grpcServer serves the gRPC API with the logic of the REST handlers
+type grpcServer struct {
+	currencypb.UnimplementedCurrencyServiceServer
+	s *Server
+}
+
+// newGRPC returns the gRPC server, calls are limited by the tenant of the API key in the x-api-key
+// metadata and unary calls by the request timeout
+func (s *Server) newGRPC() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.grpcUnaryTenant, s.grpcTimeout),
+		grpc.StreamInterceptor(s.grpcStreamTenant),
+	)
+	currencypb.RegisterCurrencyServiceServer(srv, &grpcServer{s: s})
+	return srv
+}
+
+// grpcTimeout sets the deadline of unary calls like withTimeout does for HTTP requests
+func (s *Server) grpcTimeout(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(s.cfg.Timeout)*time.Second)
+		defer cancel()
+	}
+	return handler(ctx, req)
+}
+
+// grpcStopTimeout limits the time given to the running calls on shutdown
+const grpcStopTimeout = 5 * time.Second
+
+// listenGRPC listens on the gRPC port, so a busy port fails the startup
+func listenGRPC(port int) (net.Listener, error) {
+	lis, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on the gRPC port: %w", err)
+	}
+	return lis, nil
+}
+
+// grpcTenant resolves the x-api-key metadata of the call to its tenant like the tenant middleware does
+// for HTTP requests, returns the context with the tenant
+func (s *Server) grpcTenant(ctx context.Context) (context.Context, error) {
+	key := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get("x-api-key"); len(keys) > 0 {
+			key = keys[0]
+		}
+	}
+
+	t, ok, retry, err := s.limitTenant(ctx, key)
+	var st *status.Status
+	switch {
+	case errors.Is(err, errUnknownKey), errors.Is(err, errNoKey):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, tenant.ErrQuotaExceeded):
+		st = status.New(codes.ResourceExhausted, "monthly quota of "+strconv.FormatInt(t.MonthlyQuota, 10)+" requests exceeded")
+	case errors.Is(err, tenant.ErrRateLimited):
+		st = status.New(codes.ResourceExhausted, "rate limit exceeded, try again later")
+	case err != nil:
+		log.Printf("[ERROR] gRPC: failed to check the quota: %v", err)
+		return nil, status.Error(codes.Internal, "failed to check the quota")
+	}
+	if st != nil {
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retry)}); err == nil {
+			st = detailed
+		}
+		return nil, st.Err()
+	}
+	if ok {
+		ctx = context.WithValue(ctx, tenantKey{}, t)
+	}
+	return ctx, nil
+}
+
+func (s *Server) grpcUnaryTenant(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.grpcTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) grpcStreamTenant(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.grpcTenant(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, tenantStream{ServerStream: ss, ctx: ctx})
+}
+
+// tenantStream is the server stream with the tenant in its context
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ts tenantStream) Context() context.Context {
+	return ts.ctx
+}
+
+// serveGRPC serves the gRPC API on the listener till the server context is canceled,
+// running calls are given grpcStopTimeout to finish
+func (s *Server) serveGRPC(lis net.Listener) {
+	srv := s.newGRPC()
+
+	go func() {
+		<-s.ctx.Done()
+		log.Printf("[INFO] Terminating gRPC server")
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(grpcStopTimeout):
+			log.Printf("[WARN] gRPC calls are still running, stopping the server")
+			srv.Stop()
+		}
+	}()
+
+	log.Printf("[INFO] serving gRPC on %s", lis.Addr())
+	if err := srv.Serve(lis); err != nil {
+		log.Printf("[ERROR] gRPC server failed: %v", err)
+	}
+}
+
+// grpcInvalid returns the validation errors as InvalidArgument with the field violations in the details
+func grpcInvalid(v *validator.Validator) error {
+	keys := make([]string, 0, len(v.Errors))
+	for k := range v.Errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	br := &errdetails.BadRequest{}
+	messages := make([]string, 0, len(keys))
+	for _, k := range keys {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: k, Description: v.Errors[k]})
+		messages = append(messages, k+": "+v.Errors[k])
+	}
+	st := status.New(codes.InvalidArgument, "validation errors: "+strings.Join(messages, "; "))
+	if detailed, err := st.WithDetails(br); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// grpcError maps the error of getting the rates to a status, like failRates does to an HTTP status
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrNoContent), errors.Is(err, ErrNoPairRate):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Printf("[ERROR] gRPC: %v", err)
+		return status.Error(codes.DeadlineExceeded, "rates request timed out")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, ErrUpstream):
+		log.Printf("[ERROR] gRPC: %v", err)
+		return status.Error(codes.Unavailable, "rates provider is unavailable")
+	default:
+		log.Printf("[ERROR] gRPC: failed to get rates: %v", err)
+		return status.Error(codes.Internal, "failed to get rates")
+	}
+}
+
+func ratesMessage(rates data.Rates) *currencypb.Rates {
+	res := &currencypb.Rates{Date: rates.Date.Format("2006-01-02 15:04:05+00"), Base: rates.Base, Rates: make(map[string]float64, len(rates.Rates))}
+	for c, rate := range rates.Rates {
+		res.Rates[c] = float64(rate)
+	}
+	return res
+}
+
+func (g *grpcServer) log(ctx context.Context, request string) {
+	method, _ := grpc.Method(ctx)
+	g.s.logRequest(routeContext(ctx, "GRPC "+method), "grpc", request)
+}
+
+// GetRates returns the rates of the date, the latest if the date is empty, optionally limited to the symbols,
+// scoped to the currencies and the base of the tenant like /v1/rates
+func (g *grpcServer) GetRates(ctx context.Context, req *currencypb.GetRatesRequest) (*currencypb.Rates, error) {
+	valid := validator.New()
+	var date time.Time
+	if req.Date != "" {
+		var err error
+		date, err = time.Parse("2006-01-02", req.Date)
+		valid.CheckCode(err == nil, "date", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	}
+	for _, symbol := range req.Symbols {
+		g.s.checkCurrency(ctx, valid, "symbols."+symbol, symbol)
+	}
+	if !valid.Valid() {
+		return nil, grpcInvalid(valid)
+	}
+
+	g.log(ctx, fmt.Sprintf("GetRates date: %s, symbols: %s", req.Date, strings.Join(req.Symbols, ",")))
+
+	var rates data.Rates
+	var err error
+	if date.IsZero() {
+		rates, err = g.s.latestRates(ctx)
+	} else {
+		rates, err = g.s.GetUpdateRates(ctx, date)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	rates, err = g.s.scopeRates(ctx, rates, req.Symbols)
+	if errors.Is(err, ErrNoContent) {
+		return nil, status.Error(codes.NotFound, "no rates available for the requested symbols")
+	}
+	return ratesMessage(rates), nil
+}
+
+// GetPair returns the latest exchange rate of the pair
+func (g *grpcServer) GetPair(ctx context.Context, req *currencypb.GetPairRequest) (*currencypb.Pair, error) {
+	valid := validator.New()
+	pair := g.s.checkPair(ctx, valid, req.Pair)
+	if !valid.Valid() {
+		return nil, grpcInvalid(valid)
+	}
+
+	g.log(ctx, "GetPair pair: "+strings.Join(pair, "-"))
+
+	res, err := g.s.pairRate(ctx, pair)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &currencypb.Pair{Date: res.Date, Pair: res.Pair, Rate: float64(res.Rate)}, nil
+}
+
+// Convert converts the amount by the latest exchange rate of the pair
+func (g *grpcServer) Convert(ctx context.Context, req *currencypb.ConvertRequest) (*currencypb.Conversion, error) {
+	valid := validator.New()
+	pair := g.s.checkPair(ctx, valid, req.From+"-"+req.To)
+	valid.Check(!math.IsNaN(req.Amount) && !math.IsInf(req.Amount, 0), "amount", "amount should be a finite number")
+	if !valid.Valid() {
+		return nil, grpcInvalid(valid)
+	}
+
+	g.log(ctx, fmt.Sprintf("Convert pair: %s, amount: %v", strings.Join(pair, "-"), req.Amount))
+
+	res, err := g.s.pairRate(ctx, pair)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	rate := float64(res.Rate)
+	return &currencypb.Conversion{Date: res.Date, From: req.From, To: req.To, Amount: req.Amount, Rate: rate, Result: req.Amount * rate}, nil
+}
+
+// WatchRates streams the rates every time the latest rates are stored, optionally limited to the symbols and
+// scoped to the tenant like /v1/stream. Rates older than the last sent ones are skipped. Streams count towards
+// the limit of the stream subscribers
+func (g *grpcServer) WatchRates(req *currencypb.WatchRatesRequest, stream currencypb.CurrencyService_WatchRatesServer) error {
+	valid := validator.New()
+	for _, symbol := range req.Symbols {
+		g.s.checkCurrency(stream.Context(), valid, "symbols."+symbol, symbol)
+	}
+	if !valid.Valid() {
+		return grpcInvalid(valid)
+	}
+
+	updates, unsubscribe, err := g.s.hub.Subscribe()
+	if errors.Is(err, hub.ErrFull) {
+		return status.Error(codes.ResourceExhausted, "too many stream subscribers, try again later")
+	}
+	defer unsubscribe()
+
+	g.log(stream.Context(), "WatchRates symbols: "+strings.Join(req.Symbols, ","))
+	scope, base := tenantScope(stream.Context(), req.Symbols)
+
+	var last time.Time
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-g.s.ctx.Done():
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case rates := <-updates:
+			if !rates.Date.After(last) {
+				continue
+			}
+			if rates, err = scoped(rates, scope, base); err != nil {
+				continue
+			}
+			if err = stream.Send(ratesMessage(rates)); err != nil {
+				return err
+			}
+			last = rates.Date.Time
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/tenant"
+	"github.com/parmaster/currency-api/pkg/currencypb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer_GRPC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"date":"` + now.Format("2006-01-02 15:04:05") + `+00","base":"USD","rates":{"USD":"1","UAH":"40","EUR":"0.8","RON":"4.7"}}`))
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", StreamMax: 1}, db, ctx)
+	s.client.ApiUrl["latest"] = upstream.URL
+	s.client.ApiUrl["historical"] = upstream.URL
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := s.newGRPC()
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := currencypb.NewCurrencyServiceClient(conn)
+
+	rates, err := client.GetRates(ctx, &currencypb.GetRatesRequest{Date: "2024-04-20", Symbols: []string{"UAH", "EUR"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-04-20 00:00:00+00", rates.Date)
+	assert.Equal(t, map[string]float64{"UAH": 39.4, "EUR": 0.8}, rates.Rates)
+
+	rates, err = client.GetRates(ctx, &currencypb.GetRatesRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, now.Format("2006-01-02 15:04:05+00"), rates.Date)
+	assert.Equal(t, 40.0, rates.Rates["UAH"])
+
+	// requests are logged with their methods as routes
+	usage, err := db.UsageReport(ctx, "", time.Now(), time.Now(), data.UsageByRoute)
+	assert.Nil(t, err)
+	assert.Equal(t, []data.UsageRow{{Key: "", Route: "GRPC /currency.v1.CurrencyService/GetRates", Requests: 2}}, usage)
+
+	_, err = client.GetRates(ctx, &currencypb.GetRatesRequest{Date: "20.04.2024", Symbols: []string{"XYZ"}})
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, "validation errors: date: invalid date format, use 2006-01-02; symbols.XYZ: unknown currency code: XYZ", st.Message())
+	if assert.Len(t, st.Details(), 1) {
+		br := st.Details()[0].(*errdetails.BadRequest)
+		assert.Equal(t, "symbols.XYZ", br.FieldViolations[1].Field)
+	}
+
+	pair, err := client.GetPair(ctx, &currencypb.GetPairRequest{Pair: "USD-UAH"})
+	assert.Nil(t, err)
+	assert.Equal(t, now.Format("2006-01-02"), pair.Date)
+	assert.Equal(t, "USD-UAH", pair.Pair)
+	assert.Equal(t, 40.0, pair.Rate)
+
+	_, err = client.GetPair(ctx, &currencypb.GetPairRequest{Pair: "USD"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	conversion, err := client.Convert(ctx, &currencypb.ConvertRequest{From: "EUR", To: "UAH", Amount: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, "EUR", conversion.From)
+	assert.Equal(t, 50.0, conversion.Rate)
+	assert.Equal(t, 500.0, conversion.Result)
+
+	_, err = client.Convert(ctx, &currencypb.ConvertRequest{From: "EUR", To: "GBP", Amount: 10})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// stored rates are streamed, the second stream is over the limit
+	wctx, wcancel := context.WithCancel(ctx)
+	defer wcancel()
+	watch, err := client.WatchRates(wctx, &currencypb.WatchRatesRequest{Symbols: []string{"UAH"}})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return s.hub.Len() == 1 }, time.Second, 10*time.Millisecond)
+
+	over, err := client.WatchRates(ctx, &currencypb.WatchRatesRequest{})
+	assert.Nil(t, err)
+	_, err = over.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = s.storeLatest(ctx, data.Rates{
+		Date:  data.Date{Time: now.Add(time.Second)},
+		Base:  "USD",
+		Rates: map[string]data.FloatRate{"USD": 1, "UAH": 41, "EUR": 0.8},
+	})
+	assert.Nil(t, err)
+	rates, err = watch.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"UAH": 41}, rates.Rates)
+
+	// rates older than the last sent ones are skipped
+	s.publish(data.Rates{Date: data.Date{Time: now}, Base: "USD", Rates: map[string]data.FloatRate{"USD": 1, "UAH": 39}})
+	s.publish(data.Rates{Date: data.Date{Time: now.Add(2 * time.Second)}, Base: "USD", Rates: map[string]data.FloatRate{"USD": 1, "UAH": 42}})
+	rates, err = watch.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"UAH": 42}, rates.Rates)
+
+	wcancel()
+	_, err = watch.Recv()
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	assert.Eventually(t, func() bool { return s.hub.Len() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_GRPCTenants(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", AdminKey: "secret"}, db, ctx)
+	s.tenants = tenant.NewRegistry([]tenant.Tenant{
+		{Name: "billing", Key: "billing-key", Currencies: []string{"EUR", "RON", "USD"}, Base: "EUR", MonthlyQuota: 3},
+	}, db.Usage)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := s.newGRPC()
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := currencypb.NewCurrencyServiceClient(conn)
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "x-api-key", key)
+	}
+
+	_, err = client.GetRates(withKey("unknown"), &currencypb.GetRatesRequest{Date: "2024-04-20"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// rates of a tenant are limited to its currencies and relative to its base
+	rates, err := client.GetRates(withKey("billing-key"), &currencypb.GetRatesRequest{Date: "2024-04-20"})
+	assert.Nil(t, err)
+	assert.Equal(t, "EUR", rates.Base)
+	assert.Equal(t, map[string]float64{"EUR": 1, "RON": 5.875, "USD": 1.25}, rates.Rates)
+	_, err = client.GetRates(withKey("billing-key"), &currencypb.GetRatesRequest{Date: "2024-04-20", Symbols: []string{"UAH"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// not limited with the admin key
+	rates, err = client.GetRates(withKey("secret"), &currencypb.GetRatesRequest{Date: "2024-04-20"})
+	assert.Nil(t, err)
+	assert.Equal(t, "USD", rates.Base)
+
+	// streams are scoped and counted as well
+	wctx, wcancel := context.WithCancel(withKey("billing-key"))
+	defer wcancel()
+	watch, err := client.WatchRates(wctx, &currencypb.WatchRatesRequest{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return s.hub.Len() == 1 }, time.Second, 10*time.Millisecond)
+	_, err = s.storeLatest(ctx, data.Rates{
+		Date:  data.Date{Time: time.Date(2024, 4, 22, 0, 0, 0, 0, time.UTC)},
+		Base:  "USD",
+		Rates: map[string]data.FloatRate{"UAH": 41, "EUR": 0.8, "RON": 4},
+	})
+	assert.Nil(t, err)
+	rates, err = watch.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"EUR": 1, "RON": 5, "USD": 1.25}, rates.Rates)
+	wcancel()
+
+	// the quota of 3 requests is used
+	_, err = client.GetRates(withKey("billing-key"), &currencypb.GetRatesRequest{Date: "2024-04-21"})
+	assert.Nil(t, err)
+	_, err = client.GetRates(withKey("billing-key"), &currencypb.GetRatesRequest{Date: "2024-04-21"})
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Equal(t, "monthly quota of 3 requests exceeded", st.Message())
+	if assert.Len(t, st.Details(), 1) {
+		assert.Positive(t, st.Details()[0].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+	}
+
+	// calls without a key are rejected if a key is required
+	s.cfg.RequireKey = true
+	_, err = client.GetRates(ctx, &currencypb.GetRatesRequest{Date: "2024-04-20"})
+	st = status.Convert(err)
+	assert.Equal(t, codes.Unauthenticated, st.Code())
+	assert.Equal(t, "api key is required", st.Message())
+}
+
+func TestServer_serveGRPC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	s := NewServer(Options{}, db, ctx)
+
+	lis, err := listenGRPC(0)
+	assert.Nil(t, err)
+	// the port is busy
+	_, err = listenGRPC(lis.Addr().(*net.TCPAddr).Port)
+	assert.ErrorContains(t, err, "failed to listen on the gRPC port")
+
+	done := make(chan struct{})
+	go func() {
+		s.serveGRPC(lis)
+		close(done)
+	}()
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = currencypb.NewCurrencyServiceClient(conn).GetRates(ctx, &currencypb.GetRatesRequest{Date: "20.04.2024"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "served")
+
+	// stopped with the server context
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(grpcStopTimeout + time.Second):
+		t.Fatal("gRPC server is not stopped")
+	}
+}
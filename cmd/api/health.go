@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// upstreamState tracks the outcome of recent requests to the rates provider
+type upstreamState struct {
+	mu          sync.Mutex
+	failures    int
+	lastError   string
+	lastSuccess time.Time
+}
+
+// record stores the result of an upstream request. The error is reported by /readyz, so the
+// request URL with the provider key is dropped from it, the callers log the full error
+func (u *upstreamState) record(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err != nil {
+		u.failures++
+		u.lastError = upstreamMessage(err)
+		return
+	}
+	u.failures = 0
+	u.lastError = ""
+	u.lastSuccess = time.Now()
+}
+
+// upstreamMessage returns the error without the request URL of a failed upstream request
+func upstreamMessage(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return "rates provider unreachable: " + urlErr.Err.Error()
+	}
+	return err.Error()
+}
+
+func (u *upstreamState) get() (failures int, lastError string, lastSuccess time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.failures, u.lastError, u.lastSuccess
+}
+
+// upstreamContext limits the time of an upstream request made on behalf of ctx
+func (s *Server) upstreamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.cfg.UpstreamTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(s.cfg.UpstreamTimeout)*time.Second)
+}
+
+// Check is a result of a single readiness check
+type Check struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks,omitempty"`
+}
+
+// Healthz reports that the process is alive
+// GET /healthz
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	s.respond(w, r, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// Readyz reports whether the service can serve requests: the database is
+// reachable, stored rates are fresh enough and the upstream provider is not down
+// GET /readyz
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	checks := map[string]Check{
+		"db":        s.checkDB(r.Context()),
+		"freshness": s.checkFreshness(r.Context()),
+		"upstream":  s.checkUpstream(),
+	}
+
+	resp := HealthResponse{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, c := range checks {
+		if c.Status != "ok" {
+			resp.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	s.respond(w, r, status, resp)
+}
+
+func (s *Server) checkDB(ctx context.Context) Check {
+	if err := s.db.Ping(ctx); err != nil {
+		return Check{Status: "fail", Detail: err.Error()}
+	}
+	return Check{Status: "ok"}
+}
+
+func (s *Server) checkFreshness(ctx context.Context) Check {
+	latest, err := s.db.LatestDate(ctx)
+	if err != nil {
+		return Check{Status: "fail", Detail: "no stored rates: " + err.Error()}
+	}
+
+	age := time.Since(latest).Truncate(time.Second)
+	detail := "latest rates: " + latest.Format("2006-01-02") + ", age: " + age.String()
+	if age > time.Duration(s.cfg.Staleness)*time.Second {
+		return Check{Status: "fail", Detail: detail}
+	}
+	return Check{Status: "ok", Detail: detail}
+}
+
+func (s *Server) checkUpstream() Check {
+	failures, lastError, lastSuccess := s.upstream.get()
+	if s.cfg.MaxFails > 0 && failures >= s.cfg.MaxFails {
+		return Check{Status: "fail", Detail: lastError}
+	}
+	if lastSuccess.IsZero() {
+		return Check{Status: "ok"}
+	}
+	return Check{Status: "ok", Detail: "last success: " + lastSuccess.Format(time.RFC3339)}
+}
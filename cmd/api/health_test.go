@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Healthz(t *testing.T) {
+	db, err := store.NewSQLite(context.Background(), ":memory:")
+	assert.Nil(t, err)
+	s := NewServer(Options{}, db, context.Background())
+
+	w := httptest.NewRecorder()
+	s.Healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil), httprouter.Params{})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"ok"`)
+}
+
+func TestServer_Readyz(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+	s := NewServer(Options{Staleness: 3600 * 48, MaxFails: 2}, db, ctx)
+
+	readyz := func() (int, HealthResponse) {
+		w := httptest.NewRecorder()
+		s.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil), httprouter.Params{})
+		resp := HealthResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	// no rates stored
+	code, resp := readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", resp.Status)
+	assert.Equal(t, "ok", resp.Checks["db"].Status)
+	assert.Equal(t, "fail", resp.Checks["freshness"].Status)
+	assert.Equal(t, "ok", resp.Checks["upstream"].Status)
+
+	// fresh rates
+	err = db.Write(ctx, data.Rates{Date: data.Date{Time: time.Now()}, Base: "USD", Rates: map[string]data.FloatRate{"UAH": 39.5}})
+	assert.Nil(t, err)
+	code, resp = readyz()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Status)
+	assert.Equal(t, "ok", resp.Checks["freshness"].Status)
+
+	// upstream failures
+	s.upstream.record(errors.New("boom"))
+	code, _ = readyz()
+	assert.Equal(t, http.StatusOK, code, "single failure is tolerated")
+	s.upstream.record(errors.New("boom"))
+	code, resp = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", resp.Checks["upstream"].Status)
+	assert.Equal(t, "boom", resp.Checks["upstream"].Detail)
+
+	// the provider key in the request URL is not reported
+	s.upstream.record(&url.Error{Op: "Get", URL: "https://api.example.com/v3/latest?apikey=topsecret", Err: errors.New("connection refused")})
+	w := httptest.NewRecorder()
+	s.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil), httprouter.Params{})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotContains(t, w.Body.String(), "topsecret")
+	assert.NotContains(t, w.Body.String(), "apikey")
+	assert.Contains(t, w.Body.String(), "rates provider unreachable: connection refused")
+
+	// recovered
+	s.upstream.record(nil)
+	code, _ = readyz()
+	assert.Equal(t, http.StatusOK, code)
+
+	// database is gone
+	db.DB.Close()
+	code, resp = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", resp.Checks["db"].Status)
+}
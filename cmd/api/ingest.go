@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+)
+
+// ingest checks the rates from the upstream before they are stored: invalid rates and rates
+// moved beyond the threshold since the previous day are quarantined, the rest is written.
+// Returns the rates which passed the checks, invalid rates are dropped even if the check fails
+func (s *Server) ingest(ctx context.Context, rates data.Rates) (data.Rates, error) {
+	previous, err := s.db.PreviousRates(ctx, rates.Base, rates.Date.Time)
+	if err != nil {
+		return rates.Valid(), fmt.Errorf("failed to read previous rates: %w", err)
+	}
+
+	clean, anomalies := s.detector.Check(rates, previous)
+	if len(anomalies) > 0 {
+		for _, a := range anomalies {
+			log.Printf("[WARN] quarantined %s %s/%s rate %s: %s", a.Date, a.Base, a.Currency, a.Rate, a.Reason)
+		}
+		if err = s.db.Quarantine(ctx, anomalies); err != nil {
+			log.Printf("[ERROR] failed to quarantine rates: %v", err)
+		}
+	}
+
+	if len(clean.Rates) == 0 {
+		return clean, nil
+	}
+	return clean, s.db.Write(ctx, clean)
+}
+
+// ingestStore passes the rates written by the backfill through the ingest checks
+type ingestStore struct {
+	store.Storer
+	s *Server
+}
+
+func (is ingestStore) Write(ctx context.Context, rates data.Rates) error {
+	_, err := is.s.ingest(ctx, rates)
+	return err
+}
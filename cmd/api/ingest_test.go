@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Ingest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"date":"2024-04-22 00:00:00+00","base":"USD","rates":{"UAH":"3950","EUR":"NaN","RON":"4.9"}}`))
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", AnomalyThreshold: 0.5}, db, ctx)
+	s.client.ApiUrl["historical"] = upstream.URL
+
+	// the 100x jump and NaN are quarantined, not stored and not served
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v1/rates/2024-04-22", nil)
+	s.Rates(w, r, httprouter.Params{httprouter.Param{Key: "date", Value: "2024-04-22"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := data.RateResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, map[string]data.FloatRate{"RON": 4.9}, resp.Rates)
+
+	stored, err := db.Read(ctx, time.Date(2024, 4, 22, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]data.FloatRate{"RON": 4.9}, stored.Rates)
+
+	w = httptest.NewRecorder()
+	s.Status(w, httptest.NewRequest(http.MethodGet, "/v1/status", nil), httprouter.Params{})
+	assert.Equal(t, http.StatusOK, w.Code)
+	status := StatusResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Len(t, status.Quarantine, 2)
+	reasons := map[string]string{}
+	for _, a := range status.Quarantine {
+		reasons[a.Currency] = a.Reason
+	}
+	assert.Equal(t, map[string]string{"UAH": "change 9900.0% exceeds 50.0%", "EUR": "invalid rate"}, reasons)
+
+	// rates stored before the checks are filtered, pairs are never infinite
+	today := time.Now().Format("2006-01-02")
+	assert.Nil(t, db.Import(ctx, []data.Row{
+		{Date: today, Base: "USD", Currency: "UAH", Rate: 0},
+		{Date: today, Base: "USD", Currency: "EUR", Rate: 1e-300},
+		{Date: today, Base: "USD", Currency: "RON", Rate: 1e300},
+	}))
+	for _, pair := range []string{"UAH-RON", "EUR-RON"} {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/v1/pair/"+pair, nil)
+		s.Pair(w, r, httprouter.Params{httprouter.Param{Key: "pair", Value: pair}})
+		assert.Equal(t, http.StatusNotFound, w.Code, pair)
+		assert.NotContains(t, w.Body.String(), "Inf", pair)
+	}
+}
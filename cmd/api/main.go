@@ -8,44 +8,119 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/go-pkgz/lgr"
 	"github.com/jessevdk/go-flags"
+	"github.com/parmaster/currency-api/internal/anomaly"
+	"github.com/parmaster/currency-api/internal/client"
+	"github.com/parmaster/currency-api/internal/currency"
+	"github.com/parmaster/currency-api/internal/hub"
 	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/tenant"
+	"github.com/parmaster/currency-api/internal/validator"
+	"github.com/parmaster/currency-api/internal/webhook"
 )
 
 type Options struct {
-	Port       int    `long:"port" short:"p" env:"PORT" description:"Listening port" default:"8080" json:"port"`
-	DbPath     string `long:"dbpath" env:"DBPATH" description:"Path to sqlite3 DB file" default:"file:/tmp/currency-api.db?mode=rwc&_journal_mode=WAL" json:"dbpath"`
-	ApiKey     string `long:"apikey" env:"APIKEY" description:"currencyfreaks.com API key" required:"true" json:"-"`
-	Currencies string `long:"currencies" env:"CURRENCIES" description:"currency codes to use" default:"UAH,USD,EUR,RON" json:"currencies"`
-	Interval   int    `long:"interval" env:"INTERVAL" description:"update interval in seconds" default:"3600" json:"interval"`
-	Debug      bool   `long:"dbg" env:"DEBUG" description:"Enable debug mode with verbose logging" json:"debug"`
-	Version    bool   `short:"v" description:"Show version and exit" json:"-"`
+	Port                 int     `long:"port" short:"p" env:"PORT" description:"Listening port" default:"8080" json:"port"`
+	GRPCPort             int     `long:"grpc-port" env:"GRPC_PORT" description:"gRPC listening port, disabled if 0" default:"0" json:"grpc_port"`
+	DbPath               string  `long:"dbpath" env:"DBPATH" description:"Path to sqlite3 DB file" default:"file:/tmp/currency-api.db?mode=rwc&_journal_mode=WAL" json:"dbpath"`
+	ApiKey               string  `long:"apikey" env:"APIKEY" description:"currencyfreaks.com API key" required:"true" json:"-"`
+	Currencies           string  `long:"currencies" env:"CURRENCIES" description:"currency codes to use" default:"UAH,USD,EUR,RON" json:"currencies"`
+	Interval             int     `long:"interval" env:"INTERVAL" description:"interval in seconds of fetching the latest rates kept as intraday snapshots, disabled if 0" default:"3600" json:"interval"`
+	SnapshotRetention    int     `long:"snapshot-retention" env:"SNAPSHOT_RETENTION" description:"days to keep intraday snapshots, forever if 0" default:"90" json:"snapshot_retention"`
+	Timeout              int     `long:"timeout" env:"TIMEOUT" description:"request processing timeout in seconds" default:"25" json:"timeout"`
+	UpstreamTimeout      int     `long:"upstream-timeout" env:"UPSTREAM_TIMEOUT" description:"timeout of requests to the rates provider in seconds" default:"10" json:"upstream_timeout"`
+	AnomalyThreshold     float64 `long:"anomaly-threshold" env:"ANOMALY_THRESHOLD" description:"max relative day-over-day rate change before the rate is quarantined, disabled if 0" default:"0.5" json:"anomaly_threshold"`
+	AnomalyThresholds    string  `long:"anomaly-thresholds" env:"ANOMALY_THRESHOLDS" description:"per-currency anomaly thresholds, e.g. UAH:0.1,EUR:0.05" json:"anomaly_thresholds"`
+	WebhookRetries       int     `long:"webhook-retries" env:"WEBHOOK_RETRIES" description:"retries of a failed webhook delivery" default:"3" json:"webhook_retries"`
+	WebhookMaxFailures   int     `long:"webhook-max-failures" env:"WEBHOOK_MAX_FAILURES" description:"consecutive failed deliveries before a webhook subscription is disabled" default:"5" json:"webhook_max_failures"`
+	StreamMax            int     `long:"stream-max" env:"STREAM_MAX" description:"max concurrent stream and websocket subscribers, unlimited if 0" default:"100" json:"stream_max"`
+	StreamHeartbeat      int     `long:"stream-heartbeat" env:"STREAM_HEARTBEAT" description:"interval in seconds of stream heartbeats and websocket pings, disabled if 0" default:"15" json:"stream_heartbeat"`
+	GraphQLMaxDepth      int     `long:"graphql-max-depth" env:"GRAPHQL_MAX_DEPTH" description:"max nesting of fields of a GraphQL query, unlimited if 0" default:"6" json:"graphql_max_depth"`
+	GraphQLMaxComplexity int     `long:"graphql-max-complexity" env:"GRAPHQL_MAX_COMPLEXITY" description:"max estimated number of resolved fields of a GraphQL query, unlimited if 0" default:"500" json:"graphql_max_complexity"`
+	GraphQLFetchMax      int     `long:"graphql-fetch-max" env:"GRAPHQL_FETCH_MAX" description:"max historical dates of a GraphQL query fetched from the upstream, others are read from the database only" default:"10" json:"graphql_fetch_max"`
+	BatchMax             int     `long:"batch-max" env:"BATCH_MAX" description:"max items of a batch request, unlimited if 0" default:"5000" json:"batch_max"`
+	BatchFetchMax        int     `long:"batch-fetch-max" env:"BATCH_FETCH_MAX" description:"max historical dates of a batch request fetched from the upstream, others are read from the database only" default:"10" json:"batch_fetch_max"`
+	OnDemand             bool    `long:"on-demand" env:"ON_DEMAND" description:"fetch rates of currencies outside of the configured set on request" json:"on_demand"`
+	OnDemandAllow        string  `long:"on-demand-allow" env:"ON_DEMAND_ALLOW" description:"currency codes allowed for on-demand fetching, any known code if empty" json:"on_demand_allow"`
+	OnDemandDeny         string  `long:"on-demand-deny" env:"ON_DEMAND_DENY" description:"currency codes never fetched on demand" json:"on_demand_deny"`
+	OnDemandMax          int     `long:"on-demand-max" env:"ON_DEMAND_MAX" description:"max number of currencies added on demand" default:"10" json:"on_demand_max"`
+	Staleness            int     `long:"staleness" env:"STALENESS" description:"max age of the newest stored rates in seconds before the service is not ready" default:"172800" json:"staleness"`
+	MaxFails             int     `long:"max-fails" env:"MAX_FAILS" description:"consecutive upstream failures before the provider is considered down" default:"5" json:"max_fails"`
+	AdminKey             string  `long:"admin-key" env:"ADMIN_KEY" description:"API key for the admin endpoints, admin endpoints are disabled if empty" json:"-"`
+	Tenants              string  `long:"tenants" env:"TENANTS" description:"JSON file with the tenants of the API keys, their currencies, base and limits" json:"tenants"`
+	RequireKey           bool    `long:"require-key" env:"REQUIRE_KEY" description:"reject requests without an API key on the metered endpoints and gRPC" json:"require_key"`
+	Backfill             string  `long:"backfill" description:"backfill rates for the date range START:END (2006-01-02:2006-01-02) and exit" json:"-"`
+	BackfillWorkers      int     `long:"backfill-workers" env:"BACKFILL_WORKERS" description:"number of concurrent upstream requests of a backfill job" default:"2" json:"backfill_workers"`
+	BackfillMaxRequests  int     `long:"backfill-max-requests" env:"BACKFILL_MAX_REQUESTS" description:"max upstream requests per backfill run, unlimited if 0" default:"0" json:"backfill_max_requests"`
+	Import               string  `long:"import" description:"import rates from the csv, json or ndjson file and exit" json:"-"`
+	ImportBatch          int     `long:"import-batch" env:"IMPORT_BATCH" description:"number of rows stored in a single transaction on import" default:"500" json:"import_batch"`
+	CompressMin          int     `long:"compress-min" env:"COMPRESS_MIN" description:"min response size in bytes to compress, negative disables compression" default:"1024" json:"compress_min"`
+	DocsIntegrity        string  `long:"docs-integrity" env:"DOCS_INTEGRITY" description:"subresource integrity hash of the redoc bundle of /docs, e.g. sha384-..." json:"docs_integrity"`
+	Seed                 string  `long:"seed" env:"SEED" description:"seed a new database with sample rates" choice:"none" choice:"demo" choice:"file" default:"none" json:"seed"`
+	SeedFile             string  `long:"seed-file" env:"SEED_FILE" description:"csv, json or ndjson file with rates for --seed=file" json:"seed_file"`
+	SeedServe            bool    `long:"seed-serve" env:"SEED_SERVE" description:"include seeded rates in responses" json:"seed_serve"`
+	Debug                bool    `long:"dbg" env:"DEBUG" description:"Enable debug mode with verbose logging" json:"debug"`
+	Version              bool    `short:"v" description:"Show version and exit" json:"-"`
 }
 
 var version = "undefined"
 
 type Server struct {
-	cfg Options
-	db  store.Storer
-	ctx context.Context
+	cfg        Options
+	db         store.Storer
+	ctx        context.Context
+	upstream   upstreamState
+	client     *client.Client
+	currencies []string
+	dynamic    symbolSet
+	jobs       jobs
+	detector   anomaly.Detector
+	webhooks   webhook.Sender
+	alerts     sync.Mutex
+	deliveries sync.WaitGroup
+	hub        *hub.Hub
+	tenants    *tenant.Registry
+	// normalized OnDemandAllow and OnDemandDeny
+	onDemandAllow []string
+	onDemandDeny  []string
 }
 
 func NewServer(cfg Options, db store.Storer, ctx context.Context) *Server {
-	return &Server{cfg: cfg, db: db, ctx: ctx}
+	s := &Server{cfg: cfg, ctx: ctx, db: db, client: client.New(cfg.ApiKey), hub: hub.New(cfg.StreamMax)}
+	for _, c := range strings.Split(cfg.Currencies, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			s.currencies = append(s.currencies, c)
+		}
+	}
+	s.onDemandAllow = splitCodes(cfg.OnDemandAllow)
+	s.onDemandDeny = splitCodes(cfg.OnDemandDeny)
+	// thresholds are validated on startup
+	thresholds, _ := anomaly.ParseThresholds(cfg.AnomalyThresholds)
+	s.detector = anomaly.Detector{Threshold: cfg.AnomalyThreshold, Thresholds: thresholds}
+	// tenants are validated on startup
+	tenants, _ := tenant.Load(cfg.Tenants)
+	s.tenants = tenant.NewRegistry(tenants, func(ctx context.Context, name string, since time.Time) (int64, error) {
+		return s.db.Usage(ctx, name, since)
+	})
+	s.webhooks = webhook.Sender{Client: &http.Client{Timeout: webhookTimeout}, Retries: cfg.WebhookRetries, Backoff: webhookBackoff}
+	return s
 }
 
 func (s *Server) Run() {
 
 	srv := &http.Server{
-		Addr:         net.JoinHostPort("", fmt.Sprintf("%d", s.cfg.Port)),
-		Handler:      s.router(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		Addr:        net.JoinHostPort("", fmt.Sprintf("%d", s.cfg.Port)),
+		Handler:     s.router(),
+		IdleTimeout: time.Minute,
+		ReadTimeout: 10 * time.Second,
+		// leave time to write the response after the request deadline
+		WriteTimeout: time.Duration(s.cfg.Timeout)*time.Second + 5*time.Second,
 	}
 
 	go func() {
@@ -57,12 +132,27 @@ func (s *Server) Run() {
 		}
 	}()
 
+	if s.cfg.GRPCPort > 0 {
+		lis, err := listenGRPC(s.cfg.GRPCPort)
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		go s.serveGRPC(lis)
+	}
+	s.resumeBackfills()
+	go s.refresh(time.Duration(s.cfg.Interval) * time.Second)
+
 	log.Printf("[DEBUG] starting server with options: %+v", s.cfg)
 
 	err := srv.ListenAndServe()
 	if err != http.ErrServerClosed {
 		log.Printf("[ERROR] server failed: %v", err)
 	}
+
+	// the store is closed after Run returns
+	if !s.waitDeliveries(webhookStopTimeout) {
+		log.Printf("[WARN] webhook deliveries are still running, their attempts may not be logged")
+	}
 }
 
 func main() {
@@ -121,12 +211,116 @@ func main() {
 		}
 	}()
 
-	// Database setup
-	db, err := store.NewSQLite(ctx, cfg.DbPath)
+	for _, c := range strings.Split(cfg.Currencies, ",") {
+		if !currency.Known(strings.TrimSpace(c)) {
+			log.Fatalf("[ERROR] unknown currency code in configuration: %q", c)
+		}
+	}
+
+	if _, err := anomaly.ParseThresholds(cfg.AnomalyThresholds); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	tenants, err := tenant.Load(cfg.Tenants)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if err := checkTenants(tenants, cfg); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	// Database setup, the database is closed after the server is stopped and the webhook deliveries are logged
+	dbCtx, closeDB := context.WithCancel(context.Background())
+	defer closeDB()
+	db, err := store.NewSQLite(dbCtx, cfg.DbPath)
 	if err != nil {
 		log.Fatalf("[ERROR] failed to open SQLite storage: %v", err)
 	}
+	db.ServeSeed = cfg.SeedServe
+	if err := seed(ctx, db, cfg); err != nil {
+		log.Fatalf("[ERROR] failed to seed the database: %v", err)
+	}
+
+	// Import mode
+	if cfg.Import != "" {
+		if err := NewServer(cfg, db, ctx).importFile(cfg.Import); err != nil {
+			log.Fatalf("[ERROR] import failed: %v", err)
+		}
+		return
+	}
+
+	// Backfill mode
+	if cfg.Backfill != "" {
+		if err := NewServer(cfg, db, ctx).backfillRange(cfg.Backfill); err != nil {
+			log.Fatalf("[ERROR] backfill failed: %v", err)
+		}
+		return
+	}
 
 	// Starting the server
 	NewServer(cfg, db, ctx).Run()
 }
+
+// backfillRange runs a backfill job for the date range START:END till it is finished
+func (s *Server) backfillRange(dateRange string) error {
+	startStr, endStr, _ := strings.Cut(dateRange, ":")
+	valid := validator.New()
+	start, end := parseDateRange(valid, startStr, endStr)
+	if !valid.Valid() {
+		return fmt.Errorf("invalid date range %q: %v", dateRange, valid.Errors)
+	}
+
+	job, err := s.db.CreateJob(s.ctx, start, end)
+	if err != nil {
+		return fmt.Errorf("failed to create backfill job: %w", err)
+	}
+	err = s.runBackfill(job.ID)
+
+	if job, jerr := s.db.Job(s.ctx, job.ID); jerr == nil {
+		log.Printf("[INFO] backfill job %d: %s, done: %d, failed: %d, pending: %d", job.ID, job.Status, job.Done, job.Failed, job.Pending)
+	}
+	return err
+}
+
+// seed inserts sample rates according to the seed mode, existing rates are not overwritten
+func seed(ctx context.Context, db store.Storer, cfg Options) error {
+	switch cfg.Seed {
+	case "demo":
+		return db.Seed(ctx, store.DemoData)
+	case "file":
+		if cfg.SeedFile == "" {
+			return fmt.Errorf("--seed-file is required for --seed=file")
+		}
+		return seedFile(ctx, db, cfg.SeedFile, cfg.ImportBatch)
+	}
+	return nil
+}
+
+// checkTenants checks that the keys of the tenants differ from the admin key and their currencies
+// are enabled in the configuration, unless currencies are fetched on demand
+func checkTenants(tenants []tenant.Tenant, cfg Options) error {
+	if cfg.RequireKey && len(tenants) == 0 && cfg.AdminKey == "" {
+		return fmt.Errorf("--require-key needs --tenants or --admin-key")
+	}
+	var enabled []string
+	for _, c := range strings.Split(cfg.Currencies, ",") {
+		enabled = append(enabled, strings.TrimSpace(c))
+	}
+	for _, t := range tenants {
+		if cfg.AdminKey != "" && t.Key == cfg.AdminKey {
+			return fmt.Errorf("tenant %s: the key should differ from the admin key", t.Name)
+		}
+		if cfg.OnDemand {
+			continue
+		}
+		for _, c := range t.Currencies {
+			if !validator.PermittedValue(c, enabled...) {
+				return fmt.Errorf("tenant %s: currency %s is not enabled in --currencies", t.Name, c)
+			}
+		}
+		if t.Base != "" && !validator.PermittedValue(t.Base, enabled...) {
+			return fmt.Errorf("tenant %s: base %s is not enabled in --currencies", t.Name, t.Base)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/ohlc"
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+// maxCandles limits the number of intervals of the period
+const maxCandles = 1000
+
+// Candle describes the rates of the pair over an interval
+type Candle struct {
+	Time  string         `json:"time"`
+	Open  data.FloatRate `json:"open"`
+	High  data.FloatRate `json:"high"`
+	Low   data.FloatRate `json:"low"`
+	Close data.FloatRate `json:"close"`
+	// Count is the number of snapshots in the interval
+	Count int `json:"count"`
+}
+
+// OHLCResponse is a series of candles of the pair, intervals without snapshots are skipped
+type OHLCResponse struct {
+	Pair     string   `json:"pair"`
+	Interval string   `json:"interval"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Candles  []Candle `json:"candles"`
+}
+
+// MarshalCSV returns the candles as a table
+func (o OHLCResponse) MarshalCSV() [][]string {
+	res := [][]string{{"time", "open", "high", "low", "close", "count"}}
+	for _, c := range o.Candles {
+		res = append(res, []string{c.Time, c.Open.String(), c.High.String(), c.Low.String(), c.Close.String(), strconv.Itoa(c.Count)})
+	}
+	return res
+}
+
+// OHLC returns open/high/low/close candles of the pair aggregated from the intraday snapshots
+// GET /v1/ohlc/:pair?interval=1h|1d|1w&start=2024-04-01&end=2024-04-30
+func (s *Server) OHLC(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.URL.Query()
+
+	valid := validator.New()
+	pair := s.checkPair(r.Context(), valid, ps.ByName("pair"))
+	intervalStr := query.Get("interval")
+	if intervalStr == "" {
+		intervalStr = "1d"
+	}
+	interval, err := ohlc.ParseInterval(intervalStr)
+	valid.CheckCode(err == nil, "interval", CodeInvalidInterval, "invalid interval, use 1h, 1d or 1w")
+	start, err := time.Parse("2006-01-02", query.Get("start"))
+	valid.CheckCode(err == nil, "start", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	end, err := time.Parse("2006-01-02", query.Get("end"))
+	valid.CheckCode(err == nil, "end", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	if valid.Valid() {
+		valid.CheckCode(!start.After(end), "start", CodeInvalidDate, "start should not be after end")
+		valid.CheckCode(!end.After(time.Now()), "end", CodeInvalidDate, "end should not be in the future")
+		valid.CheckCode(end.AddDate(0, 0, 1).Sub(start)/interval <= maxCandles, "end", CodeInvalidDate,
+			fmt.Sprintf("period should not exceed %d intervals", maxCandles))
+	}
+
+	if !valid.Valid() {
+		s.failValidation(w, r, valid)
+		return
+	}
+
+	s.logRequest(r.Context(), "ohlc", fmt.Sprintf("pair: %s, interval: %s, start: %s, end: %s",
+		strings.Join(pair, "-"), intervalStr, start.Format("2006-01-02"), end.Format("2006-01-02")))
+
+	snapshots, err := s.db.Snapshots(r.Context(), pair, start, end.AddDate(0, 0, 1))
+	if err != nil {
+		s.failRates(w, r, err)
+		return
+	}
+
+	points := make([]ohlc.Point, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if rate, ok := crossRate(snapshot.Rates, pair[0], pair[1]); ok {
+			points = append(points, ohlc.Point{Time: snapshot.Date.Time, Value: float64(rate)})
+		}
+	}
+	if len(points) == 0 {
+		s.fail(w, r, http.StatusNotFound, CodeRatesUnavailable, "no snapshots available for the pair in the period")
+		return
+	}
+
+	res := OHLCResponse{Pair: strings.Join(pair, "-"), Interval: intervalStr, Start: start.Format("2006-01-02"), End: end.Format("2006-01-02")}
+	for _, c := range ohlc.Aggregate(points, interval) {
+		res.Candles = append(res.Candles, Candle{
+			Time:  c.Time.Format(time.RFC3339),
+			Open:  data.FloatRate(c.Open),
+			High:  data.FloatRate(c.High),
+			Low:   data.FloatRate(c.Low),
+			Close: data.FloatRate(c.Close),
+			Count: c.Count,
+		})
+	}
+
+	err = s.respond(w, r, http.StatusOK, res)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
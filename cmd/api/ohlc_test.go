@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_OHLC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	// EUR per UAH: 0.02, 0.025, 0.016 (quarantined), 0.0225
+	responses := []string{
+		`{"date":"2024-04-22 09:00:00+00","base":"USD","rates":{"UAH":"40","EUR":"0.8","RON":"4.7"}}`,
+		`{"date":"2024-04-22 09:30:00+00","base":"USD","rates":{"UAH":"36","EUR":"0.9","RON":"4.7"}}`,
+		`{"date":"2024-04-22 10:00:00+00","base":"USD","rates":{"UAH":"500","EUR":"0.8","RON":"4.7"}}`,
+		`{"date":"2024-04-22 10:15:00+00","base":"USD","rates":{"UAH":"40","EUR":"0.9","RON":"4.7"}}`,
+	}
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := atomic.AddInt32(&calls, 1) - 1
+		w.Write([]byte(responses[int(i)%len(responses)]))
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", AnomalyThreshold: 0.5}, db, ctx)
+	s.client.ApiUrl["latest"] = upstream.URL
+	for range responses {
+		s.refreshLatest()
+	}
+
+	// the rates of the day are updated by the latest snapshot
+	stored, err := db.Read(ctx, time.Date(2024, 4, 22, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]data.FloatRate{"UAH": 40, "EUR": 0.9, "RON": 4.7}, stored.Rates)
+
+	request := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	w := request("/v1/ohlc/UAH-EUR?interval=1h&start=2024-04-22&end=2024-04-22")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	resp := OHLCResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "1h", resp.Interval)
+	assert.Equal(t, []Candle{
+		{Time: "2024-04-22T09:00:00Z", Open: 0.02, High: 0.025, Low: 0.02, Close: 0.025, Count: 2},
+		{Time: "2024-04-22T10:00:00Z", Open: 0.0225, High: 0.0225, Low: 0.0225, Close: 0.0225, Count: 1},
+	}, resp.Candles)
+
+	w = request("/v1/ohlc/UAH-EUR?start=2024-04-15&end=2024-04-28&format=csv")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "time,open,high,low,close,count\n2024-04-22T00:00:00Z,0.02,0.025,0.02,0.0225,3\n", w.Body.String())
+
+	tbl := []struct {
+		url    string
+		status int
+		code   string
+	}{
+		{"/v1/ohlc/UAH-EUR?interval=5m&start=2024-04-22&end=2024-04-22", http.StatusBadRequest, CodeInvalidInterval},
+		{"/v1/ohlc/UAH-EUR?interval=1h&start=2024-01-01&end=2024-04-22", http.StatusBadRequest, CodeInvalidDate},
+		{"/v1/ohlc/UAH-EUR?start=2024-04-22", http.StatusBadRequest, CodeInvalidDate},
+		{"/v1/ohlc/UAH-GBP?start=2024-04-22&end=2024-04-22", http.StatusBadRequest, CodeUnsupportedCurrency},
+		{"/v1/ohlc/UAH-EUR?interval=1w&start=2024-03-01&end=2024-03-31", http.StatusNotFound, CodeRatesUnavailable},
+	}
+	for _, tt := range tbl {
+		w = request(tt.url)
+		assert.Equal(t, tt.status, w.Code, tt.url)
+		resp := ErrorResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp), tt.url)
+		assert.Equal(t, tt.code, resp.Error.Code, tt.url)
+	}
+
+	// snapshots older than the retention are removed on refresh
+	s.cfg.SnapshotRetention = 1
+	s.refreshLatest()
+	w = request("/v1/ohlc/UAH-EUR?start=2024-04-22&end=2024-04-22")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
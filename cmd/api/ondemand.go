@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/currency"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+// symbolSet is a set of currency codes, safe for concurrent use
+type symbolSet struct {
+	mu      sync.Mutex
+	symbols map[string]bool
+}
+
+func (ss *symbolSet) has(symbol string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.symbols[symbol]
+}
+
+// add adds the symbol unless the set already holds max symbols, returns false if the set is full
+func (ss *symbolSet) add(symbol string, max int) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.symbols[symbol] {
+		return true
+	}
+	if len(ss.symbols) >= max {
+		return false
+	}
+	if ss.symbols == nil {
+		ss.symbols = map[string]bool{}
+	}
+	ss.symbols[symbol] = true
+	return true
+}
+
+func (ss *symbolSet) len() int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return len(ss.symbols)
+}
+
+func (ss *symbolSet) list() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	res := make([]string, 0, len(ss.symbols))
+	for s := range ss.symbols {
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// splitCodes splits a comma separated list of currency codes, codes are trimmed and upper-cased
+func splitCodes(list string) []string {
+	res := []string{}
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// onDemandAllowed reports whether the currency outside of the configured set
+// can be fetched from the upstream on request
+func (s *Server) onDemandAllowed(code string) bool {
+	if !s.cfg.OnDemand || !currency.Known(code) {
+		return false
+	}
+	if len(s.onDemandAllow) > 0 && !validator.PermittedValue(code, s.onDemandAllow...) {
+		return false
+	}
+	if validator.PermittedValue(code, s.onDemandDeny...) {
+		return false
+	}
+	return s.dynamic.has(code) || s.dynamic.len() < s.cfg.OnDemandMax
+}
+
+// fetchOnDemand fetches the requested symbols missing in the rates from the upstream,
+// stores them alongside the existing rates for the date and returns the merged rates.
+// Symbols not allowed for on-demand fetching are skipped, errors are logged. Symbols count
+// against OnDemandMax only once they are fetched
+func (s *Server) fetchOnDemand(ctx context.Context, rates data.Rates, symbols []string) data.Rates {
+	missing := []string{}
+	for _, symbol := range symbols {
+		if _, ok := rates.Rates[symbol]; ok || validator.PermittedValue(symbol, s.currencies...) {
+			continue
+		}
+		if s.onDemandAllowed(symbol) {
+			missing = append(missing, symbol)
+		}
+	}
+	latest := rates.Date.String() == time.Now().Format("2006-01-02")
+	if len(missing) == 0 || (!latest && checkFetch(ctx, rates.Date.Time) != nil) {
+		return rates
+	}
+
+	var fetched data.Rates
+	var err error
+	uctx, cancel := s.upstreamContext(ctx)
+	if latest {
+		fetched, err = s.client.GetLatest(uctx, strings.Join(missing, ","))
+	} else {
+		fetched, err = s.client.GetHistorical(uctx, strings.Join(missing, ","), rates.Date.Time)
+	}
+	cancel()
+	if ctx.Err() != nil {
+		return rates
+	}
+	s.upstream.record(err)
+	if err != nil {
+		log.Printf("[ERROR] failed to fetch rates on demand for %v: %v", missing, err)
+		return rates
+	}
+	if fetched.Base != rates.Base {
+		log.Printf("[WARN] on-demand rates base %q differs from %q, skipped", fetched.Base, rates.Base)
+		return rates
+	}
+
+	// keep the symbols fitting under the cap, concurrent requests may have filled it meanwhile
+	added := []string{}
+	for _, symbol := range missing {
+		if _, ok := fetched.Rates[symbol]; ok && s.dynamic.add(symbol, s.cfg.OnDemandMax) {
+			added = append(added, symbol)
+		}
+	}
+	if len(added) == 0 {
+		return rates
+	}
+
+	// store under the date of the existing rates
+	fetched.Date = rates.Date
+	fetched, err = s.ingest(context.WithoutCancel(ctx), fetched.Filter(added))
+	if err != nil {
+		log.Printf("[ERROR] failed to write on-demand rates: %v", err)
+	}
+
+	merged := data.Rates{Date: rates.Date, Base: rates.Base, Rates: make(map[string]data.FloatRate, len(rates.Rates)+len(fetched.Rates))}
+	for c, r := range rates.Rates {
+		merged.Rates[c] = r
+	}
+	for c, r := range fetched.Rates {
+		merged.Rates[c] = r
+	}
+	return merged
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_OnDemand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	var calls int32
+	var down atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "GBP", r.URL.Query().Get("symbols"))
+		assert.Equal(t, "2024-04-20", r.URL.Query().Get("date"))
+		w.Write([]byte(`{"date":"2024-04-20 00:00:00+00","base":"USD","rates":{"GBP":"0.8"}}`))
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", OnDemand: true, OnDemandDeny: " rub", OnDemandMax: 1}, db, ctx)
+	s.client.ApiUrl["historical"] = upstream.URL
+
+	rates := func(symbols string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/rates/2024-04-20?symbols="+symbols, nil)
+		s.Rates(w, r, httprouter.Params{httprouter.Param{Key: "date", Value: "2024-04-20"}})
+		return w
+	}
+
+	// failed fetches don't take the slot
+	down.Store(true)
+	w := rates("EUR,GBP")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, s.dynamic.list())
+	down.Store(false)
+	atomic.StoreInt32(&calls, 0)
+
+	// GBP is fetched on demand and merged
+	w = rates("EUR,GBP")
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := data.RateResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, map[string]data.FloatRate{"EUR": 0.8, "GBP": 0.8}, resp.Rates)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, []string{"GBP"}, s.dynamic.list())
+
+	// and stored alongside the existing rows
+	stored, err := db.Read(ctx, time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Len(t, stored.Rates, 4)
+	assert.Equal(t, data.FloatRate(0.8), stored.Rates["GBP"])
+
+	// second request is served from the database
+	w = rates("GBP")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// denied, the configured list is normalized
+	w = rates("RUB")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "currency is not enabled: RUB")
+
+	// cap reached
+	w = rates("JPY")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "currency is not enabled: JPY")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func Test_symbolSet(t *testing.T) {
+	ss := symbolSet{}
+	assert.True(t, ss.add("GBP", 2))
+	assert.True(t, ss.add("GBP", 2))
+	assert.True(t, ss.add("JPY", 2))
+	assert.False(t, ss.add("CHF", 2))
+	assert.True(t, ss.has("GBP"))
+	assert.False(t, ss.has("CHF"))
+	assert.Equal(t, []string{"GBP", "JPY"}, ss.list())
+}
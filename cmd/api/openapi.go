@@ -0,0 +1,49 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// openAPISpec is the OpenAPI 3 description of the API, kept in sync with the handlers by the contract tests
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// redocScript is the pinned redoc bundle rendering the documentation page
+const redocScript = "https://cdn.redoc.ly/redoc/v2.1.5/bundles/redoc.standalone.js"
+
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Currency exchange rates API</title>
+</head>
+<body>
+	<redoc spec-url="/v1/openapi.json"></redoc>
+	<script src="%s"%s></script>
+</body>
+</html>
+`
+
+// OpenAPI returns the OpenAPI specification
+// GET /v1/openapi.json
+func (s *Server) OpenAPI(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// Docs renders the OpenAPI specification as a documentation page
+// GET /docs
+func (s *Server) Docs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	integrity := ""
+	if s.cfg.DocsIntegrity != "" {
+		integrity = ` integrity="` + html.EscapeString(s.cfg.DocsIntegrity) + `" crossorigin="anonymous"`
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, docsPage, redocScript, integrity)
+}
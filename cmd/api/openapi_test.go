@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_openAPISpec(t *testing.T) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	assert.Nil(t, err)
+	assert.Nil(t, doc.Validate(context.Background()))
+
+	s := NewServer(Options{}, nil, context.Background())
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, openAPISpec, w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `spec-url="/v1/openapi.json"`)
+	assert.Contains(t, w.Body.String(), `<script src="`+redocScript+`"></script>`)
+
+	s.cfg.DocsIntegrity = "sha384-abc"
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Contains(t, w.Body.String(), `<script src="`+redocScript+`" integrity="sha384-abc" crossorigin="anonymous"></script>`)
+}
+
+// TestServer_OpenAPIContract validates actual responses of the handlers against the specification
+func TestServer_OpenAPIContract(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	assert.Nil(t, err)
+	router, err := legacy.NewRouter(doc)
+	assert.Nil(t, err)
+
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"date":"2024-04-22 00:00:00+00","base":"USD","rates":{"UAH":"39.5","EUR":"0.81","RON":"4.71"}}`))
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", AdminKey: "secret", Staleness: 3600}, db, ctx)
+	s.client.ApiUrl["latest"] = upstream.URL
+	s.client.ApiUrl["historical"] = upstream.URL
+	s.tenants = tenant.NewRegistry([]tenant.Tenant{
+		{Name: "partner", Key: "partner", Currencies: []string{"EUR", "RON"}, Base: "EUR", MonthlyQuota: 1},
+	}, db.Usage)
+	handler := s.router()
+
+	options := &openapi3filter.Options{
+		IncludeResponseStatus: true,
+		AuthenticationFunc: func(context.Context, *openapi3filter.AuthenticationInput) error {
+			return nil
+		},
+	}
+
+	tbl := []struct {
+		method, url, body string
+		status            int
+	}{
+		{http.MethodGet, "/v1/rates", "", http.StatusOK},
+		{http.MethodGet, "/v1/rates/2024-04-20", "", http.StatusOK},
+		{http.MethodGet, "/v1/rates/2024-04-20?symbols=UAH,EUR&pretty=1", "", http.StatusOK},
+		{http.MethodGet, "/v1/rates/2024-04-20?symbols=XYZ", "", http.StatusBadRequest},
+		{http.MethodGet, "/v1/rates/2024-04-20?symbols=GBP", "", http.StatusBadRequest},
+		{http.MethodGet, "/v1/pair/UAH-RON", "", http.StatusOK},
+		{http.MethodGet, "/v1/pair/UAH", "", http.StatusBadRequest},
+		{http.MethodGet, "/v1/stats/UAH-EUR?start=2024-04-19&end=2024-04-21", "", http.StatusOK},
+		{http.MethodGet, "/v1/stats/UAH-EUR?start=2024-04-21", "", http.StatusBadRequest},
+		{http.MethodGet, "/v1/stats/UAH-EUR?start=2024-01-01&end=2024-01-31", "", http.StatusNotFound},
+		{http.MethodGet, "/v1/ohlc/UAH-EUR?interval=1h&start=2024-04-22&end=2024-04-22", "", http.StatusOK},
+		{http.MethodGet, "/v1/ohlc/UAH-EUR?interval=5m&start=2024-04-22&end=2024-04-22", "", http.StatusBadRequest},
+		{http.MethodGet, "/v1/ohlc/UAH-EUR?start=2024-03-01&end=2024-03-31", "", http.StatusNotFound},
+		{http.MethodPost, "/v1/batch", `{"items":[{"pair":"UAH-RON","date":"2024-04-20","amount":100},{"pair":"UAH-XYZ"},{"pair":"UAH-RON","date":"2024-01-01"}]}`, http.StatusOK},
+		{http.MethodPost, "/v1/batch", `{"items":[]}`, http.StatusBadRequest},
+		{http.MethodGet, "/v1/stream?symbols=UAH,XYZ", "", http.StatusBadRequest},
+		{http.MethodGet, "/v1/ws", "", http.StatusBadRequest},
+		{http.MethodGet, "/v1/currencies?all=true", "", http.StatusOK},
+		{http.MethodPost, "/v1/graphql", `{"query":"{ rates(date: \"2024-04-20\") { date rates { currency rate info { code } } } pair(pair: \"USD-XYZ\") { rate } }"}`, http.StatusOK},
+		{http.MethodPost, "/v1/graphql", `{"query":"{ rates { time } }"}`, http.StatusBadRequest},
+		{http.MethodPost, "/v1/graphql", `{}`, http.StatusBadRequest},
+		{http.MethodGet, "/v1/graphql?query=%7B%20currency(code%3A%20%22UAH%22)%20%7B%20name%20%7D%20%7D", "", http.StatusOK},
+		{http.MethodGet, "/v1/status", "", http.StatusOK},
+		{http.MethodGet, "/healthz", "", http.StatusOK},
+		{http.MethodGet, "/readyz", "", http.StatusServiceUnavailable},
+		{http.MethodGet, "/v1/export?start=2024-04-20&end=2024-04-21", "", http.StatusOK},
+		{http.MethodGet, "/v1/export?format=yaml", "", http.StatusBadRequest},
+		{http.MethodPost, "/v1/import", `[{"date":"2024-04-19","base":"USD","currency":"UAH","rate":39.3},{"date":"2024-04-19","base":"USD","currency":"XYZ","rate":1}]`, http.StatusOK},
+		{http.MethodPost, "/v1/import", `{`, http.StatusBadRequest},
+		{http.MethodPost, "/v1/subscriptions", `{"pair":"USD-UAH","condition":"above","value":50,"url":"http://127.0.0.1:1/hook"}`, http.StatusCreated},
+		{http.MethodPost, "/v1/subscriptions", `{"pair":"USD-UAH","condition":"equal","value":-1,"url":"ftp://host"}`, http.StatusBadRequest},
+		{http.MethodGet, "/v1/subscriptions", "", http.StatusOK},
+		{http.MethodPut, "/v1/subscriptions/1", `{"pair":"USD-UAH","condition":"below","value":30,"url":"http://127.0.0.1:1/hook","active":false}`, http.StatusOK},
+		{http.MethodGet, "/v1/subscriptions/1", "", http.StatusOK},
+		{http.MethodGet, "/v1/subscriptions/1/deliveries", "", http.StatusOK},
+		{http.MethodGet, "/v1/subscriptions/99", "", http.StatusNotFound},
+		{http.MethodDelete, "/v1/subscriptions/1", "", http.StatusNoContent},
+		{http.MethodPost, "/v1/admin/backfill", `{"start":"2024-04-20","end":"2024-04-19"}`, http.StatusBadRequest},
+		{http.MethodGet, "/v1/admin/backfill/1", "", http.StatusNotFound},
+		{http.MethodPost, "/v1/admin/backfill/1/resume", "", http.StatusNotFound},
+		{http.MethodGet, "/v1/admin/usage", "", http.StatusOK},
+		{http.MethodGet, "/v1/admin/usage?key=partner&from=2024-04-01&to=2024-04-30&group_by=route", "", http.StatusOK},
+		{http.MethodGet, "/v1/admin/usage?from=2024-04-30&to=2024-04-01&group_by=month", "", http.StatusBadRequest},
+	}
+	check := func(method, url, body, key string, status int) {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("X-Api-Key", key)
+		if body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, status, w.Code, url)
+
+		route, params, err := router.FindRoute(r)
+		if !assert.Nil(t, err, "%s %s is not documented", method, url) {
+			return
+		}
+		input := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: params,
+				Route:      route,
+				Options:    options,
+			},
+			Status:  w.Code,
+			Header:  w.Header(),
+			Options: options,
+		}
+		input.SetBodyBytes(w.Body.Bytes())
+		assert.Nil(t, openapi3filter.ValidateResponse(ctx, input), "%s %s: %s", method, url, w.Body.String())
+	}
+	for _, tt := range tbl {
+		check(tt.method, tt.url, tt.body, "secret", tt.status)
+	}
+
+	// requests of tenants are limited to their currencies and quotas
+	check(http.MethodGet, "/v1/pair/UAH-RON", "", "partner", http.StatusBadRequest)
+	check(http.MethodGet, "/v1/rates/2024-04-20", "", "partner", http.StatusOK)
+	check(http.MethodGet, "/v1/pair/EUR-RON", "", "partner", http.StatusTooManyRequests)
+	check(http.MethodGet, "/v1/pair/EUR-RON", "", "unknown", http.StatusUnauthorized)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+)
+
+// storeLatest ingests the latest rates, evaluates webhook subscriptions, publishes the rates
+// to the stream subscribers and keeps the rates which passed the checks as an intraday snapshot
+func (s *Server) storeLatest(ctx context.Context, rates data.Rates) (data.Rates, error) {
+	clean, err := s.ingest(ctx, rates)
+	if err != nil || len(clean.Rates) == 0 {
+		return clean, err
+	}
+	s.notify(ctx, clean)
+	s.publish(clean)
+	return clean, s.db.WriteSnapshot(ctx, clean)
+}
+
+// refresh fetches the latest rates every interval till the server context is canceled,
+// the rates of the day are updated and kept as intraday snapshots
+func (s *Server) refresh(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.refreshLatest()
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshLatest fetches and stores the latest rates and removes snapshots older than the retention
+func (s *Server) refreshLatest() {
+	symbols := strings.Join(append(s.currencies, s.dynamic.list()...), ",")
+	uctx, cancel := s.upstreamContext(s.ctx)
+	rates, err := s.client.GetLatest(uctx, symbols)
+	cancel()
+	if s.ctx.Err() != nil {
+		return
+	}
+	s.upstream.record(err)
+	if err != nil {
+		log.Printf("[ERROR] failed to refresh rates: %v", err)
+		return
+	}
+	if _, err = s.storeLatest(s.ctx, rates); err != nil {
+		log.Printf("[ERROR] failed to store refreshed rates: %v", err)
+	}
+
+	if s.cfg.SnapshotRetention <= 0 {
+		return
+	}
+	n, err := s.db.PruneSnapshots(s.ctx, time.Now().AddDate(0, 0, -s.cfg.SnapshotRetention))
+	if err != nil {
+		log.Printf("[ERROR] failed to prune snapshots: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[DEBUG] pruned %d snapshot rates", n)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Response formats
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatXML  = "xml"
+)
+
+var contentTypes = map[string]string{
+	formatJSON: "application/json",
+	formatCSV:  "text/csv",
+	formatXML:  "application/xml",
+}
+
+// csvMarshaler is implemented by responses which can be represented as a table,
+// the first record is a header
+type csvMarshaler interface {
+	MarshalCSV() [][]string
+}
+
+// negotiate returns the response format requested by the format query parameter
+// or the Accept header, JSON by default
+func negotiate(r *http.Request) string {
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case formatCSV:
+		return formatCSV
+	case formatXML:
+		return formatXML
+	case formatJSON:
+		return formatJSON
+	}
+
+	type accepted struct {
+		format string
+		q      float64
+	}
+	var formats []accepted
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json", "*/*", "application/*":
+			formats = append(formats, accepted{formatJSON, q})
+		case "text/csv":
+			formats = append(formats, accepted{formatCSV, q})
+		case "application/xml", "text/xml":
+			formats = append(formats, accepted{formatXML, q})
+		}
+	}
+	sort.SliceStable(formats, func(i, j int) bool { return formats[i].q > formats[j].q })
+	if len(formats) > 0 && formats[0].q > 0 {
+		return formats[0].format
+	}
+	return formatJSON
+}
+
+// respond writes the data in the format negotiated with the client. Data which can't be
+// represented as CSV is written as JSON. JSON is compact unless ?pretty=1 is set
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, status int, data any) error {
+	w.Header().Add("Vary", "Accept")
+
+	format := negotiate(r)
+	if _, ok := data.(csvMarshaler); format == formatCSV && !ok {
+		format = formatJSON
+	}
+
+	var body []byte
+	var err error
+	switch format {
+	case formatCSV:
+		body, err = marshalCSV(data.(csvMarshaler))
+	case formatXML:
+		body, err = marshalXML(data)
+	default:
+		pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+		body, err = marshalJSON(data, pretty)
+	}
+	if err != nil {
+		log.Printf("[ERROR] marshaling error, %+v", err)
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.WriteHeader(status)
+	w.Write(body)
+
+	return nil
+}
+
+func marshalJSON(data any, pretty bool) ([]byte, error) {
+	var js []byte
+	var err error
+	if pretty {
+		js, err = json.MarshalIndent(data, "", "\t")
+	} else {
+		js, err = json.Marshal(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(js, '\n'), nil
+}
+
+func marshalCSV(data csvMarshaler) ([]byte, error) {
+	buf := bytes.Buffer{}
+	cw := csv.NewWriter(&buf)
+	if err := cw.WriteAll(data.MarshalCSV()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalXML converts the JSON representation of the data to XML, so both formats
+// share field names and hidden fields. Objects become elements named by their keys,
+// keys which are not valid element names become <entry key="..."> elements,
+// array items are wrapped in <item> elements
+func marshalXML(data any) ([]byte, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	if err = jsonToXML(dec, enc, "response"); err != nil {
+		return nil, err
+	}
+	if err = enc.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// xmlName matches keys safe to use as element names, a subset of the XML Name production
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// jsonToXML reads a single JSON value from dec and writes it as the element named name,
+// or as an <entry> element with the name in the key attribute if it is not a valid name
+func jsonToXML(dec *json.Decoder, enc *xml.Encoder, name string) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !xmlName.MatchString(name) {
+		start = xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}}}
+	}
+	if err = enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := t.(type) {
+	case json.Delim:
+		for dec.More() {
+			child := "item"
+			if v == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = key.(string)
+			}
+			if err = jsonToXML(dec, enc, child); err != nil {
+				return err
+			}
+		}
+		// closing delimiter
+		if _, err = dec.Token(); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		if err = enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_negotiate(t *testing.T) {
+	tbl := []struct {
+		url, accept, format string
+	}{
+		{"/", "", formatJSON},
+		{"/", "*/*", formatJSON},
+		{"/", "text/csv", formatCSV},
+		{"/", "application/xml", formatXML},
+		{"/", "text/xml;q=0.5, text/csv;q=0.8", formatCSV},
+		{"/", "text/html, application/xml;q=0.9, */*;q=0.8", formatXML},
+		{"/", "text/html", formatJSON},
+		{"/", "text/csv;q=0", formatJSON},
+		{"/?format=xml", "text/csv", formatXML},
+		{"/?format=CSV", "", formatCSV},
+		{"/?format=yaml", "text/csv", formatCSV},
+	}
+	for _, tt := range tbl {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		r.Header.Set("Accept", tt.accept)
+		assert.Equal(t, tt.format, negotiate(r), "%s, Accept: %s", tt.url, tt.accept)
+	}
+}
+
+func TestServer_Formats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+	s := NewServer(Options{ApiKey: "secret-key", Currencies: "USD,UAH,EUR,RON"}, db, ctx)
+
+	get := func(url, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set("Accept", accept)
+		s.router().ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/v1/rates/2024-04-20?symbols=UAH,EUR", "")
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Values("Vary"), "Accept")
+	assert.Equal(t, `{"date":"2024-04-20 00:00:00+00","base":"USD","rates":{"EUR":0.8,"UAH":39.4}}`+"\n", w.Body.String())
+
+	w = get("/v1/rates/2024-04-20?symbols=UAH&pretty=1", "")
+	assert.Equal(t, "{\n\t\"date\": \"2024-04-20 00:00:00+00\",\n\t\"base\": \"USD\",\n\t\"rates\": {\n\t\t\"UAH\": 39.4\n\t}\n}\n", w.Body.String())
+
+	w = get("/v1/rates/2024-04-20", "text/csv")
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "date,base,currency,rate\n2024-04-20,USD,EUR,0.8\n2024-04-20,USD,RON,4.7\n2024-04-20,USD,UAH,39.4\n", w.Body.String())
+
+	w = get("/v1/rates/2024-04-20?symbols=UAH,EUR&format=xml", "")
+	assert.Equal(t, "application/xml", w.Header().Get("Content-Type"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<response><date>2024-04-20 00:00:00+00</date><base>USD</base><rates><EUR>0.8</EUR><UAH>39.4</UAH></rates></response>`+"\n", w.Body.String())
+
+	// errors are returned as JSON when CSV is requested
+	w = get("/v1/rates/2024-04?format=csv", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	// logs
+	w = get("/v1/status", "text/csv")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "datetime,type,request\n")
+	assert.Contains(t, w.Body.String(), ",rates,\"date: 2024-04-20, symbols: UAH,EUR\"\n")
+
+	w = get("/v1/status", "application/xml")
+	assert.Contains(t, w.Body.String(), "<logs><item>")
+	assert.Contains(t, w.Body.String(), "<currencies>USD,UAH,EUR,RON</currencies>")
+	assert.NotContains(t, w.Body.String(), "secret-key")
+
+	// keys which are not element names don't break the document
+	out, err := marshalXML(map[string]int{"a><injected>x</injected><b": 1, "1st": 2, "EUR": 3})
+	assert.Nil(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<response><entry key="1st">2</entry><EUR>3</EUR><entry key="a&gt;&lt;injected&gt;x&lt;/injected&gt;&lt;b">1</entry></response>`+"\n", string(out))
+	assert.Nil(t, xml.Unmarshal(out, new(struct{})))
+
+	w = get("/v1/currencies?format=csv", "")
+	assert.True(t, strings.HasPrefix(w.Body.String(), "code,name,numeric,minor_units,symbol,enabled\nEUR,Euro,978,2,€,true\n"))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/pkg/currencyapi"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestServer_SDK runs the public client against the real router
+func TestServer_SDK(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON"}, db, ctx)
+	ts := httptest.NewServer(s.router())
+	defer ts.Close()
+	c := currencyapi.New(ts.URL, "")
+	c.RetryDelay = time.Millisecond
+
+	date := time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC)
+	rates, err := c.Historical(ctx, date, "UAH", "EUR")
+	assert.Nil(t, err)
+	assert.Equal(t, "USD", rates.Base)
+	assert.Equal(t, map[string]currencyapi.FloatRate{"UAH": 39.4, "EUR": 0.8}, rates.Rates)
+
+	series, err := c.TimeSeries(ctx, date, date.AddDate(0, 0, 1), "UAH")
+	assert.Nil(t, err)
+	assert.Len(t, series, 2)
+	assert.Equal(t, "2024-04-20", series[0].Date.String())
+	assert.Equal(t, map[string]currencyapi.FloatRate{"UAH": 39.4}, series[0].Rates)
+	assert.Equal(t, "2024-04-21", series[1].Date.String())
+
+	status, err := c.Status(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", status.Status)
+	assert.Equal(t, "USD,UAH,EUR,RON", status.Config["currencies"])
+
+	_, err = c.Historical(ctx, date, "XYZ")
+	assert.True(t, errors.Is(err, currencyapi.ErrUnknownCurrency), err)
+
+	_, err = c.Pair(ctx, "USD", "JPY")
+	assert.True(t, errors.Is(err, currencyapi.ErrUnsupportedCurrency), err)
+
+	_, err = c.Historical(ctx, date, "GBP")
+	var apiErr *currencyapi.Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.NotEmpty(t, apiErr.RequestID)
+}
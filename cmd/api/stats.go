@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/stats"
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+// maxStatsDays limits the period of the statistics
+const maxStatsDays = 3660
+
+// StatsResponse describes the daily rates of a pair over a period
+type StatsResponse struct {
+	Pair  string `json:"pair"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Days is the number of days with rates of both currencies
+	Days   int            `json:"days"`
+	Open   data.FloatRate `json:"open"`
+	Close  data.FloatRate `json:"close"`
+	Min    data.FloatRate `json:"min"`
+	Max    data.FloatRate `json:"max"`
+	Mean   data.FloatRate `json:"mean"`
+	Median data.FloatRate `json:"median"`
+	StdDev data.FloatRate `json:"stddev"`
+	// Change is the percent change from open to close
+	Change float64 `json:"change"`
+	// Missing are the days of the period without rates
+	Missing []string `json:"missing"`
+}
+
+// MarshalCSV returns the statistics as a table, missing days are separated by spaces
+func (s StatsResponse) MarshalCSV() [][]string {
+	return [][]string{
+		{"pair", "start", "end", "days", "open", "close", "min", "max", "mean", "median", "stddev", "change", "missing"},
+		{s.Pair, s.Start, s.End, strconv.Itoa(s.Days), s.Open.String(), s.Close.String(), s.Min.String(), s.Max.String(),
+			s.Mean.String(), s.Median.String(), s.StdDev.String(), strconv.FormatFloat(s.Change, 'f', -1, 64), strings.Join(s.Missing, " ")},
+	}
+}
+
+// Stats returns statistics of the stored daily rates of the pair over the period
+// GET /v1/stats/:pair?start=2024-04-01&end=2024-04-30
+func (s *Server) Stats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.URL.Query()
+
+	valid := validator.New()
+	pair := s.checkPair(r.Context(), valid, ps.ByName("pair"))
+	start, err := time.Parse("2006-01-02", query.Get("start"))
+	valid.CheckCode(err == nil, "start", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	end, err := time.Parse("2006-01-02", query.Get("end"))
+	valid.CheckCode(err == nil, "end", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	if valid.Valid() {
+		valid.CheckCode(!start.After(end), "start", CodeInvalidDate, "start should not be after end")
+		valid.CheckCode(!end.After(time.Now()), "end", CodeInvalidDate, "end should not be in the future")
+		valid.CheckCode(end.Sub(start) < maxStatsDays*24*time.Hour, "end", CodeInvalidDate, fmt.Sprintf("period should not exceed %d days", maxStatsDays))
+	}
+
+	if !valid.Valid() {
+		s.failValidation(w, r, valid)
+		return
+	}
+
+	s.logRequest(r.Context(), "stats", fmt.Sprintf("pair: %s, start: %s, end: %s", strings.Join(pair, "-"), start.Format("2006-01-02"), end.Format("2006-01-02")))
+
+	days := map[string]map[string]data.FloatRate{}
+	err = s.db.Export(r.Context(), start, end, func(row data.Row) error {
+		if row.Currency != pair[0] && row.Currency != pair[1] {
+			return nil
+		}
+		if days[row.Date] == nil {
+			days[row.Date] = map[string]data.FloatRate{}
+		}
+		days[row.Date][row.Currency] = row.Rate
+		return nil
+	})
+	if err != nil {
+		s.failRates(w, r, err)
+		return
+	}
+
+	res := StatsResponse{Pair: strings.Join(pair, "-"), Start: start.Format("2006-01-02"), End: end.Format("2006-01-02"), Missing: []string{}}
+	values := []float64{}
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		if rate, ok := crossRate(days[date], pair[0], pair[1]); ok {
+			values = append(values, float64(rate))
+		} else {
+			res.Missing = append(res.Missing, date)
+		}
+	}
+	if len(values) == 0 {
+		s.fail(w, r, http.StatusNotFound, CodeRatesUnavailable, "no rates available for the pair in the period")
+		return
+	}
+
+	summary := stats.Compute(values)
+	res.Days = len(values)
+	res.Open, res.Close = data.FloatRate(summary.Open), data.FloatRate(summary.Close)
+	res.Min, res.Max = data.FloatRate(summary.Min), data.FloatRate(summary.Max)
+	res.Mean, res.Median = data.FloatRate(summary.Mean), data.FloatRate(summary.Median)
+	res.StdDev, res.Change = data.FloatRate(summary.StdDev), summary.Change
+
+	err = s.respond(w, r, http.StatusOK, res)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
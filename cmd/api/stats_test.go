@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Stats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+	assert.Nil(t, db.Import(ctx, []data.Row{
+		{Date: "2024-04-17", Base: "USD", Currency: "UAH", Rate: 40},
+		{Date: "2024-04-17", Base: "USD", Currency: "EUR", Rate: 1},
+		{Date: "2024-04-18", Base: "USD", Currency: "UAH", Rate: 39},
+		{Date: "2024-04-23", Base: "USD", Currency: "UAH", Rate: 0},
+		{Date: "2024-04-23", Base: "USD", Currency: "EUR", Rate: 0.9},
+	}))
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON"}, db, ctx)
+	request := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	w := request("/v1/stats/UAH-EUR?start=2024-04-16&end=2024-04-23")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	resp := StatsResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	// 1/40, 0.8/39.4, 0.9/39.5 - EUR has no rate on 04-18, UAH is invalid on 04-23
+	assert.Equal(t, "UAH-EUR", resp.Pair)
+	assert.Equal(t, 3, resp.Days)
+	assert.Equal(t, data.FloatRate(0.025), resp.Open)
+	assert.Equal(t, data.FloatRate(0.9/39.5), resp.Close)
+	assert.InDelta(t, 0.8/39.4, float64(resp.Min), 1e-12)
+	assert.Equal(t, data.FloatRate(0.025), resp.Max)
+	assert.Equal(t, data.FloatRate(0.9/39.5), resp.Median)
+	assert.InDelta(t, -8.86, resp.Change, 0.01)
+	assert.Greater(t, float64(resp.StdDev), 0.0)
+	assert.Equal(t, []string{"2024-04-16", "2024-04-18", "2024-04-19", "2024-04-22", "2024-04-23"}, resp.Missing)
+
+	w = request("/v1/stats/UAH-EUR?start=2024-04-20&end=2024-04-21&format=csv")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "pair,start,end,days,open,close,min,max,mean,median,stddev,change,missing\n")
+	assert.Contains(t, w.Body.String(), "UAH-EUR,2024-04-20,2024-04-21,2,")
+
+	tbl := []struct {
+		url    string
+		status int
+		code   string
+	}{
+		{"/v1/stats/UAH-EUR?start=2024-04-21&end=2024-04-20", http.StatusBadRequest, CodeInvalidDate},
+		{"/v1/stats/UAH-EUR?start=2024-04-21", http.StatusBadRequest, CodeInvalidDate},
+		{"/v1/stats/UAH-EUR?start=2000-01-01&end=2024-01-01", http.StatusBadRequest, CodeInvalidDate},
+		{"/v1/stats/UAH-EUR?start=2024-04-21&end=2999-01-01", http.StatusBadRequest, CodeInvalidDate},
+		{"/v1/stats/UAH?start=2024-04-20&end=2024-04-21", http.StatusBadRequest, CodeInvalidPair},
+		{"/v1/stats/UAH-JPY?start=2024-04-20&end=2024-04-21", http.StatusBadRequest, CodeUnsupportedCurrency},
+		{"/v1/stats/UAH-EUR?start=2024-01-01&end=2024-01-31", http.StatusNotFound, CodeRatesUnavailable},
+	}
+	for _, tt := range tbl {
+		w = request(tt.url)
+		assert.Equal(t, tt.status, w.Code, tt.url)
+		resp := ErrorResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp), tt.url)
+		assert.Equal(t, tt.code, resp.Error.Code, tt.url)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/hub"
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+// streamReplay limits the number of snapshots sent on resume
+const streamReplay = 100
+
+// streamPaths are long-lived streams, WebSockets and exports, they are not limited by the request timeout
+var streamPaths = map[string]bool{"/v1/stream": true, "/v1/ws": true, "/v1/export": true}
+
+// publish sends the latest stored rates to the stream subscribers. Historical rates stored by
+// backfill, imports and requests of past dates are not published
+func (s *Server) publish(rates data.Rates) {
+	if n := s.hub.Publish(rates); n > 0 {
+		log.Printf("[DEBUG] rates of %s dropped for %d slow stream subscribers", rates.Date, n)
+	}
+}
+
+// writeEvent writes the rates as a server-sent event, the id is the unix time of the rates
+func writeEvent(w io.Writer, rates data.Rates) error {
+	b, err := json.Marshal(rates)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: rates\ndata: %s\n\n", rates.Date.Unix(), b)
+	return err
+}
+
+// Stream pushes the rates as server-sent events every time the latest rates are stored, optionally
+// filtered by the comma-separated list of symbols, and scoped to the currencies and the base of the tenant
+// like /v1/rates. Events older than the last sent one are skipped, the snapshots taken after
+// the Last-Event-ID are sent first on reconnect
+// GET /v1/stream[?symbols=EUR,UAH]
+func (s *Server) Stream(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	valid := validator.New()
+	symbols := splitSymbols(r.URL.Query().Get("symbols"))
+	for _, symbol := range symbols {
+		s.checkCurrency(r.Context(), valid, "symbols."+symbol, symbol)
+	}
+	var lastID int64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		lastID, err = strconv.ParseInt(id, 10, 64)
+		valid.Check(err == nil && lastID > 0, "Last-Event-ID", "invalid event id, use the id of the last received event")
+	}
+	if !valid.Valid() {
+		s.failValidation(w, r, valid)
+		return
+	}
+
+	updates, unsubscribe, err := s.hub.Subscribe()
+	if errors.Is(err, hub.ErrFull) {
+		w.Header().Set("Retry-After", "30")
+		s.fail(w, r, http.StatusServiceUnavailable, CodeTooManySubscribers, "too many stream subscribers, try again later")
+		return
+	}
+	defer unsubscribe()
+
+	s.logRequest(r.Context(), "stream", fmt.Sprintf("symbols: %s, last event: %d", strings.Join(symbols, ","), lastID))
+
+	// events are scoped without fetching on demand, the stream relays the refreshed rates only
+	scope, base := tenantScope(r.Context(), symbols)
+
+	// subscribed before reading the snapshots, so rates stored meanwhile are not missed
+	var replay []data.Rates
+	if lastID > 0 {
+		currencies := scope
+		if len(currencies) == 0 {
+			currencies = append(append([]string{}, s.currencies...), s.dynamic.list()...)
+		} else if base != "" {
+			currencies = append(currencies[:len(currencies):len(currencies)], base)
+		}
+		replay, err = s.db.Snapshots(r.Context(), currencies, time.Unix(lastID+1, 0), time.Now().Add(time.Minute))
+		if err != nil {
+			s.failInternal(w, r, "failed to read snapshots", err)
+			return
+		}
+		if len(replay) > streamReplay {
+			replay = replay[len(replay)-streamReplay:]
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	// the stream is not limited by the server write timeout
+	if err = rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("[WARN] request %s: failed to reset write deadline: %v", requestID(r.Context()), err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(rates data.Rates) error {
+		if rates.Date.Unix() <= lastID {
+			return nil
+		}
+		rates, err := scoped(rates, scope, base)
+		if err != nil {
+			// nothing of the scope in the event
+			return nil
+		}
+		if err := writeEvent(w, rates); err != nil {
+			return err
+		}
+		lastID = rates.Date.Unix()
+		return rc.Flush()
+	}
+
+	for _, rates := range replay {
+		if err = send(rates); err != nil {
+			return
+		}
+	}
+	if err = rc.Flush(); err != nil {
+		return
+	}
+
+	var heartbeat <-chan time.Time
+	if s.cfg.StreamHeartbeat > 0 {
+		ticker := time.NewTicker(time.Duration(s.cfg.StreamHeartbeat) * time.Second)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		case rates := <-updates:
+			err = send(rates)
+		case <-heartbeat:
+			if _, err = io.WriteString(w, ": heartbeat\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Stream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	at := func(hour int) data.Rates {
+		return data.Rates{
+			Date:  data.Date{Time: time.Date(2024, 4, 22, hour, 0, 0, 0, time.UTC)},
+			Base:  "USD",
+			Rates: map[string]data.FloatRate{"UAH": data.FloatRate(39 + hour), "EUR": 0.9, "RON": 4.7},
+		}
+	}
+	for _, hour := range []int{8, 9, 10} {
+		assert.Nil(t, db.WriteSnapshot(ctx, at(hour)))
+	}
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", StreamMax: 2, StreamHeartbeat: 1, Timeout: 1}, db, ctx)
+	ts := httptest.NewServer(s.router())
+	defer ts.Close()
+
+	// stream opens a stream and returns the reader of the events
+	stream := func(url, lastID string) (*http.Response, func() string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+url, nil)
+		assert.Nil(t, err)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		reader := bufio.NewReader(resp.Body)
+		return resp, func() string {
+			var event []string
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return strings.Join(event, "\n")
+				}
+				if line = strings.TrimSuffix(line, "\n"); line == "" {
+					return strings.Join(event, "\n")
+				}
+				event = append(event, line)
+			}
+		}
+	}
+
+	resp, _ := stream("/v1/stream?symbols=XYZ", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+	resp, _ = stream("/v1/stream", "abc")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	// the snapshots after the last event are replayed, then the stored rates are pushed
+	resp, next := stream("/v1/stream?symbols=UAH", fmt.Sprint(at(8).Date.Unix()))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, fmt.Sprintf(`id: %d
+event: rates
+data: {"date":"2024-04-22 09:00:00+00","base":"USD","rates":{"UAH":48}}`, at(9).Date.Unix()), next())
+	assert.Equal(t, fmt.Sprintf(`id: %d
+event: rates
+data: {"date":"2024-04-22 10:00:00+00","base":"USD","rates":{"UAH":49}}`, at(10).Date.Unix()), next())
+
+	// the second subscriber gets every currency, the third one is over the limit
+	resp2, next2 := stream("/v1/stream", "")
+	assert.Equal(t, http.StatusOK, resp2.StatusCode)
+	resp3, _ := stream("/v1/stream", "")
+	assert.Equal(t, http.StatusServiceUnavailable, resp3.StatusCode)
+	assert.Equal(t, "30", resp3.Header.Get("Retry-After"))
+	resp3.Body.Close()
+
+	// rates older than the last event are skipped, the stream outlives the request timeout
+	time.Sleep(1100 * time.Millisecond)
+	// rates stored by backfill and requests of past dates are not published
+	_, err = s.ingest(ctx, at(12))
+	assert.Nil(t, err)
+	_, err = s.storeLatest(ctx, at(7))
+	assert.Nil(t, err)
+	_, err = s.storeLatest(ctx, at(11))
+	assert.Nil(t, err)
+	assert.Equal(t, ": heartbeat", next())
+	assert.Equal(t, fmt.Sprintf(`id: %d
+event: rates
+data: {"date":"2024-04-22 11:00:00+00","base":"USD","rates":{"UAH":50}}`, at(11).Date.Unix()), next())
+	assert.Equal(t, ": heartbeat", next2())
+	assert.Contains(t, next2(), `"2024-04-22 07:00:00+00"`)
+	assert.Contains(t, next2(), `"rates":{"EUR":0.9,"RON":4.7,"UAH":50}`)
+
+	resp.Body.Close()
+	resp2.Body.Close()
+	assert.Eventually(t, func() bool { return s.hub.Len() == 0 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/tenant"
+)
+
+type tenantKey struct{}
+
+// tenantFrom returns the tenant of the request, requests without an API key have no tenant
+func tenantFrom(ctx context.Context) (tenant.Tenant, bool) {
+	t, ok := ctx.Value(tenantKey{}).(tenant.Tenant)
+	return t, ok
+}
+
+// errUnknownKey is returned for API keys of no tenant
+var errUnknownKey = errors.New("invalid api key")
+
+// errNoKey is returned for requests without an API key if the key is required
+var errNoKey = errors.New("api key is required")
+
+// limitTenant resolves the API key to its tenant and enforces the monthly quota and the rate limit of the
+// tenant, retry is the time to wait if a limit is reached. Reports false for requests with the admin key
+// and without a key unless RequireKey is set, which use the global configuration
+func (s *Server) limitTenant(ctx context.Context, key string) (t tenant.Tenant, ok bool, retry time.Duration, err error) {
+	if key == "" && s.cfg.RequireKey {
+		return t, false, 0, errNoKey
+	}
+	if key == "" || s.isAdminKey(key) {
+		return tenant.Tenant{}, false, 0, nil
+	}
+	if t, ok = s.tenants.Lookup(key); !ok {
+		return t, false, 0, errUnknownKey
+	}
+	retry, err = s.tenants.Allow(ctx, key)
+	return t, err == nil, retry, err
+}
+
+// isAdminKey reports whether the key is the admin key
+func (s *Server) isAdminKey(key string) bool {
+	return s.cfg.AdminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.cfg.AdminKey)) == 1
+}
+
+// tenant resolves the X-Api-Key header to its tenant and enforces the monthly quota and the rate limit
+// of the tenant. Requests without a key and with the admin key use the global configuration.
+// WebSocket handshakes may pass a tenant key in the api_key parameter as browsers can't set headers,
+// the admin key is not accepted there
+func (s *Server) tenant(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		key := r.Header.Get("X-Api-Key")
+		if key == "" && websocket.IsWebSocketUpgrade(r) {
+			if key = r.URL.Query().Get("api_key"); s.isAdminKey(key) {
+				s.fail(w, r, http.StatusUnauthorized, CodeUnauthorized, "invalid api key")
+				return
+			}
+		}
+
+		t, ok, retry, err := s.limitTenant(r.Context(), key)
+		switch {
+		case errors.Is(err, errUnknownKey), errors.Is(err, errNoKey):
+			s.fail(w, r, http.StatusUnauthorized, CodeUnauthorized, err.Error())
+			return
+		case errors.Is(err, tenant.ErrQuotaExceeded):
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			s.fail(w, r, http.StatusTooManyRequests, CodeQuotaExceeded, "monthly quota of "+strconv.FormatInt(t.MonthlyQuota, 10)+" requests exceeded")
+			return
+		case errors.Is(err, tenant.ErrRateLimited):
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			s.fail(w, r, http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded, try again later")
+			return
+		case err != nil:
+			s.failInternal(w, r, "failed to check the quota", err)
+			return
+		}
+		if ok {
+			r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, t))
+		}
+		h(w, r, ps)
+	}
+}
+
+// logRequest logs the request with the tenant and the route of the context,
+// logged requests count towards the quota of the tenant
+func (s *Server) logRequest(ctx context.Context, reqType, request string) {
+	t, _ := tenantFrom(ctx)
+	entry := data.LogEntry{Tenant: t.Name, Route: routeFrom(ctx), Type: reqType, Request: request}
+	if err := s.db.Log(ctx, entry); err != nil {
+		log.Printf("[ERROR] failed to log request: %v", err)
+		return
+	}
+	if t.Name != "" {
+		s.tenants.Count(t.Name)
+	}
+}
+
+// scopeRates limits the rates to the symbols, to the currencies of the tenant of the request if there are
+// no symbols, and rebases them to the default base of the tenant. Currencies missing in the rates are fetched
+// on demand. Returns ErrNoContent if no rates are left
+func (s *Server) scopeRates(ctx context.Context, rates data.Rates, symbols []string) (data.Rates, error) {
+	symbols, base := tenantScope(ctx, symbols)
+	if base == "" && len(symbols) == 0 {
+		return rates, nil
+	}
+
+	fetch := symbols
+	if base != "" {
+		fetch = append(symbols[:len(symbols):len(symbols)], base)
+	}
+	return scoped(s.fetchOnDemand(ctx, rates, fetch), symbols, base)
+}
+
+// tenantScope returns the symbols, the currencies of the tenant of the request if there are no symbols,
+// and the default base of the tenant, empty for the base of the stored rates
+func tenantScope(ctx context.Context, symbols []string) ([]string, string) {
+	t, _ := tenantFrom(ctx)
+	if len(symbols) == 0 {
+		symbols = t.Currencies
+	}
+	return symbols, t.Base
+}
+
+// scoped rebases the rates to the base unless it is empty and limits them to the symbols unless there are
+// none. Returns ErrNoContent if no rates are left
+func scoped(rates data.Rates, symbols []string, base string) (data.Rates, error) {
+	if base != "" {
+		rebased, ok := rates.Rebase(base)
+		if !ok {
+			return data.Rates{}, ErrNoContent
+		}
+		rates = rebased
+	}
+	if len(symbols) > 0 {
+		if rates = rates.Filter(symbols); len(rates.Rates) == 0 {
+			return data.Rates{}, ErrNoContent
+		}
+	}
+	return rates, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Tenants(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"date":"` + now.Format("2006-01-02 15:04:05") + `+00","base":"USD","rates":{"USD":"1","UAH":"40","EUR":"0.8","RON":"4.7"}}`))
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", AdminKey: "secret"}, db, ctx)
+	s.client.ApiUrl["latest"] = upstream.URL
+	s.client.ApiUrl["historical"] = upstream.URL
+	s.tenants = tenant.NewRegistry([]tenant.Tenant{
+		{Name: "billing", Key: "billing-key", Currencies: []string{"EUR", "RON", "USD"}, Base: "EUR", MonthlyQuota: 3},
+		{Name: "reports", Key: "reports-key", RateLimit: 0.001, Burst: 2},
+	}, db.Usage)
+	ts := httptest.NewServer(s.router())
+	defer ts.Close()
+
+	get := func(url, key string, res any) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+url, nil)
+		assert.Nil(t, err)
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(res))
+		return resp
+	}
+	date := data.Date{Time: time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC)}
+
+	// rates of a tenant are limited to its currencies and relative to its base
+	rates := data.Rates{}
+	resp := get("/v1/rates/2024-04-20", "billing-key", &rates)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, data.Rates{Date: date, Base: "EUR", Rates: map[string]data.FloatRate{"EUR": 1, "RON": 5.875, "USD": 1.25}}, rates)
+
+	// currencies outside of the set of the tenant are not enabled
+	errResp := ErrorResponse{}
+	resp = get("/v1/pair/UAH-EUR", "billing-key", &errResp)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, CodeUnsupportedCurrency, errResp.Error.Code)
+	assert.Equal(t, map[string]string{"pair": "currency is not enabled: UAH, use these: EUR,RON,USD"}, errResp.Error.Details)
+
+	pair := data.PairResponse{}
+	resp = get("/v1/pair/EUR-RON", "billing-key", &pair)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the same request is not limited without the tenant key and with the admin key
+	for _, key := range []string{"", "secret"} {
+		rates = data.Rates{}
+		resp = get("/v1/rates/2024-04-20", key, &rates)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, data.Rates{Date: date, Base: "USD", Rates: map[string]data.FloatRate{"UAH": 39.4, "EUR": 0.8, "RON": 4.7}}, rates)
+	}
+
+	// GraphQL rates are scoped the same way
+	body := strings.NewReader(`{"query": "{ rates(date: \"2024-04-20\", symbols: [\"RON\"]) { base rates { currency rate } } }"}`)
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/graphql", body)
+	assert.Nil(t, err)
+	req.Header.Set("X-Api-Key", "billing-key")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	gql := map[string]any{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&gql))
+	resp.Body.Close()
+	assert.Equal(t, map[string]any{"rates": map[string]any{"base": "EUR", "rates": []any{map[string]any{"currency": "RON", "rate": 5.875}}}}, gql["data"])
+
+	// logged requests are counted towards the monthly quota of the tenant
+	used, err := db.Usage(ctx, "billing", time.Now().AddDate(0, 0, -1))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), used)
+	errResp = ErrorResponse{}
+	resp = get("/v1/pair/EUR-RON", "billing-key", &errResp)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, CodeQuotaExceeded, errResp.Error.Code)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	// requests over the burst are rate limited
+	for i := 0; i < 2; i++ {
+		resp = get("/v1/pair/UAH-EUR", "reports-key", &pair)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	errResp = ErrorResponse{}
+	resp = get("/v1/pair/UAH-EUR", "reports-key", &errResp)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, CodeRateLimited, errResp.Error.Code)
+	assert.Equal(t, "1000", resp.Header.Get("Retry-After"))
+
+	errResp = ErrorResponse{}
+	resp = get("/v1/pair/UAH-EUR", "unknown-key", &errResp)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, CodeUnauthorized, errResp.Error.Code)
+
+	// requests without a key are rejected if a key is required, the admin key is still accepted
+	s.cfg.RequireKey = true
+	errResp = ErrorResponse{}
+	resp = get("/v1/rates/2024-04-20", "", &errResp)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, CodeUnauthorized, errResp.Error.Code)
+	assert.Equal(t, "api key is required", errResp.Error.Message)
+	rates = data.Rates{}
+	resp = get("/v1/rates/2024-04-20", "secret", &rates)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServer_TenantStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	at := func(hour int) data.Rates {
+		return data.Rates{
+			Date:  data.Date{Time: time.Date(2024, 4, 22, hour, 0, 0, 0, time.UTC)},
+			Base:  "USD",
+			Rates: map[string]data.FloatRate{"UAH": 40, "EUR": 0.8, "RON": data.FloatRate(hour) * 0.4},
+		}
+	}
+	for _, hour := range []int{8, 9} {
+		assert.Nil(t, db.WriteSnapshot(ctx, at(hour)))
+	}
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", StreamMax: 2}, db, ctx)
+	s.tenants = tenant.NewRegistry([]tenant.Tenant{
+		{Name: "billing", Key: "billing-key", Currencies: []string{"EUR", "RON", "USD"}, Base: "EUR"},
+	}, db.Usage)
+	ts := httptest.NewServer(s.router())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/stream", nil)
+	assert.Nil(t, err)
+	req.Header.Set("X-Api-Key", "billing-key")
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(at(8).Date.Unix(), 10))
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	reader := bufio.NewReader(resp.Body)
+	next := func() string {
+		for {
+			line, err := reader.ReadString('\n')
+			assert.Nil(t, err)
+			if strings.HasPrefix(line, "data: ") {
+				return strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}
+
+	// replayed and pushed events are limited to the currencies of the tenant and relative to its base
+	assert.Equal(t, `{"date":"2024-04-22 09:00:00+00","base":"EUR","rates":{"EUR":1,"RON":4.5,"USD":1.25}}`, next())
+	_, err = s.storeLatest(ctx, at(10))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"date":"2024-04-22 10:00:00+00","base":"EUR","rates":{"EUR":1,"RON":5,"USD":1.25}}`, next())
+}
+
+func Test_checkTenants(t *testing.T) {
+	cfg := Options{Currencies: "USD, UAH,EUR", AdminKey: "secret"}
+	assert.Nil(t, checkTenants([]tenant.Tenant{{Name: "a", Key: "k", Currencies: []string{"UAH", "EUR"}, Base: "UAH"}}, cfg))
+	assert.EqualError(t, checkTenants([]tenant.Tenant{{Name: "a", Key: "secret"}}, cfg), "tenant a: the key should differ from the admin key")
+	assert.EqualError(t, checkTenants([]tenant.Tenant{{Name: "a", Key: "k", Currencies: []string{"RON"}}}, cfg), "tenant a: currency RON is not enabled in --currencies")
+	assert.EqualError(t, checkTenants([]tenant.Tenant{{Name: "a", Key: "k", Base: "RON"}}, cfg), "tenant a: base RON is not enabled in --currencies")
+
+	cfg.OnDemand = true
+	assert.Nil(t, checkTenants([]tenant.Tenant{{Name: "a", Key: "k", Currencies: []string{"RON"}, Base: "RON"}}, cfg))
+
+	cfg.RequireKey = true
+	assert.Nil(t, checkTenants(nil, cfg))
+	cfg.AdminKey = ""
+	assert.EqualError(t, checkTenants(nil, cfg), "--require-key needs --tenants or --admin-key")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+type routeKey struct{}
+
+// withRoute sets the route of the request for the request log, e.g. "GET /v1/pair/:pair"
+func withRoute(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		h(w, r.WithContext(routeContext(r.Context(), route)), ps)
+	}
+}
+
+func routeContext(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// routeFrom returns the route of the request, empty if it is not set
+func routeFrom(ctx context.Context) string {
+	route, _ := ctx.Value(routeKey{}).(string)
+	return route
+}
+
+// UsageReport is the number of requests per API key by day or by route
+type UsageReport struct {
+	Key     string          `json:"key,omitempty"`
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	GroupBy string          `json:"group_by"`
+	Total   int64           `json:"total"`
+	Rows    []data.UsageRow `json:"rows"`
+}
+
+// MarshalCSV returns the rows of the report as a table
+func (u UsageReport) MarshalCSV() [][]string {
+	res := [][]string{{"key", u.GroupBy, "requests"}}
+	for _, row := range u.Rows {
+		value := row.Day
+		if u.GroupBy == data.UsageByRoute {
+			value = row.Route
+		}
+		res = append(res, []string{row.Key, value, strconv.FormatInt(row.Requests, 10)})
+	}
+	return res
+}
+
+// Usage reports the requests of the API keys from the daily rollup of the request log. The key is
+// the tenant name of the API key, all keys are reported if it is empty, requests without a key have
+// an empty key. The range is the current month by default, days are in the time zone of the server
+// GET /v1/admin/usage?key=billing&from=2024-04-01&to=2024-04-30&group_by=day|route[&format=csv]
+func (s *Server) Usage(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	now := time.Now()
+	report := UsageReport{
+		Key:     query.Get("key"),
+		From:    time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local).Format("2006-01-02"),
+		To:      now.Format("2006-01-02"),
+		GroupBy: data.UsageByDay,
+	}
+	if v := query.Get("from"); v != "" {
+		report.From = v
+	}
+	if v := query.Get("to"); v != "" {
+		report.To = v
+	}
+	if v := query.Get("group_by"); v != "" {
+		report.GroupBy = v
+	}
+
+	valid := validator.New()
+	from, err := time.Parse("2006-01-02", report.From)
+	valid.CheckCode(err == nil, "from", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	to, err := time.Parse("2006-01-02", report.To)
+	valid.CheckCode(err == nil, "to", CodeInvalidDate, "invalid date format, use 2006-01-02")
+	if valid.Valid() {
+		valid.CheckCode(!from.After(to), "from", CodeInvalidDate, "from should not be after to")
+	}
+	valid.Check(validator.PermittedValue(report.GroupBy, data.UsageByDay, data.UsageByRoute), "group_by", "invalid group_by, use day or route")
+	if !valid.Valid() {
+		s.failValidation(w, r, valid)
+		return
+	}
+
+	report.Rows, err = s.db.UsageReport(r.Context(), report.Key, from, to, report.GroupBy)
+	if err != nil {
+		s.failInternal(w, r, "failed to read usage", err)
+		return
+	}
+	for _, row := range report.Rows {
+		report.Total += row.Requests
+	}
+
+	err = s.respond(w, r, http.StatusOK, report)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Usage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", AdminKey: "secret"}, db, ctx)
+	s.tenants = tenant.NewRegistry([]tenant.Tenant{{Name: "billing", Key: "billing-key"}}, db.Usage)
+	ts := httptest.NewServer(s.router())
+	defer ts.Close()
+
+	get := func(url, key string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+url, nil)
+		assert.Nil(t, err)
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		return resp
+	}
+	for _, req := range []struct{ url, key string }{
+		{"/v1/rates/2024-04-20", "billing-key"},
+		{"/v1/rates/2024-04-21?symbols=EUR", "billing-key"},
+		{"/v1/stats/EUR-RON?start=2024-04-20&end=2024-04-21", "billing-key"},
+		{"/v1/rates/2024-04-20", ""},
+		// invalid requests are not logged
+		{"/v1/rates/2024-04-20?symbols=XYZ", "billing-key"},
+	} {
+		resp := get(req.url, req.key)
+		resp.Body.Close()
+	}
+
+	today := time.Now().Format("2006-01-02")
+	report := func(query string) UsageReport {
+		resp := get("/v1/admin/usage"+query, "secret")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, query)
+		res := UsageReport{}
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&res))
+		return res
+	}
+
+	assert.Equal(t, UsageReport{From: time.Now().Format("2006-01") + "-01", To: today, GroupBy: data.UsageByDay, Total: 4, Rows: []data.UsageRow{
+		{Key: "", Day: today, Requests: 1},
+		{Key: "billing", Day: today, Requests: 3},
+	}}, report(""))
+
+	assert.Equal(t, UsageReport{Key: "billing", From: today, To: today, GroupBy: data.UsageByRoute, Total: 3, Rows: []data.UsageRow{
+		{Key: "billing", Route: "GET /v1/rates/:date", Requests: 2},
+		{Key: "billing", Route: "GET /v1/stats/:pair", Requests: 1},
+	}}, report("?key=billing&from="+today+"&to="+today+"&group_by=route"))
+
+	assert.Equal(t, UsageReport{From: "2024-04-01", To: "2024-04-30", GroupBy: data.UsageByDay, Rows: []data.UsageRow{}}, report("?from=2024-04-01&to=2024-04-30"))
+
+	// reports are exportable as tables
+	resp := get("/v1/admin/usage?group_by=route&format=csv", "secret")
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "key,route,requests\n,GET /v1/rates/:date,1\nbilling,GET /v1/rates/:date,2\nbilling,GET /v1/stats/:pair,1\n", string(body))
+
+	tbl := []struct {
+		query   string
+		status  int
+		details map[string]string
+	}{
+		{"?from=01.04.2024&to=2024-04-30", http.StatusBadRequest, map[string]string{"from": "invalid date format, use 2006-01-02"}},
+		{"?from=2024-04-30&to=2024-04-01", http.StatusBadRequest, map[string]string{"from": "from should not be after to"}},
+		{"?group_by=month", http.StatusBadRequest, map[string]string{"group_by": "invalid group_by, use day or route"}},
+	}
+	for _, tt := range tbl {
+		resp = get("/v1/admin/usage"+tt.query, "secret")
+		assert.Equal(t, tt.status, resp.StatusCode, tt.query)
+		errResp := ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&errResp))
+		resp.Body.Close()
+		assert.Equal(t, tt.details, errResp.Error.Details, tt.query)
+	}
+
+	// tenant keys are not admin keys
+	resp = get("/v1/admin/usage", "billing-key")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/validator"
+	"github.com/parmaster/currency-api/internal/webhook"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+	webhookBackoff = time.Second
+	// webhookStopTimeout is the time the running deliveries get to log their attempts on shutdown
+	webhookStopTimeout = 5 * time.Second
+	// deliveriesLimit is the number of recent delivery attempts listed
+	deliveriesLimit = 100
+)
+
+var conditions = []string{data.ConditionAbove, data.ConditionBelow, data.ConditionChange}
+
+// notify evaluates the active subscriptions against the rates, triggered alerts are delivered in the background
+func (s *Server) notify(ctx context.Context, rates data.Rates) {
+	s.alerts.Lock()
+	defer s.alerts.Unlock()
+
+	subs, err := s.db.Subscriptions(ctx, true)
+	if err != nil {
+		log.Printf("[ERROR] failed to read subscriptions: %v", err)
+		return
+	}
+	for _, sub := range subs {
+		pair := strings.Split(sub.Pair, "-")
+		rate, ok := crossRate(rates.Rates, pair[0], pair[1])
+		if !ok {
+			continue
+		}
+		fire, reference := webhook.Evaluate(sub.Condition, sub.Value, sub.Reference, float64(rate))
+		if reference != sub.Reference {
+			if err = s.db.SetReference(ctx, sub.ID, reference); err != nil {
+				log.Printf("[ERROR] failed to update subscription %d: %v", sub.ID, err)
+				continue
+			}
+		}
+		if !fire {
+			continue
+		}
+
+		alert := data.Alert{
+			Event:          randomHex(8),
+			SubscriptionID: sub.ID,
+			Pair:           sub.Pair,
+			Condition:      sub.Condition,
+			Value:          sub.Value,
+			Rate:           rate,
+			Previous:       data.FloatRate(sub.Reference),
+			Time:           rates.Date.UTC().Format(time.RFC3339),
+		}
+		s.deliveries.Add(1)
+		go s.deliver(sub, alert)
+	}
+}
+
+// waitDeliveries waits for the running deliveries up to the timeout, reports false if they are still running
+func (s *Server) waitDeliveries(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.deliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// deliver posts the alert and logs every attempt, the subscription is disabled after too many failed deliveries
+func (s *Server) deliver(sub data.Subscription, alert data.Alert) {
+	defer s.deliveries.Done()
+
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("[ERROR] failed to marshal alert %s: %v", alert.Event, err)
+		return
+	}
+
+	sendErr := s.webhooks.Send(s.ctx, sub.URL, sub.Secret, alert.Event, payload, func(attempt, status int, err error) {
+		d := data.Delivery{SubscriptionID: sub.ID, Event: alert.Event, Attempt: attempt, Status: status}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		if lerr := s.db.LogDelivery(context.WithoutCancel(s.ctx), d); lerr != nil {
+			log.Printf("[ERROR] failed to log delivery of alert %s: %v", alert.Event, lerr)
+		}
+	})
+	if s.ctx.Err() != nil {
+		// shutting down, not a failure of the receiver
+		return
+	}
+	if sendErr != nil {
+		log.Printf("[WARN] failed to deliver alert %s of subscription %d: %v", alert.Event, sub.ID, sendErr)
+	}
+
+	active, err := s.db.RecordDelivery(s.ctx, sub.ID, sendErr == nil, s.cfg.WebhookMaxFailures)
+	if errors.Is(err, store.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] failed to record delivery of subscription %d: %v", sub.ID, err)
+		return
+	}
+	if sendErr != nil && !active {
+		log.Printf("[WARN] subscription %d disabled after %d failed deliveries", sub.ID, s.cfg.WebhookMaxFailures)
+	}
+}
+
+// subscriptionRequest is the body of the create and update requests
+type subscriptionRequest struct {
+	Pair      string  `json:"pair"`
+	Condition string  `json:"condition"`
+	Value     float64 `json:"value"`
+	URL       string  `json:"url"`
+	Secret    string  `json:"secret"`
+	Active    *bool   `json:"active"`
+}
+
+// checkSubscription validates the subscription settings
+func (s *Server) checkSubscription(ctx context.Context, v *validator.Validator, req subscriptionRequest) {
+	s.checkPair(ctx, v, req.Pair)
+	v.Check(validator.PermittedValue(req.Condition, conditions...), "condition", "invalid condition, use one of: "+strings.Join(conditions, ", "))
+	v.Check(req.Value > 0 && !math.IsInf(req.Value, 0), "value", "value should be a positive number")
+	u, err := url.Parse(req.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "invalid url, use an absolute http or https url")
+}
+
+// CreateSubscription creates a webhook subscription, a secret is generated unless set.
+// The secret is returned only in this response
+// POST /v1/subscriptions {"pair": "USD-UAH", "condition": "above", "value": 42, "url": "https://example.com/hook"}
+func (s *Server) CreateSubscription(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	req := subscriptionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.fail(w, r, http.StatusBadRequest, CodeInvalidBody, "invalid request body: "+err.Error())
+		return
+	}
+
+	valid := validator.New()
+	s.checkSubscription(r.Context(), valid, req)
+	if !valid.Valid() {
+		s.failValidation(w, r, valid)
+		return
+	}
+
+	sub := data.Subscription{Pair: req.Pair, Condition: req.Condition, Value: req.Value, URL: req.URL, Secret: req.Secret, Active: true}
+	if sub.Secret == "" {
+		sub.Secret = randomHex(16)
+	}
+	if req.Active != nil {
+		sub.Active = *req.Active
+	}
+	sub, err := s.db.CreateSubscription(r.Context(), sub)
+	if err != nil {
+		s.failInternal(w, r, "failed to create subscription", err)
+		return
+	}
+
+	err = s.respond(w, r, http.StatusCreated, sub)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
+
+// Subscriptions lists the webhook subscriptions
+// GET /v1/subscriptions
+func (s *Server) Subscriptions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	subs, err := s.db.Subscriptions(r.Context(), false)
+	if err != nil {
+		s.failInternal(w, r, "failed to read subscriptions", err)
+		return
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+
+	err = s.respond(w, r, http.StatusOK, subs)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
+
+// subscription reads the subscription of the id parameter, writes the error response if it fails
+func (s *Server) subscription(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (data.Subscription, bool) {
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		s.fail(w, r, http.StatusBadRequest, CodeInvalidRequest, "invalid subscription id")
+		return data.Subscription{}, false
+	}
+
+	sub, err := s.db.Subscription(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		s.fail(w, r, http.StatusNotFound, CodeNotFound, "subscription not found")
+		return sub, false
+	} else if err != nil {
+		s.failInternal(w, r, "failed to read subscription", err)
+		return sub, false
+	}
+	return sub, true
+}
+
+// Subscription returns the webhook subscription
+// GET /v1/subscriptions/:id
+func (s *Server) Subscription(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sub, ok := s.subscription(w, r, ps)
+	if !ok {
+		return
+	}
+	sub.Secret = ""
+
+	err := s.respond(w, r, http.StatusOK, sub)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
+
+// UpdateSubscription replaces the settings of the webhook subscription, the secret and the active flag
+// are kept unless set. Changed settings or reactivation reset the failures and the reference rate
+// PUT /v1/subscriptions/:id
+func (s *Server) UpdateSubscription(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sub, ok := s.subscription(w, r, ps)
+	if !ok {
+		return
+	}
+
+	req := subscriptionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.fail(w, r, http.StatusBadRequest, CodeInvalidBody, "invalid request body: "+err.Error())
+		return
+	}
+
+	valid := validator.New()
+	s.checkSubscription(r.Context(), valid, req)
+	if !valid.Valid() {
+		s.failValidation(w, r, valid)
+		return
+	}
+
+	changed := req.Pair != sub.Pair || req.Condition != sub.Condition || req.Value != sub.Value
+	reactivated := req.Active != nil && *req.Active && !sub.Active
+	if changed || reactivated {
+		sub.Failures, sub.Reference = 0, 0
+	}
+	sub.Pair, sub.Condition, sub.Value, sub.URL = req.Pair, req.Condition, req.Value, req.URL
+	if req.Secret != "" {
+		sub.Secret = req.Secret
+	}
+	if req.Active != nil {
+		sub.Active = *req.Active
+	}
+
+	// evaluation of the subscription should not interleave with the update of its state
+	s.alerts.Lock()
+	sub, err := s.db.UpdateSubscription(r.Context(), sub)
+	s.alerts.Unlock()
+	if err != nil {
+		s.failInternal(w, r, "failed to update subscription", err)
+		return
+	}
+	sub.Secret = ""
+
+	err = s.respond(w, r, http.StatusOK, sub)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
+
+// DeleteSubscription removes the webhook subscription and its delivery log
+// DELETE /v1/subscriptions/:id
+func (s *Server) DeleteSubscription(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sub, ok := s.subscription(w, r, ps)
+	if !ok {
+		return
+	}
+
+	err := s.db.DeleteSubscription(r.Context(), sub.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		s.failInternal(w, r, "failed to delete subscription", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries returns the recent delivery attempts of the webhook subscription
+// GET /v1/subscriptions/:id/deliveries
+func (s *Server) Deliveries(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sub, ok := s.subscription(w, r, ps)
+	if !ok {
+		return
+	}
+
+	deliveries, err := s.db.Deliveries(r.Context(), sub.ID, deliveriesLimit)
+	if err != nil {
+		s.failInternal(w, r, "failed to read deliveries", err)
+		return
+	}
+
+	err = s.respond(w, r, http.StatusOK, deliveries)
+	if err != nil {
+		s.failInternal(w, r, "failed to write response", err)
+	}
+}
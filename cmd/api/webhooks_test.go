@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Webhooks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	var uah string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"date":"2024-04-22 09:00:00+00","base":"USD","rates":{"USD":"1","UAH":"%s","EUR":"0.9"}}`, uah)
+	}))
+	defer upstream.Close()
+
+	var mu sync.Mutex
+	var alerts []data.Alert
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !webhook.Verify("topsecret", body, r.Header.Get(webhook.SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		alert := data.Alert{}
+		assert.Nil(t, json.Unmarshal(body, &alert))
+		assert.Equal(t, alert.Event, r.Header.Get("X-Event-ID"))
+		mu.Lock()
+		alerts = append(alerts, alert)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR", AdminKey: "secret", WebhookRetries: 1, WebhookMaxFailures: 2}, db, ctx)
+	s.client.ApiUrl["latest"] = upstream.URL
+	s.webhooks.Backoff = 0
+
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("X-Api-Key", "secret")
+		s.router().ServeHTTP(w, r)
+		return w
+	}
+
+	// CRUD
+	w := request(http.MethodPost, "/v1/subscriptions", `{"pair":"USD-UAH","condition":"above","value":42,"url":"`+receiver.URL+`","secret":"topsecret"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	above := data.Subscription{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &above))
+	assert.Equal(t, "topsecret", above.Secret, "returned on creation")
+	assert.True(t, above.Active)
+
+	// the secret is generated, the receiver rejects the signature
+	w = request(http.MethodPost, "/v1/subscriptions", `{"pair":"USD-UAH","condition":"change","value":1,"url":"`+receiver.URL+`"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	change := data.Subscription{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &change))
+	assert.Len(t, change.Secret, 32)
+
+	w = request(http.MethodPost, "/v1/subscriptions", `{"pair":"USD-UAH","condition":"around","value":-1,"url":"ftp://host"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	errResp := ErrorResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Len(t, errResp.Error.Details, 3)
+
+	w = request(http.MethodGet, "/v1/subscriptions", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "secret")
+	subs := []data.Subscription{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &subs))
+	assert.Len(t, subs, 2)
+
+	// above fires once when crossed, change fires on moves over 1% and fails to deliver
+	for _, rate := range []string{"41", "42.5", "42.7", "41"} {
+		uah = rate
+		s.refreshLatest()
+		s.deliveries.Wait()
+	}
+	mu.Lock()
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, above.ID, alerts[0].SubscriptionID)
+	assert.Equal(t, data.FloatRate(42.5), alerts[0].Rate)
+	assert.Equal(t, data.FloatRate(41), alerts[0].Previous)
+	assert.Equal(t, "2024-04-22T09:00:00Z", alerts[0].Time)
+	mu.Unlock()
+
+	w = request(http.MethodGet, fmt.Sprintf("/v1/subscriptions/%d/deliveries", above.ID), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	deliveries := []data.Delivery{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, http.StatusOK, deliveries[0].Status)
+
+	// 2 alerts with a retry each, disabled after 2 failed deliveries
+	w = request(http.MethodGet, fmt.Sprintf("/v1/subscriptions/%d/deliveries", change.ID), "")
+	deliveries = []data.Delivery{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+	assert.Len(t, deliveries, 4)
+	assert.Equal(t, http.StatusUnauthorized, deliveries[0].Status)
+	assert.Equal(t, "unexpected status 401", deliveries[0].Error)
+
+	w = request(http.MethodGet, fmt.Sprintf("/v1/subscriptions/%d", change.ID), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	change = data.Subscription{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &change))
+	assert.False(t, change.Active)
+	assert.Equal(t, 2, change.Failures)
+	assert.Empty(t, change.Secret)
+
+	// reactivated with the right secret
+	w = request(http.MethodPut, fmt.Sprintf("/v1/subscriptions/%d", change.ID), `{"pair":"USD-UAH","condition":"change","value":1,"url":"`+receiver.URL+`","secret":"topsecret","active":true}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	change = data.Subscription{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &change))
+	assert.True(t, change.Active)
+	assert.Equal(t, 0, change.Failures)
+	for _, rate := range []string{"41", "43"} {
+		uah = rate
+		s.refreshLatest()
+		s.deliveries.Wait()
+	}
+	mu.Lock()
+	assert.Len(t, alerts, 3, "above crossed again, change delivered")
+	mu.Unlock()
+
+	w = request(http.MethodDelete, fmt.Sprintf("/v1/subscriptions/%d", change.ID), "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = request(http.MethodGet, fmt.Sprintf("/v1/subscriptions/%d", change.ID), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = request(http.MethodPut, "/v1/subscriptions/x", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/subscriptions", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestServer_waitDeliveries(t *testing.T) {
+	s := NewServer(Options{}, nil, context.Background())
+	assert.True(t, s.waitDeliveries(time.Millisecond))
+
+	s.deliveries.Add(1)
+	assert.False(t, s.waitDeliveries(10*time.Millisecond))
+	time.AfterFunc(10*time.Millisecond, s.deliveries.Done)
+	assert.True(t, s.waitDeliveries(time.Second))
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/hub"
+	"github.com/parmaster/currency-api/internal/validator"
+)
+
+const (
+	// wsBuffer is the number of messages queued for a connection
+	wsBuffer = 32
+	// wsMaxDrops is the number of messages in a row dropped on a full queue before the slow connection is closed
+	wsMaxDrops  = 8
+	wsWriteWait = 10 * time.Second
+	wsReadLimit = 4096
+	// wsMaxPairs limits the subscriptions of a connection
+	wsMaxPairs = 50
+)
+
+// WebSocket message types
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsSubscribed  = "subscribed"
+	wsPair        = "pair"
+	wsError       = "error"
+)
+
+// wsRequest is a message of the client: {"action": "subscribe", "pairs": ["USD-UAH"]}
+type wsRequest struct {
+	Action string   `json:"action"`
+	Pairs  []string `json:"pairs"`
+	err    error
+}
+
+// wsMessage is a message of the server: the subscribed pairs, a pair update or an error
+type wsMessage struct {
+	Type  string   `json:"type"`
+	Pairs []string `json:"pairs,omitempty"`
+	*data.PairResponse
+	Error *APIError `json:"error,omitempty"`
+}
+
+// wsConn is a WebSocket client, its subscriptions are changed and updated only by the serving goroutine
+type wsConn struct {
+	s     *Server
+	conn  *websocket.Conn
+	send  chan wsMessage
+	done  chan struct{}
+	drops int
+	pairs map[string][]string
+	// last is the time of the last rates pushed, older rates are skipped
+	last time.Time
+}
+
+// WebSocket serves subscriptions to pairs over a WebSocket. Clients send {"action": "subscribe", "pairs": ["USD-UAH"]}
+// or "unsubscribe" and receive the subscribed pairs, the current rate of every new pair and the rates of the
+// subscribed pairs every time the latest rates are stored, rates older than the last pushed ones are skipped.
+// Messages are dropped while the client doesn't keep up, the connection is closed after too many dropped messages
+// GET /v1/ws
+func (s *Server) WebSocket(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	updates, unsubscribe, err := s.hub.Subscribe()
+	if errors.Is(err, hub.ErrFull) {
+		w.Header().Set("Retry-After", "30")
+		s.fail(w, r, http.StatusServiceUnavailable, CodeTooManySubscribers, "too many stream subscribers, try again later")
+		return
+	}
+	defer unsubscribe()
+
+	upgrader := websocket.Upgrader{
+		// clients are authenticated by the api key, so requests from any origin are accepted
+		CheckOrigin: func(*http.Request) bool { return true },
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			s.fail(w, r, status, CodeInvalidRequest, reason.Error())
+		},
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("[DEBUG] request %s: websocket upgrade failed: %v", requestID(r.Context()), err)
+		return
+	}
+	defer conn.Close()
+
+	s.logRequest(r.Context(), "ws", "connected")
+
+	c := &wsConn{s: s, conn: conn, send: make(chan wsMessage, wsBuffer), done: make(chan struct{}), pairs: map[string][]string{}}
+	defer close(c.done)
+	requests := c.read()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		c.write()
+	}()
+	defer func() {
+		close(c.send)
+		<-written
+	}()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			c.close(websocket.CloseGoingAway, "server is shutting down")
+			return
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			c.handle(r, req)
+		case rates := <-updates:
+			if !rates.Date.After(c.last) {
+				continue
+			}
+			c.last = rates.Date.Time
+			for _, pair := range c.pairs {
+				if res, ok := pairResponse(rates, pair); ok {
+					c.enqueue(wsMessage{Type: wsPair, PairResponse: &res})
+				}
+			}
+		}
+		if c.drops >= wsMaxDrops {
+			log.Printf("[WARN] request %s: closing slow websocket client", requestID(r.Context()))
+			c.close(websocket.ClosePolicyViolation, "slow consumer")
+			return
+		}
+	}
+}
+
+// read reads the requests of the client till the connection fails, the channel is closed then.
+// The read deadline is extended by every pong if pings are enabled
+func (c *wsConn) read() <-chan wsRequest {
+	requests := make(chan wsRequest)
+	c.conn.SetReadLimit(wsReadLimit)
+	// the server read timeout applies to the handshake only
+	pongWait := 2 * time.Duration(c.s.cfg.StreamHeartbeat) * time.Second
+	deadline := func() time.Time {
+		if pongWait <= 0 {
+			return time.Time{}
+		}
+		return time.Now().Add(pongWait)
+	}
+	c.conn.SetReadDeadline(deadline())
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(deadline())
+	})
+
+	go func() {
+		defer close(requests)
+		for {
+			_, msg, err := c.conn.ReadMessage()
+			if err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
+					log.Printf("[DEBUG] websocket read failed: %v", err)
+				}
+				return
+			}
+			req := wsRequest{}
+			req.err = json.Unmarshal(msg, &req)
+			select {
+			case requests <- req:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return requests
+}
+
+// write sends the queued messages and pings till the queue is closed
+func (c *wsConn) write() {
+	var ping <-chan time.Time
+	if c.s.cfg.StreamHeartbeat > 0 {
+		ticker := time.NewTicker(time.Duration(c.s.cfg.StreamHeartbeat) * time.Second)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+	failed := false
+	for {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				return
+			}
+			if failed {
+				// drain the queue, the serving goroutine stops when the reading fails
+				continue
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				log.Printf("[DEBUG] websocket write failed: %v", err)
+				failed = true
+				c.conn.Close()
+			}
+		case <-ping:
+			if failed {
+				continue
+			}
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				failed = true
+				c.conn.Close()
+			}
+		}
+	}
+}
+
+// enqueue queues the message without blocking, counts the messages dropped on a full queue
+func (c *wsConn) enqueue(msg wsMessage) {
+	select {
+	case c.send <- msg:
+		c.drops = 0
+	default:
+		c.drops++
+	}
+}
+
+func (c *wsConn) close(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+}
+
+func (c *wsConn) fail(code, message string, details map[string]string) {
+	c.enqueue(wsMessage{Type: wsError, Error: &APIError{Code: code, Message: message, Details: details}})
+}
+
+// handle changes the subscriptions, the current rates of the new pairs are sent after the subscribed pairs
+func (c *wsConn) handle(r *http.Request, req wsRequest) {
+	if req.err != nil {
+		c.fail(CodeInvalidBody, "invalid message: "+req.err.Error(), nil)
+		return
+	}
+	if req.Action != wsSubscribe && req.Action != wsUnsubscribe {
+		c.fail(CodeInvalidRequest, "invalid action, use subscribe or unsubscribe", nil)
+		return
+	}
+
+	valid := validator.New()
+	pairs := make([][]string, 0, len(req.Pairs))
+	for _, pairStr := range req.Pairs {
+		pv := validator.New()
+		pair := c.s.checkPair(r.Context(), pv, pairStr)
+		for _, msg := range pv.Errors {
+			valid.AddErrorCode("pairs."+pairStr, pv.Code(CodeInvalidRequest), msg)
+		}
+		pairs = append(pairs, pair)
+	}
+	valid.Check(len(req.Pairs) > 0, "pairs", "pairs should not be empty")
+	if !valid.Valid() {
+		c.fail(valid.Code(CodeInvalidRequest), "validation errors", valid.Errors)
+		return
+	}
+
+	added := [][]string{}
+	for _, pair := range pairs {
+		key := strings.Join(pair, "-")
+		_, subscribed := c.pairs[key]
+		switch {
+		case req.Action == wsUnsubscribe:
+			delete(c.pairs, key)
+		case subscribed:
+			// already subscribed, the current rate is not sent again
+		case len(c.pairs) >= wsMaxPairs:
+			c.fail(CodeInvalidRequest, fmt.Sprintf("too many pairs, up to %d are allowed", wsMaxPairs), nil)
+			return
+		default:
+			c.pairs[key] = pair
+			added = append(added, pair)
+		}
+	}
+
+	res := wsMessage{Type: wsSubscribed, Pairs: make([]string, 0, len(c.pairs))}
+	for key := range c.pairs {
+		res.Pairs = append(res.Pairs, key)
+	}
+	sort.Strings(res.Pairs)
+	c.enqueue(res)
+
+	for _, pair := range added {
+		rate, err := c.s.pairRate(r.Context(), pair)
+		if err != nil {
+			log.Printf("[DEBUG] request %s: no current rate of %s: %v", requestID(r.Context()), strings.Join(pair, "-"), err)
+			continue
+		}
+		c.enqueue(wsMessage{Type: wsPair, PairResponse: &rate})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/parmaster/currency-api/internal/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_WebSocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"date":"` + now.Format("2006-01-02 15:04:05") + `+00","base":"USD","rates":{"USD":"1","UAH":"40","EUR":"0.8","RON":"4.7"}}`))
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Options{Currencies: "USD,UAH,EUR,RON", AdminKey: "secret", StreamMax: 1, StreamHeartbeat: 1}, db, ctx)
+	s.tenants = tenant.NewRegistry([]tenant.Tenant{{Name: "web", Key: "web-key"}}, db.Usage)
+	s.client.ApiUrl["latest"] = upstream.URL
+	s.client.ApiUrl["historical"] = upstream.URL
+	// the rates of the day are stored before subscribing, so only the writes below are pushed
+	_, err = s.GetUpdateRates(ctx, time.Time{})
+	assert.Nil(t, err)
+	ts := httptest.NewServer(s.router())
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/v1/ws"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"X-Api-Key": []string{"unknown"}})
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// the admin key is not accepted in the parameter
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?api_key=secret", nil)
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"X-Api-Key": []string{"web-key"}})
+	assert.Nil(t, err)
+	defer conn.Close()
+	pings := make(chan struct{}, 10)
+	conn.SetPingHandler(func(string) error {
+		pings <- struct{}{}
+		return conn.WriteControl(websocket.PongMessage, nil, time.Now().Add(time.Second))
+	})
+
+	// the second connection is over the limit, the client key may be passed in the parameter
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?api_key=web-key", nil)
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	read := func() map[string]any {
+		msg := map[string]any{}
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		assert.Nil(t, conn.ReadJSON(&msg))
+		return msg
+	}
+
+	assert.Nil(t, conn.WriteMessage(websocket.TextMessage, []byte(`{`)))
+	msg := read()
+	assert.Equal(t, "error", msg["type"])
+	assert.Equal(t, CodeInvalidBody, msg["error"].(map[string]any)["code"])
+
+	assert.Nil(t, conn.WriteJSON(wsRequest{Action: "list"}))
+	msg = read()
+	assert.Equal(t, CodeInvalidRequest, msg["error"].(map[string]any)["code"])
+
+	assert.Nil(t, conn.WriteJSON(wsRequest{Action: wsSubscribe, Pairs: []string{"USD-UAH", "USD-XYZ"}}))
+	msg = read()
+	assert.Equal(t, map[string]any{"type": "error", "error": map[string]any{
+		"code":    CodeUnknownCurrency,
+		"message": "validation errors",
+		"details": map[string]any{"pairs.USD-XYZ": "unknown currency code: XYZ"},
+	}}, msg)
+
+	// the current rate is sent on subscribe
+	assert.Nil(t, conn.WriteJSON(wsRequest{Action: wsSubscribe, Pairs: []string{"USD-UAH", "UAH-EUR"}}))
+	assert.Equal(t, map[string]any{"type": "subscribed", "pairs": []any{"UAH-EUR", "USD-UAH"}}, read())
+	msg = read()
+	assert.Equal(t, "pair", msg["type"])
+	assert.Equal(t, "USD-UAH", msg["pair"])
+	assert.Equal(t, 40.0, msg["rate"])
+	msg = read()
+	assert.Equal(t, "UAH-EUR", msg["pair"])
+	assert.Equal(t, 0.02, msg["rate"])
+
+	// stored rates are pushed for the subscribed pairs
+	assert.Nil(t, conn.WriteJSON(wsRequest{Action: wsUnsubscribe, Pairs: []string{"UAH-EUR"}}))
+	assert.Equal(t, map[string]any{"type": "subscribed", "pairs": []any{"USD-UAH"}}, read())
+	// pings are answered while the client waits for the update
+	time.AfterFunc(1500*time.Millisecond, func() {
+		_, err := s.storeLatest(ctx, data.Rates{
+			Date:  data.Date{Time: now.Add(time.Second)},
+			Base:  "USD",
+			Rates: map[string]data.FloatRate{"USD": 1, "UAH": 41, "EUR": 0.8},
+		})
+		assert.Nil(t, err)
+	})
+	msg = read()
+	assert.Equal(t, "USD-UAH", msg["pair"])
+	assert.Equal(t, 41.0, msg["rate"])
+	assert.NotEmpty(t, pings)
+
+	// rates older than the last pushed ones are skipped
+	s.publish(data.Rates{Date: data.Date{Time: now}, Base: "USD", Rates: map[string]data.FloatRate{"USD": 1, "UAH": 39}})
+	s.publish(data.Rates{Date: data.Date{Time: now.Add(2 * time.Second)}, Base: "USD", Rates: map[string]data.FloatRate{"USD": 1, "UAH": 42}})
+	msg = read()
+	assert.Equal(t, "USD-UAH", msg["pair"])
+	assert.Equal(t, 42.0, msg["rate"])
+
+	// the connection is closed on shutdown
+	cancel()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+	assert.Eventually(t, func() bool { return s.hub.Len() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestWsConn_enqueue(t *testing.T) {
+	c := &wsConn{send: make(chan wsMessage, wsBuffer)}
+	for i := 0; i < wsBuffer+wsMaxDrops; i++ {
+		c.enqueue(wsMessage{Type: wsPair})
+	}
+	assert.Equal(t, wsMaxDrops, c.drops)
+
+	<-c.send
+	c.enqueue(wsMessage{Type: wsPair})
+	assert.Equal(t, 0, c.drops)
+}
@@ -0,0 +1,81 @@
+// Package anomaly detects invalid and suspicious rates before they are stored
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/parmaster/currency-api/internal/data"
+)
+
+// Detector checks rates against the previous rates of the currencies
+type Detector struct {
+	// Threshold is the max relative day-over-day change, e.g. 0.5 for 50%, disabled if 0
+	Threshold float64
+	// Thresholds override Threshold per currency
+	Thresholds map[string]float64
+}
+
+// ParseThresholds parses per-currency thresholds in the form "UAH:0.1,EUR:0.05"
+func ParseThresholds(s string) (map[string]float64, error) {
+	res := map[string]float64{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		code, value, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid threshold %q, use CODE:VALUE", part)
+		}
+		t, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || t < 0 || math.IsInf(t, 0) || math.IsNaN(t) {
+			return nil, fmt.Errorf("invalid threshold %q, should be a non-negative number", part)
+		}
+		res[strings.ToUpper(strings.TrimSpace(code))] = t
+	}
+	return res, nil
+}
+
+func (d Detector) threshold(currency string) float64 {
+	if t, ok := d.Thresholds[currency]; ok {
+		return t
+	}
+	return d.Threshold
+}
+
+// Check splits the rates into the clean ones and anomalies: rates which are not positive
+// finite numbers and rates changed by more than the threshold since the previous rate of the currency.
+// Currencies without a previous rate are checked for validity only
+func (d Detector) Check(rates data.Rates, previous map[string]data.FloatRate) (clean data.Rates, anomalies []data.Anomaly) {
+	clean = data.Rates{Date: rates.Date, Base: rates.Base, Rates: make(map[string]data.FloatRate, len(rates.Rates))}
+	for currency, rate := range rates.Rates {
+		anomaly := data.Anomaly{
+			Date:     rates.Date.String(),
+			Base:     rates.Base,
+			Currency: currency,
+			Rate:     strconv.FormatFloat(float64(rate), 'g', -1, 64),
+		}
+
+		if !rate.Valid() {
+			anomaly.Reason = "invalid rate"
+			anomalies = append(anomalies, anomaly)
+			continue
+		}
+
+		prev, ok := previous[currency]
+		if t := d.threshold(currency); ok && prev.Valid() && t > 0 {
+			change := math.Abs(float64(rate)/float64(prev) - 1)
+			if change > t {
+				anomaly.Previous = prev.String()
+				anomaly.Reason = fmt.Sprintf("change %.1f%% exceeds %.1f%%", change*100, t*100)
+				anomalies = append(anomalies, anomaly)
+				continue
+			}
+		}
+		clean.Rates[currency] = rate
+	}
+	return clean, anomalies
+}
@@ -0,0 +1,63 @@
+package anomaly
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetector_Check(t *testing.T) {
+	d := Detector{Threshold: 0.5, Thresholds: map[string]float64{"EUR": 0.05, "RON": 0}}
+	rates := data.Rates{
+		Date: data.Date{Time: time.Date(2024, 4, 21, 0, 0, 0, 0, time.UTC)},
+		Base: "USD",
+		Rates: map[string]data.FloatRate{
+			"UAH": 3940,   // 100x jump
+			"EUR": 0.9,    // 12.5% change, above the EUR threshold
+			"RON": 47,     // threshold disabled
+			"GBP": 0.81,   // within the threshold
+			"PLN": 4.1,    // no previous rate
+			"CZK": 0,      // parse error upstream
+			"JPY": -154.2, // negative
+			"CHF": data.FloatRate(math.Inf(1)),
+			"HUF": data.FloatRate(math.NaN()),
+		},
+	}
+	previous := map[string]data.FloatRate{"UAH": 39.4, "EUR": 0.8, "RON": 4.7, "GBP": 0.8}
+
+	clean, anomalies := d.Check(rates, previous)
+	assert.Equal(t, map[string]data.FloatRate{"RON": 47, "GBP": 0.81, "PLN": 4.1}, clean.Rates)
+	assert.Equal(t, "USD", clean.Base)
+	assert.Equal(t, rates.Date, clean.Date)
+
+	byCurrency := map[string]data.Anomaly{}
+	for _, a := range anomalies {
+		byCurrency[a.Currency] = a
+	}
+	assert.Len(t, byCurrency, 6)
+	assert.Equal(t, data.Anomaly{Date: "2024-04-21", Base: "USD", Currency: "UAH", Rate: "3940", Previous: "39.4", Reason: "change 9900.0% exceeds 50.0%"}, byCurrency["UAH"])
+	assert.Equal(t, "change 12.5% exceeds 5.0%", byCurrency["EUR"].Reason)
+	for _, c := range []string{"CZK", "JPY", "CHF", "HUF"} {
+		assert.Equal(t, "invalid rate", byCurrency[c].Reason, c)
+	}
+	assert.Equal(t, "+Inf", byCurrency["CHF"].Rate)
+	assert.Equal(t, "NaN", byCurrency["HUF"].Rate)
+}
+
+func TestParseThresholds(t *testing.T) {
+	res, err := ParseThresholds("uah:0.1, EUR:0.05,")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"UAH": 0.1, "EUR": 0.05}, res)
+
+	res, err = ParseThresholds("")
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+
+	for _, s := range []string{"UAH", "UAH:x", "UAH:-1", "UAH:NaN"} {
+		_, err = ParseThresholds(s)
+		assert.NotNil(t, err, s)
+	}
+}
@@ -0,0 +1,180 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/client"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+)
+
+// ErrLimitReached is returned when the job is paused because of the request limit or upstream quota
+var ErrLimitReached = errors.New("request limit reached")
+
+// Store keeps the rates and the state of backfill jobs
+type Store interface {
+	Read(ctx context.Context, date time.Time) (data.Rates, error)
+	Write(ctx context.Context, rates data.Rates) error
+	SetJobStatus(ctx context.Context, id int64, status string) error
+	SetDayStatus(ctx context.Context, id int64, date time.Time, status, errMsg string) error
+	PendingDays(ctx context.Context, id int64) ([]time.Time, error)
+}
+
+// Fetcher requests historical rates from the upstream
+type Fetcher interface {
+	GetHistorical(ctx context.Context, symbols string, date time.Time) (data.Rates, error)
+}
+
+// Runner fetches missing days of backfill jobs
+type Runner struct {
+	Store   Store
+	Fetcher Fetcher
+	Symbols string
+	// Workers is the number of concurrent upstream requests
+	Workers int
+	// MaxRequests limits the number of upstream requests per run, unlimited if 0
+	MaxRequests int
+}
+
+// Run processes pending days of the job until all of them are done, the context is
+// canceled or the request limit is reached. The state is persisted after every day,
+// so an interrupted job can be resumed by calling Run again
+func (r *Runner) Run(ctx context.Context, id int64) error {
+	days, err := r.Store.PendingDays(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to read pending days: %w", err)
+	}
+	if err = r.Store.SetJobStatus(ctx, id, data.JobRunning); err != nil {
+		return fmt.Errorf("failed to update job status: %w", err)
+	}
+	log.Printf("[INFO] backfill job %d: %d days pending", id, len(days))
+
+	workers := r.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	requests := 0
+	limited := false
+	// acquire reserves an upstream request, returns false if the limit is reached
+	acquire := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if limited || (r.MaxRequests > 0 && requests >= r.MaxRequests) {
+			limited = true
+			return false
+		}
+		requests++
+		return true
+	}
+	stop := func() {
+		mu.Lock()
+		limited = true
+		mu.Unlock()
+	}
+
+	queue := make(chan time.Time)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for day := range queue {
+				r.process(ctx, id, day, acquire, stop)
+			}
+		}()
+	}
+
+	for _, day := range days {
+		mu.Lock()
+		done := limited
+		mu.Unlock()
+		if done {
+			break
+		}
+		select {
+		case queue <- day:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	switch {
+	case ctx.Err() != nil:
+		// status stays running, the job is resumed on the next start
+		return ctx.Err()
+	case limited:
+		if err = r.Store.SetJobStatus(ctx, id, data.JobPaused); err != nil {
+			return fmt.Errorf("failed to update job status: %w", err)
+		}
+		return ErrLimitReached
+	}
+
+	if err = r.Store.SetJobStatus(ctx, id, data.JobDone); err != nil {
+		return fmt.Errorf("failed to update job status: %w", err)
+	}
+	log.Printf("[INFO] backfill job %d finished", id)
+	return nil
+}
+
+// process fetches and stores a single day, unless it is already stored.
+// The day stays pending if the context is canceled
+func (r *Runner) process(ctx context.Context, id int64, day time.Time, acquire func() bool, stop func()) {
+	if _, err := r.Store.Read(ctx, day); err == nil {
+		r.setDay(ctx, id, day, data.JobDone, "")
+		return
+	} else if ctx.Err() != nil {
+		return
+	} else if !errors.Is(err, store.ErrNotFound) {
+		r.setDay(ctx, id, day, data.JobFailed, err.Error())
+		return
+	}
+
+	if !acquire() {
+		return
+	}
+	rates, err := r.Fetcher.GetHistorical(ctx, r.Symbols, day)
+	if ctx.Err() != nil {
+		return
+	}
+	if errors.Is(err, client.ErrQuotaExceeded) {
+		// leave the day pending to be retried when the job is resumed
+		log.Printf("[WARN] backfill job %d: %v", id, err)
+		stop()
+		return
+	}
+	if err == nil && len(rates.Rates) == 0 {
+		err = errors.New("no rates returned")
+	}
+	if err != nil {
+		r.setDay(ctx, id, day, data.JobFailed, err.Error())
+		return
+	}
+
+	// the upstream may return the date with time
+	rates.Date = data.Date{Time: day}
+	if err = r.Store.Write(ctx, rates); err != nil {
+		r.setDay(ctx, id, day, data.JobFailed, err.Error())
+		return
+	}
+	r.setDay(ctx, id, day, data.JobDone, "")
+}
+
+func (r *Runner) setDay(ctx context.Context, id int64, day time.Time, status, errMsg string) {
+	if errMsg != "" {
+		log.Printf("[WARN] backfill job %d, %s: %s", id, day.Format("2006-01-02"), errMsg)
+	}
+	if err := r.Store.SetDayStatus(ctx, id, day, status, errMsg); err != nil {
+		log.Printf("[ERROR] backfill job %d: failed to update day status: %v", id, err)
+	}
+}
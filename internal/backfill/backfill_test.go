@@ -0,0 +1,152 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/client"
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/parmaster/currency-api/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockFetcher struct {
+	mu    sync.Mutex
+	calls []string
+	errs  map[string]error
+}
+
+func (m *mockFetcher) GetHistorical(_ context.Context, symbols string, date time.Time) (data.Rates, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	day := date.Format("2006-01-02")
+	m.calls = append(m.calls, day)
+	if err, ok := m.errs[day]; ok {
+		return data.Rates{}, err
+	}
+	return data.Rates{
+		Date:  data.Date{Time: date.Add(12 * time.Hour)},
+		Base:  "USD",
+		Rates: map[string]data.FloatRate{"UAH": 40, "EUR": 0.9},
+	}, nil
+}
+
+func day(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func Test_Runner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Import(ctx, store.DemoData))
+
+	// 2024-04-20 and 2024-04-21 are in the demo data
+	job, err := db.CreateJob(ctx, day("2024-04-18"), day("2024-04-23"))
+	assert.Nil(t, err)
+	assert.Equal(t, data.JobPending, job.Status)
+	assert.Equal(t, 6, job.Pending)
+
+	fetcher := &mockFetcher{errs: map[string]error{"2024-04-18": errors.New("boom")}}
+	r := Runner{Store: db, Fetcher: fetcher, Symbols: "UAH,EUR", Workers: 3, MaxRequests: 3}
+
+	// limited run: 3 out of 4 missing days are requested
+	err = r.Run(ctx, job.ID)
+	assert.ErrorIs(t, err, ErrLimitReached)
+	assert.Len(t, fetcher.calls, 3)
+	job, err = db.Job(ctx, job.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, data.JobPaused, job.Status)
+	assert.Equal(t, 6, job.Done+job.Failed+job.Pending)
+	assert.Equal(t, 1, job.Pending)
+
+	ids, err := db.UnfinishedJobs(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{job.ID}, ids)
+
+	// resumed
+	r.MaxRequests = 0
+	err = r.Run(ctx, job.ID)
+	assert.Nil(t, err)
+	assert.Len(t, fetcher.calls, 4)
+	job, err = db.Job(ctx, job.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, data.JobDone, job.Status)
+	assert.Equal(t, 5, job.Done)
+	assert.Equal(t, 1, job.Failed)
+	assert.Equal(t, 0, job.Pending)
+
+	ids, err = db.UnfinishedJobs(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, ids)
+
+	rates, err := db.Read(ctx, day("2024-04-22"))
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-04-22", rates.Date.String())
+	assert.Equal(t, data.FloatRate(40), rates.Rates["UAH"])
+
+	// existing data is not overwritten
+	rates, err = db.Read(ctx, day("2024-04-20"))
+	assert.Nil(t, err)
+	assert.Equal(t, data.FloatRate(39.4), rates.Rates["UAH"])
+}
+
+func Test_RunnerQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+
+	job, err := db.CreateJob(ctx, day("2024-03-01"), day("2024-03-10"))
+	assert.Nil(t, err)
+
+	fetcher := &mockFetcher{errs: map[string]error{"2024-03-01": client.ErrQuotaExceeded}}
+	r := Runner{Store: db, Fetcher: fetcher, Symbols: "UAH,EUR", Workers: 1}
+	err = r.Run(ctx, job.ID)
+	assert.ErrorIs(t, err, ErrLimitReached)
+
+	job, err = db.Job(ctx, job.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, data.JobPaused, job.Status)
+	assert.Equal(t, 10, job.Pending, "day rejected because of the quota stays pending")
+}
+
+type blockingFetcher struct {
+	started chan struct{}
+}
+
+func (b *blockingFetcher) GetHistorical(ctx context.Context, _ string, _ time.Time) (data.Rates, error) {
+	b.started <- struct{}{}
+	<-ctx.Done()
+	return data.Rates{}, ctx.Err()
+}
+
+func Test_RunnerCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := store.NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err)
+
+	job, err := db.CreateJob(ctx, day("2024-03-01"), day("2024-03-03"))
+	assert.Nil(t, err)
+
+	runCtx, runCancel := context.WithCancel(ctx)
+	fetcher := &blockingFetcher{started: make(chan struct{}, 3)}
+	r := Runner{Store: db, Fetcher: fetcher, Symbols: "UAH", Workers: 1}
+	go func() {
+		<-fetcher.started
+		runCancel()
+	}()
+	err = r.Run(runCtx, job.ID)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	job, err = db.Job(ctx, job.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, data.JobRunning, job.Status, "canceled job is resumed on the next start")
+	assert.Equal(t, 3, job.Pending, "in-flight day stays pending")
+}
@@ -0,0 +1,291 @@
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/currency"
+	"github.com/parmaster/currency-api/internal/data"
+)
+
+// Supported formats
+const (
+	CSV    = "csv"
+	JSON   = "json"
+	NDJSON = "ndjson"
+)
+
+// Formats lists the supported formats
+var Formats = []string{CSV, JSON, NDJSON}
+
+// ContentType returns the MIME type of the format
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv"
+	case NDJSON:
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+// ErrStore is returned when a batch of valid rows can't be stored
+var ErrStore = errors.New("failed to store rows")
+
+var csvHeader = []string{"date", "base", "currency", "rate"}
+
+// Encoder streams rows in one of the supported formats
+type Encoder struct {
+	format string
+	w      io.Writer
+	csv    *csv.Writer
+	rows   int
+}
+
+// NewEncoder creates an encoder writing rows in the format to w
+func NewEncoder(w io.Writer, format string) *Encoder {
+	e := &Encoder{format: format, w: w}
+	if format == CSV {
+		e.csv = csv.NewWriter(w)
+	}
+	return e
+}
+
+// Encode writes a single row
+func (e *Encoder) Encode(row data.Row) error {
+	defer func() { e.rows++ }()
+	switch e.format {
+	case CSV:
+		if e.rows == 0 {
+			if err := e.csv.Write(csvHeader); err != nil {
+				return err
+			}
+		}
+		return e.csv.Write([]string{row.Date, row.Base, row.Currency, strconv.FormatFloat(float64(row.Rate), 'f', -1, 64)})
+	case JSON:
+		prefix := ",\n"
+		if e.rows == 0 {
+			prefix = "[\n"
+		}
+		if _, err := io.WriteString(e.w, prefix); err != nil {
+			return err
+		}
+	}
+
+	js, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if e.format == NDJSON {
+		js = append(js, '\n')
+	}
+	_, err = e.w.Write(js)
+	return err
+}
+
+// Close finishes the output, it should be called after the last row
+func (e *Encoder) Close() error {
+	switch e.format {
+	case CSV:
+		if e.rows == 0 {
+			e.csv.Write(csvHeader)
+		}
+		e.csv.Flush()
+		return e.csv.Error()
+	case JSON:
+		closing := "\n]\n"
+		if e.rows == 0 {
+			closing = "[]\n"
+		}
+		_, err := io.WriteString(e.w, closing)
+		return err
+	}
+	return nil
+}
+
+// RowError is a validation error of a single imported row,
+// rows are numbered from 1, not counting the CSV header
+type RowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// Result is a summary of an import
+type Result struct {
+	Imported int        `json:"imported"`
+	Errors   []RowError `json:"errors"`
+}
+
+// Writer stores a batch of rows
+type Writer interface {
+	Import(ctx context.Context, rows []data.Row) error
+}
+
+// Import reads rows in the format from r, validates them and stores valid rows
+// in batches of batchSize. Invalid rows are reported in the result, an error is
+// returned only if the input can't be read or a batch can't be stored
+func Import(ctx context.Context, r io.Reader, format string, batchSize int, w Writer) (Result, error) {
+	res := Result{Errors: []RowError{}}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	batch := make([]data.Row, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := w.Import(ctx, batch); err != nil {
+			return fmt.Errorf("%w %d-%d: %w", ErrStore, res.Imported+1, res.Imported+len(batch), err)
+		}
+		res.Imported += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	n := 0
+	err := decode(r, format, func(row data.Row, decodeErr error) error {
+		n++
+		if decodeErr == nil {
+			decodeErr = Validate(&row)
+		}
+		if decodeErr != nil {
+			res.Errors = append(res.Errors, RowError{Row: n, Error: decodeErr.Error()})
+			return nil
+		}
+		batch = append(batch, row)
+		if len(batch) == batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	return res, flush()
+}
+
+// Validate checks the row and normalizes the date
+func Validate(row *data.Row) error {
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(row.Date))
+	if err != nil {
+		return fmt.Errorf("invalid date %q, use 2006-01-02", row.Date)
+	}
+	row.Date = date.Format("2006-01-02")
+	if !currency.Known(row.Base) {
+		return fmt.Errorf("unknown base currency %q", row.Base)
+	}
+	if !currency.Known(row.Currency) {
+		return fmt.Errorf("unknown currency %q", row.Currency)
+	}
+	if rate := float64(row.Rate); rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return fmt.Errorf("invalid rate %v", rate)
+	}
+	return nil
+}
+
+// decode calls fn for every row of the input. Rows which can't be decoded are passed with an error,
+// decoding stops if fn returns an error or the input is malformed beyond a single row
+func decode(r io.Reader, format string, fn func(data.Row, error) error) error {
+	switch format {
+	case CSV:
+		return decodeCSV(r, fn)
+	case JSON:
+		return decodeJSON(r, fn)
+	case NDJSON:
+		return decodeNDJSON(r, fn)
+	}
+	return fmt.Errorf("unsupported format %q, use one of %v", format, Formats)
+}
+
+func decodeCSV(r io.Reader, fn func(data.Row, error) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read csv header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, h := range csvHeader {
+		if _, ok := columns[h]; !ok {
+			return fmt.Errorf("csv header should contain columns %v", csvHeader)
+		}
+	}
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var row data.Row
+		if err == nil {
+			row, err = csvRow(record, columns)
+		}
+		if err = fn(row, err); err != nil {
+			return err
+		}
+	}
+}
+
+func csvRow(record []string, columns map[string]int) (data.Row, error) {
+	field := func(name string) string {
+		if i := columns[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	rate, err := strconv.ParseFloat(field("rate"), 64)
+	if err != nil {
+		return data.Row{}, fmt.Errorf("invalid rate %q", field("rate"))
+	}
+	return data.Row{Date: field("date"), Base: field("base"), Currency: field("currency"), Rate: data.FloatRate(rate)}, nil
+}
+
+func decodeJSON(r io.Reader, fn func(data.Row, error) error) error {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return errors.New("json input should be an array of rows")
+	}
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("malformed json: %w", err)
+		}
+		row := data.Row{}
+		err := json.Unmarshal(raw, &row)
+		if err = fn(row, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeNDJSON(r io.Reader, fn func(data.Row, error) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		row := data.Row{}
+		err := json.Unmarshal([]byte(line), &row)
+		if err = fn(row, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
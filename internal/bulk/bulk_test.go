@@ -0,0 +1,109 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockWriter struct {
+	batches [][]data.Row
+	err     error
+}
+
+func (m *mockWriter) Import(_ context.Context, rows []data.Row) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.batches = append(m.batches, append([]data.Row{}, rows...))
+	return nil
+}
+
+var rows = []data.Row{
+	{Date: "2024-04-20", Base: "USD", Currency: "EUR", Rate: 0.8},
+	{Date: "2024-04-20", Base: "USD", Currency: "UAH", Rate: 39.4},
+	{Date: "2024-04-21", Base: "USD", Currency: "EUR", Rate: 0.9},
+}
+
+func Test_EncodeDecode(t *testing.T) {
+	for _, format := range Formats {
+		buf := bytes.Buffer{}
+		enc := NewEncoder(&buf, format)
+		for _, row := range rows {
+			assert.Nil(t, enc.Encode(row), format)
+		}
+		assert.Nil(t, enc.Close(), format)
+
+		w := &mockWriter{}
+		res, err := Import(context.Background(), &buf, format, 2, w)
+		assert.Nil(t, err, format)
+		assert.Equal(t, 3, res.Imported, format)
+		assert.Empty(t, res.Errors, format)
+		assert.Equal(t, [][]data.Row{rows[:2], rows[2:]}, w.batches, format)
+	}
+}
+
+func Test_EncodeEmpty(t *testing.T) {
+	buf := bytes.Buffer{}
+	enc := NewEncoder(&buf, JSON)
+	assert.Nil(t, enc.Close())
+	assert.Equal(t, "[]\n", buf.String())
+
+	buf.Reset()
+	enc = NewEncoder(&buf, CSV)
+	assert.Nil(t, enc.Close())
+	assert.Equal(t, "date,base,currency,rate\n", buf.String())
+}
+
+func Test_ImportErrors(t *testing.T) {
+	input := `Currency, Rate, Date, Base
+EUR, 0.8, 2024-04-20, USD
+XYZ, 1, 2024-04-20, USD
+UAH, abc, 2024-04-20, USD
+UAH, 39.4, 20.04.2024, USD
+UAH, -1, 2024-04-20, USD
+UAH, 39.4, 2024-04-20, USD
+`
+	w := &mockWriter{}
+	res, err := Import(context.Background(), strings.NewReader(input), CSV, 10, w)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, res.Imported)
+	assert.Equal(t, []RowError{
+		{Row: 2, Error: `unknown currency "XYZ"`},
+		{Row: 3, Error: `invalid rate "abc"`},
+		{Row: 4, Error: `invalid date "20.04.2024", use 2006-01-02`},
+		{Row: 5, Error: `invalid rate -1`},
+	}, res.Errors)
+
+	input = `{"date":"2024-04-20","base":"USD","currency":"EUR","rate":0.8}
+{"date":"2024-04-20","base":"USD","currency":"EUR","rate":"0.9"}
+{"date":"2024-04-20","base":"USD","currency":"EUR","rate":"x"}
+{"date":
+`
+	res, err = Import(context.Background(), strings.NewReader(input), NDJSON, 10, w)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, res.Imported)
+	assert.Len(t, res.Errors, 2)
+	assert.Equal(t, 3, res.Errors[0].Row)
+	assert.Equal(t, 4, res.Errors[1].Row)
+
+	_, err = Import(context.Background(), strings.NewReader(`{"date":"2024-04-20"}`), JSON, 10, w)
+	assert.NotNil(t, err, "json should be an array")
+
+	_, err = Import(context.Background(), strings.NewReader("date,base,currency\n"), CSV, 10, w)
+	assert.NotNil(t, err, "rate column is missing")
+
+	_, err = Import(context.Background(), strings.NewReader(""), "xml", 10, w)
+	assert.NotNil(t, err)
+
+	w.err = errors.New("db error")
+	res, err = Import(context.Background(), strings.NewReader("date,base,currency,rate\n2024-04-20,USD,EUR,0.8\n"), CSV, 10, w)
+	assert.ErrorIs(t, err, ErrStore)
+	assert.ErrorContains(t, err, "failed to store rows 1-1: db error")
+	assert.Equal(t, 0, res.Imported)
+}
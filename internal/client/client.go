@@ -1,7 +1,9 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,9 +14,14 @@ import (
 	"github.com/parmaster/currency-api/internal/data"
 )
 
+// ErrQuotaExceeded is returned when the upstream rejects a request because of the plan limits
+var ErrQuotaExceeded = errors.New("upstream quota exceeded")
+
 type Client struct {
 	ApiUrl map[string]string
 	ApiKey string
+	// HTTPClient limits the time of every upstream request, callers add their own deadlines with the context
+	HTTPClient *http.Client
 }
 
 func New(apiKey string) *Client {
@@ -23,11 +30,12 @@ func New(apiKey string) *Client {
 			"latest":     "https://api.currencyfreaks.com/v2.0/rates/latest",
 			"historical": "https://api.currencyfreaks.com/v2.0/rates/historical",
 		},
-		ApiKey: apiKey,
+		ApiKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
-func (c *Client) request(endpoint string, parameters map[string]string) ([]byte, error) {
+func (c *Client) request(ctx context.Context, endpoint string, parameters map[string]string) ([]byte, error) {
 	params := url.Values{}
 	params.Add(`apikey`, c.ApiKey)
 	for k, v := range parameters {
@@ -35,7 +43,11 @@ func (c *Client) request(endpoint string, parameters map[string]string) ([]byte,
 	}
 	log.Printf("[DEBUG] CF request: %s?%s", c.ApiUrl[endpoint], params.Encode())
 
-	response, err := http.Get(fmt.Sprintf("%s?%s", c.ApiUrl[endpoint], params.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?%s", c.ApiUrl[endpoint], params.Encode()), nil)
+	if err != nil {
+		return []byte{}, err
+	}
+	response, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -48,6 +60,13 @@ func (c *Client) request(endpoint string, parameters map[string]string) ([]byte,
 	}
 	log.Printf("[DEBUG] CF Api response: %s", string(body))
 
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusPaymentRequired:
+		return []byte{}, fmt.Errorf("%w: %s", ErrQuotaExceeded, response.Status)
+	case response.StatusCode >= http.StatusBadRequest:
+		return []byte{}, fmt.Errorf("upstream error: %s", response.Status)
+	}
+
 	return body, nil
 }
 
@@ -60,8 +79,9 @@ func (c *Client) parseResponse(response []byte) (data.Rates, error) {
 	return rates, nil
 }
 
-func (c *Client) GetLatest(symbols string) (data.Rates, error) {
+func (c *Client) GetLatest(ctx context.Context, symbols string) (data.Rates, error) {
 	response, err := c.request(
+		ctx,
 		"latest",
 		map[string]string{
 			`symbols`: symbols,
@@ -74,8 +94,9 @@ func (c *Client) GetLatest(symbols string) (data.Rates, error) {
 	return c.parseResponse(response)
 }
 
-func (c *Client) GetHistorical(symbols string, date time.Time) (data.Rates, error) {
+func (c *Client) GetHistorical(ctx context.Context, symbols string, date time.Time) (data.Rates, error) {
 	response, err := c.request(
+		ctx,
 		"historical",
 		map[string]string{
 			`symbols`: symbols,
@@ -1,6 +1,9 @@
 package client
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -54,7 +57,7 @@ func Test_RequestLatestRates(t *testing.T) {
 		t.Skip("APIKEY not set")
 	}
 	client := New(os.Getenv("APIKEY"))
-	rates, err := client.GetLatest("USD,UAH,EUR,RON")
+	rates, err := client.GetLatest(context.Background(), "USD,UAH,EUR,RON")
 	assert.Nil(t, err)
 	assert.NotEmpty(t, rates)
 	assert.NotEmpty(t, rates.Date)
@@ -69,10 +72,40 @@ func Test_RequestHistoricalRates(t *testing.T) {
 		t.Skip("APIKEY not set")
 	}
 	client := New(os.Getenv("APIKEY"))
-	rates, err := client.GetHistorical("USD,UAH,EUR,RON", time.Now().AddDate(0, 0, -1))
+	rates, err := client.GetHistorical(context.Background(), "USD,UAH,EUR,RON", time.Now().AddDate(0, 0, -1))
 	assert.Nil(t, err)
 	assert.NotEmpty(t, rates)
 	assert.NotEmpty(t, rates.Date)
 	assert.NotEmpty(t, rates.Base)
 	assert.NotEmpty(t, rates.Rates)
 }
+
+func Test_RequestErrors(t *testing.T) {
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"date":"2024-04-29 12:34:56+00","base":"USD","rates":{"UAH":"39.65"}}`))
+	}))
+	defer ts.Close()
+
+	client := New("key")
+	client.ApiUrl["latest"] = ts.URL
+
+	rates, err := client.GetLatest(context.Background(), "UAH")
+	assert.Nil(t, err)
+	assert.Equal(t, data.FloatRate(39.65), rates.Rates["UAH"])
+
+	status = http.StatusTooManyRequests
+	_, err = client.GetLatest(context.Background(), "UAH")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	status = http.StatusInternalServerError
+	_, err = client.GetLatest(context.Background(), "UAH")
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, ErrQuotaExceeded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.GetLatest(ctx, "UAH")
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,75 @@
+package currency
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Currency describes an ISO 4217 currency
+type Currency struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	Numeric    string `json:"numeric"`
+	MinorUnits int    `json:"minor_units"`
+	Symbol     string `json:"symbol"`
+}
+
+//go:embed iso4217.csv
+var iso4217 []byte
+
+var catalog = mustLoad(iso4217)
+
+// Lookup returns the currency with the given ISO 4217 alphabetic code
+func Lookup(code string) (Currency, bool) {
+	c, ok := catalog[code]
+	return c, ok
+}
+
+// Known reports whether the code is a known ISO 4217 currency code
+func Known(code string) bool {
+	_, ok := catalog[code]
+	return ok
+}
+
+// All returns all currencies of the catalog, sorted by code
+func All() []Currency {
+	res := make([]Currency, 0, len(catalog))
+	for _, c := range catalog {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Code < res[j].Code })
+	return res
+}
+
+func load(table []byte) (map[string]Currency, error) {
+	records, err := csv.NewReader(bytes.NewReader(table)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("empty currency table")
+	}
+
+	res := make(map[string]Currency, len(records)-1)
+	// first line is a header: code,numeric,minor,name,symbol
+	for i, r := range records[1:] {
+		minor, err := strconv.Atoi(r[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid minor units %q: %w", i+2, r[2], err)
+		}
+		res[r[0]] = Currency{Code: r[0], Numeric: r[1], MinorUnits: minor, Name: r[3], Symbol: r[4]}
+	}
+	return res, nil
+}
+
+func mustLoad(table []byte) map[string]Currency {
+	res, err := load(table)
+	if err != nil {
+		panic("invalid ISO 4217 table: " + err.Error())
+	}
+	return res
+}
@@ -0,0 +1,46 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Lookup(t *testing.T) {
+	uah, ok := Lookup("UAH")
+	assert.True(t, ok)
+	assert.Equal(t, Currency{Code: "UAH", Name: "Hryvnia", Numeric: "980", MinorUnits: 2, Symbol: "₴"}, uah)
+
+	jpy, ok := Lookup("JPY")
+	assert.True(t, ok)
+	assert.Equal(t, 0, jpy.MinorUnits)
+
+	_, ok = Lookup("XYZ")
+	assert.False(t, ok)
+	assert.False(t, Known("usd"), "codes are case sensitive")
+	assert.True(t, Known("USD"))
+}
+
+func Test_All(t *testing.T) {
+	all := All()
+	assert.Equal(t, len(catalog), len(all))
+	for i := 1; i < len(all); i++ {
+		assert.Less(t, all[i-1].Code, all[i].Code, "should be sorted by code")
+	}
+	for _, c := range all {
+		assert.Len(t, c.Code, 3)
+		assert.Len(t, c.Numeric, 3)
+		assert.NotEmpty(t, c.Name)
+	}
+}
+
+func Test_Load(t *testing.T) {
+	_, err := load([]byte("code,numeric,minor,name,symbol\n"))
+	assert.NotNil(t, err)
+
+	_, err = load([]byte("code,numeric,minor,name,symbol\nUSD,840,x,US Dollar,$\n"))
+	assert.NotNil(t, err)
+
+	_, err = load([]byte("code,numeric,minor,name,symbol\nUSD,840,2,US Dollar\n"))
+	assert.NotNil(t, err, "wrong number of fields")
+}
@@ -0,0 +1,14 @@
+package data
+
+// Anomaly is a quarantined rate rejected on ingest
+type Anomaly struct {
+	ID       int64  `json:"id"`
+	Date     string `json:"date"`
+	Base     string `json:"base"`
+	Currency string `json:"currency"`
+	// Rate and Previous are formatted as text, the rate may be not a finite number
+	Rate     string `json:"rate"`
+	Previous string `json:"previous,omitempty"`
+	Reason   string `json:"reason"`
+	Created  string `json:"created"`
+}
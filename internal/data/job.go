@@ -0,0 +1,23 @@
+package data
+
+// Backfill job and day statuses
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobPaused  = "paused"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a backfill job with its progress
+type Job struct {
+	ID      int64  `json:"id"`
+	Start   Date   `json:"start"`
+	End     Date   `json:"end"`
+	Status  string `json:"status"`
+	Created string `json:"created"`
+	Updated string `json:"updated"`
+	Done    int    `json:"done"`
+	Failed  int    `json:"failed"`
+	Pending int    `json:"pending"`
+}
@@ -1,6 +1,8 @@
 package data
 
 import (
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +15,11 @@ type PairResponse struct {
 	Rate FloatRate `json:"rate"`
 }
 
+// MarshalCSV returns the pair as a table
+func (p PairResponse) MarshalCSV() [][]string {
+	return [][]string{{"date", "pair", "rate"}, {p.Date, p.Pair, p.Rate.String()}}
+}
+
 // RateResponse is a response from the API
 type RateResponse struct {
 	Date  Date                 `json:"date"`
@@ -59,8 +66,75 @@ type Rates struct {
 	Rates map[string]FloatRate `json:"rates"`
 }
 
+// MarshalCSV returns the rates as a table sorted by currency
+func (r Rates) MarshalCSV() [][]string {
+	currencies := make([]string, 0, len(r.Rates))
+	for c := range r.Rates {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+
+	res := [][]string{{"date", "base", "currency", "rate"}}
+	for _, c := range currencies {
+		res = append(res, []string{r.Date.String(), r.Base, c, r.Rates[c].String()})
+	}
+	return res
+}
+
+// Filter returns a copy of the rates containing only the given currencies,
+// currencies missing in the rates are skipped
+func (r Rates) Filter(currencies []string) Rates {
+	res := Rates{Date: r.Date, Base: r.Base, Rates: make(map[string]FloatRate, len(currencies))}
+	for _, c := range currencies {
+		if rate, ok := r.Rates[c]; ok {
+			res.Rates[c] = rate
+		}
+	}
+	return res
+}
+
+// Rebase returns a copy of the rates relative to the base currency, the previous base is added
+// if it is missing in the rates. Reports false if the rate of the base is not valid
+func (r Rates) Rebase(base string) (Rates, bool) {
+	if base == r.Base {
+		return r, true
+	}
+	baseRate := r.Rates[base]
+	if !baseRate.Valid() {
+		return r, false
+	}
+	res := Rates{Date: r.Date, Base: base, Rates: make(map[string]FloatRate, len(r.Rates)+1)}
+	res.Rates[r.Base] = 1 / baseRate
+	for c, rate := range r.Rates {
+		res.Rates[c] = rate / baseRate
+	}
+	res.Rates[base] = 1
+	return res, true
+}
+
+// Valid returns the rates without invalid values
+func (r Rates) Valid() Rates {
+	res := Rates{Date: r.Date, Base: r.Base, Rates: make(map[string]FloatRate, len(r.Rates))}
+	for c, rate := range r.Rates {
+		if rate.Valid() {
+			res.Rates[c] = rate
+		}
+	}
+	return res
+}
+
 type FloatRate float64
 
+func (r FloatRate) String() string {
+	return strconv.FormatFloat(float64(r), 'f', -1, 64)
+}
+
+// Valid reports whether the rate is a positive finite number
+func (r FloatRate) Valid() bool {
+	return r > 0 && !math.IsInf(float64(r), 0) && !math.IsNaN(float64(r))
+}
+
+// UnmarshalJSON parses a number or a quoted number, values which can't be parsed are 0 and not Valid
 func (r *FloatRate) UnmarshalJSON(data []byte) error {
 	dataStr := strings.Trim(string(data), "\"")
 	t, err := strconv.ParseFloat(dataStr, 64)
@@ -70,3 +144,18 @@ func (r *FloatRate) UnmarshalJSON(data []byte) error {
 	*r = FloatRate(t)
 	return nil
 }
+
+// Sources of the stored rates
+const (
+	SourceAPI    = "api"
+	SourceImport = "import"
+	SourceSeed   = "seed"
+)
+
+// Row is a single stored rate, used for import and export
+type Row struct {
+	Date     string    `json:"date"`
+	Base     string    `json:"base"`
+	Currency string    `json:"currency"`
+	Rate     FloatRate `json:"rate"`
+}
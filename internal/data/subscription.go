@@ -0,0 +1,54 @@
+package data
+
+// Subscription conditions
+const (
+	// ConditionAbove fires when the rate crosses the value upwards
+	ConditionAbove = "above"
+	// ConditionBelow fires when the rate crosses the value downwards
+	ConditionBelow = "below"
+	// ConditionChange fires when the rate moved by more than the value percent since the last alert
+	ConditionChange = "change"
+)
+
+// Subscription is a webhook alert on the rate of a pair
+type Subscription struct {
+	ID        int64   `json:"id"`
+	Pair      string  `json:"pair"`
+	Condition string  `json:"condition"`
+	Value     float64 `json:"value"`
+	URL       string  `json:"url"`
+	// Secret signs the payloads, returned only on creation
+	Secret string `json:"secret,omitempty"`
+	Active bool   `json:"active"`
+	// Failures is the number of consecutive failed deliveries
+	Failures int `json:"failures"`
+	// Reference is the rate the next one is compared with, 0 before the first evaluation
+	Reference float64 `json:"-"`
+	Created   string  `json:"created"`
+	Updated   string  `json:"updated"`
+}
+
+// Alert is the payload posted to the subscription URL
+type Alert struct {
+	Event          string    `json:"event"`
+	SubscriptionID int64     `json:"subscription_id"`
+	Pair           string    `json:"pair"`
+	Condition      string    `json:"condition"`
+	Value          float64   `json:"value"`
+	Rate           FloatRate `json:"rate"`
+	Previous       FloatRate `json:"previous,omitempty"`
+	// Time is the time of the rates reported by the provider
+	Time string `json:"time"`
+}
+
+// Delivery is an attempt to post an alert
+type Delivery struct {
+	ID             int64  `json:"id"`
+	SubscriptionID int64  `json:"subscription_id"`
+	Event          string `json:"event"`
+	Attempt        int    `json:"attempt"`
+	// Status is the HTTP status of the response, 0 if there was no response
+	Status  int    `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Created string `json:"created"`
+}
@@ -0,0 +1,29 @@
+package data
+
+import "time"
+
+// Usage report groupings
+const (
+	UsageByDay   = "day"
+	UsageByRoute = "route"
+)
+
+// LogEntry is a logged request
+type LogEntry struct {
+	// Time of the request, the current time if zero
+	Time time.Time
+	// Tenant of the API key, empty for requests without a key
+	Tenant string
+	// Route is the method and the path pattern, e.g. "GET /v1/pair/:pair"
+	Route   string
+	Type    string
+	Request string
+}
+
+// UsageRow is the number of requests of the API key on the day or to the route
+type UsageRow struct {
+	Key      string `json:"key"`
+	Day      string `json:"day,omitempty"`
+	Route    string `json:"route,omitempty"`
+	Requests int64  `json:"requests"`
+}
@@ -0,0 +1,72 @@
+// Package hub fans out the latest stored rates to the subscribers of the streaming endpoints
+package hub
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/parmaster/currency-api/internal/data"
+)
+
+// Buffer is the number of rates queued for a subscriber, rates published to a full queue
+// are dropped for that subscriber, so a slow client never blocks the writer
+const Buffer = 16
+
+// ErrFull is returned when the number of subscribers reached the limit
+var ErrFull = errors.New("too many subscribers")
+
+// Hub is a pub/sub of the rates, the zero value is not usable, use New
+type Hub struct {
+	mu   sync.Mutex
+	max  int
+	subs map[chan data.Rates]struct{}
+}
+
+// New returns a hub limited to max concurrent subscribers, unlimited if max is not positive
+func New(max int) *Hub {
+	return &Hub{max: max, subs: map[chan data.Rates]struct{}{}}
+}
+
+// Subscribe returns the channel of the published rates and the function to unsubscribe,
+// which closes the channel
+func (h *Hub) Subscribe() (<-chan data.Rates, func(), error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.max > 0 && len(h.subs) >= h.max {
+		return nil, nil, ErrFull
+	}
+
+	ch := make(chan data.Rates, Buffer)
+	h.subs[ch] = struct{}{}
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			delete(h.subs, ch)
+			close(ch)
+		})
+	}, nil
+}
+
+// Publish sends the rates to every subscriber without blocking, returns the number of
+// subscribers which dropped the rates
+func (h *Hub) Publish(rates data.Rates) (dropped int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- rates:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// Len returns the number of subscribers
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
@@ -0,0 +1,58 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHub(t *testing.T) {
+	h := New(2)
+	rates := data.Rates{Date: data.Date{Time: time.Date(2024, 4, 22, 9, 0, 0, 0, time.UTC)}, Base: "USD", Rates: map[string]data.FloatRate{"UAH": 39.5}}
+
+	a, unsubA, err := h.Subscribe()
+	assert.Nil(t, err)
+	b, unsubB, err := h.Subscribe()
+	assert.Nil(t, err)
+	_, _, err = h.Subscribe()
+	assert.ErrorIs(t, err, ErrFull)
+	assert.Equal(t, 2, h.Len())
+
+	assert.Equal(t, 0, h.Publish(rates))
+	assert.Equal(t, rates, <-a)
+	assert.Equal(t, rates, <-b)
+
+	// slow subscriber drops the rates over the buffer, others are not blocked
+	for i := 0; i < Buffer; i++ {
+		assert.Equal(t, 0, h.Publish(rates))
+		<-a
+	}
+	assert.Equal(t, 1, h.Publish(rates))
+	assert.Len(t, b, Buffer)
+
+	unsubB()
+	unsubB()
+	_, ok := <-a
+	assert.True(t, ok)
+	for range b {
+	}
+	assert.Equal(t, 1, h.Len())
+
+	_, unsubC, err := h.Subscribe()
+	assert.Nil(t, err)
+	unsubC()
+	unsubA()
+	assert.Equal(t, 0, h.Len())
+	assert.Equal(t, 0, h.Publish(rates))
+}
+
+func TestHub_Unlimited(t *testing.T) {
+	h := New(0)
+	for i := 0; i < 100; i++ {
+		_, _, err := h.Subscribe()
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 100, h.Len())
+}
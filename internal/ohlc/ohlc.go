@@ -0,0 +1,66 @@
+// Package ohlc aggregates timed values into open/high/low/close candles
+package ohlc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Intervals are the supported candle intervals. Weekly candles start on Monday
+var Intervals = map[string]time.Duration{
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+	"1w": 7 * 24 * time.Hour,
+}
+
+// ParseInterval returns the duration of the named interval
+func ParseInterval(name string) (time.Duration, error) {
+	if d, ok := Intervals[name]; ok {
+		return d, nil
+	}
+	names := make([]string, 0, len(Intervals))
+	for n := range Intervals {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("invalid interval %q, use one of: %s", name, strings.Join(names, ", "))
+}
+
+// Point is a value at a time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Candle describes the values of an interval starting at Time
+type Candle struct {
+	Time  time.Time
+	Open  float64
+	High  float64
+	Low   float64
+	Close float64
+	// Count is the number of values in the interval
+	Count int
+}
+
+// Aggregate groups the points in chronological order into candles of the interval, aligned to UTC.
+// Intervals without points are skipped
+func Aggregate(points []Point, interval time.Duration) []Candle {
+	res := []Candle{}
+	for _, p := range points {
+		// time.Time zero is Monday, January 1 of year 1, so truncation aligns weeks to Monday
+		start := p.Time.UTC().Truncate(interval)
+		if n := len(res); n > 0 && res[n-1].Time.Equal(start) {
+			c := &res[n-1]
+			c.High = max(c.High, p.Value)
+			c.Low = min(c.Low, p.Value)
+			c.Close = p.Value
+			c.Count++
+			continue
+		}
+		res = append(res, Candle{Time: start, Open: p.Value, High: p.Value, Low: p.Value, Close: p.Value, Count: 1})
+	}
+	return res
+}
@@ -0,0 +1,65 @@
+package ohlc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// series returns values taken every step starting at the time
+func series(start time.Time, step time.Duration, values ...float64) []Point {
+	res := make([]Point, len(values))
+	for i, v := range values {
+		res[i] = Point{Time: start.Add(time.Duration(i) * step), Value: v}
+	}
+	return res
+}
+
+func TestAggregate(t *testing.T) {
+	// Wednesday
+	start := time.Date(2024, 4, 17, 22, 30, 0, 0, time.UTC)
+	points := series(start, 20*time.Minute, 10, 12, 9, 11, 13, 8)
+
+	hourly := Aggregate(points, time.Hour)
+	assert.Equal(t, []Candle{
+		{Time: time.Date(2024, 4, 17, 22, 0, 0, 0, time.UTC), Open: 10, High: 12, Low: 10, Close: 12, Count: 2},
+		{Time: time.Date(2024, 4, 17, 23, 0, 0, 0, time.UTC), Open: 9, High: 13, Low: 9, Close: 13, Count: 3},
+		{Time: time.Date(2024, 4, 18, 0, 0, 0, 0, time.UTC), Open: 8, High: 8, Low: 8, Close: 8, Count: 1},
+	}, hourly)
+
+	daily := Aggregate(points, 24*time.Hour)
+	assert.Equal(t, []Candle{
+		{Time: time.Date(2024, 4, 17, 0, 0, 0, 0, time.UTC), Open: 10, High: 13, Low: 9, Close: 13, Count: 5},
+		{Time: time.Date(2024, 4, 18, 0, 0, 0, 0, time.UTC), Open: 8, High: 8, Low: 8, Close: 8, Count: 1},
+	}, daily)
+
+	// Sunday and Monday fall into different weeks
+	weekly := Aggregate(series(time.Date(2024, 4, 21, 12, 0, 0, 0, time.UTC), 24*time.Hour, 5, 6, 4), 7*24*time.Hour)
+	assert.Equal(t, []Candle{
+		{Time: time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC), Open: 5, High: 5, Low: 5, Close: 5, Count: 1},
+		{Time: time.Date(2024, 4, 22, 0, 0, 0, 0, time.UTC), Open: 6, High: 6, Low: 4, Close: 4, Count: 2},
+	}, weekly)
+
+	// gaps are skipped, local times are aligned to UTC
+	kyiv := time.FixedZone("EEST", 3*60*60)
+	gaps := []Point{
+		{Time: time.Date(2024, 4, 18, 1, 30, 0, 0, kyiv), Value: 1},
+		{Time: time.Date(2024, 4, 18, 5, 10, 0, 0, kyiv), Value: 2},
+	}
+	assert.Equal(t, []Candle{
+		{Time: time.Date(2024, 4, 17, 22, 0, 0, 0, time.UTC), Open: 1, High: 1, Low: 1, Close: 1, Count: 1},
+		{Time: time.Date(2024, 4, 18, 2, 0, 0, 0, time.UTC), Open: 2, High: 2, Low: 2, Close: 2, Count: 1},
+	}, Aggregate(gaps, time.Hour))
+
+	assert.Empty(t, Aggregate(nil, time.Hour))
+}
+
+func TestParseInterval(t *testing.T) {
+	d, err := ParseInterval("1w")
+	assert.Nil(t, err)
+	assert.Equal(t, 7*24*time.Hour, d)
+
+	_, err = ParseInterval("5m")
+	assert.EqualError(t, err, `invalid interval "5m", use one of: 1d, 1h, 1w`)
+}
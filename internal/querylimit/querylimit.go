@@ -0,0 +1,151 @@
+// Package querylimit measures the depth and the complexity of GraphQL queries,
+// so expensive queries are rejected before they are executed
+package querylimit
+
+import (
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// ListFactor is the estimated number of items of a list field, the complexity
+// of the selections of a list field is multiplied by it
+const ListFactor = 10
+
+// Cost is the measure of a query
+type Cost struct {
+	// Depth is the max nesting of fields, fields of the root are at depth 1
+	Depth int
+	// Complexity is the estimated number of resolved fields
+	Complexity int
+}
+
+// Measure returns the cost of the operation of the validated document, the only operation if the name is empty.
+// Introspection fields count towards the depth only
+func Measure(schema *graphql.Schema, doc *ast.Document, operationName string) Cost {
+	m := measurer{schema: schema, fragments: map[string]*ast.FragmentDefinition{}, visiting: map[string]bool{}}
+	var op *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			m.fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			if operationName == "" || (def.Name != nil && def.Name.Value == operationName) {
+				op = def
+			}
+		}
+	}
+	if op == nil {
+		return Cost{}
+	}
+
+	var root *graphql.Object
+	switch op.Operation {
+	case ast.OperationTypeMutation:
+		root = schema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = schema.SubscriptionType()
+	default:
+		root = schema.QueryType()
+	}
+	return m.selections(root, op.SelectionSet)
+}
+
+type measurer struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	// visiting guards against cycles of fragments, which are rejected by the validation anyway
+	visiting map[string]bool
+	// introspection is set within introspection fields, their selections have no complexity
+	introspection bool
+}
+
+// selections returns the cost of the selection set of the parent type
+func (m measurer) selections(parent graphql.Type, set *ast.SelectionSet) Cost {
+	res := Cost{}
+	if set == nil {
+		return res
+	}
+	add := func(c Cost) {
+		res.Depth = max(res.Depth, c.Depth)
+		res.Complexity += c.Complexity
+	}
+
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			add(m.field(parent, sel))
+		case *ast.InlineFragment:
+			add(m.selections(m.condition(parent, sel.TypeCondition), sel.SelectionSet))
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			fragment, ok := m.fragments[name]
+			if !ok || m.visiting[name] {
+				continue
+			}
+			m.visiting[name] = true
+			add(m.selections(m.condition(parent, fragment.TypeCondition), fragment.SelectionSet))
+			delete(m.visiting, name)
+		}
+	}
+	return res
+}
+
+// field returns the cost of the field including its selections
+func (m measurer) field(parent graphql.Type, field *ast.Field) Cost {
+	name := field.Name.Value
+	if m.introspection || strings.HasPrefix(name, "__") {
+		im := m
+		im.introspection = true
+		children := im.selections(nil, field.SelectionSet)
+		return Cost{Depth: children.Depth + 1}
+	}
+
+	var fieldType graphql.Type
+	if obj, ok := parent.(*graphql.Object); ok && obj != nil {
+		if def, ok := obj.Fields()[name]; ok {
+			fieldType = def.Type
+		}
+	}
+
+	factor := 1
+	if isList(fieldType) {
+		factor = ListFactor
+	}
+	children := m.selections(unwrap(fieldType), field.SelectionSet)
+	return Cost{Depth: children.Depth + 1, Complexity: 1 + factor*children.Complexity}
+}
+
+// condition returns the type of the fragment condition, the parent type if there is no condition
+func (m measurer) condition(parent graphql.Type, named *ast.Named) graphql.Type {
+	if named == nil || named.Name == nil {
+		return parent
+	}
+	if t := m.schema.Type(named.Name.Value); t != nil {
+		return t
+	}
+	return parent
+}
+
+func isList(t graphql.Type) bool {
+	if nonNull, ok := t.(*graphql.NonNull); ok {
+		t = nonNull.OfType
+	}
+	_, ok := t.(*graphql.List)
+	return ok
+}
+
+// unwrap returns the type of the items of list and non-null types
+func unwrap(t graphql.Type) graphql.Type {
+	for {
+		switch wrapped := t.(type) {
+		case *graphql.List:
+			t = wrapped.OfType
+		case *graphql.NonNull:
+			t = wrapped.OfType
+		default:
+			return t
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package querylimit
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSchema(t *testing.T) graphql.Schema {
+	item := graphql.NewObject(graphql.ObjectConfig{Name: "Item", Fields: graphql.Fields{
+		"name": &graphql.Field{Type: graphql.String},
+	}})
+	item.AddFieldConfig("children", &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(item))})
+	item.AddFieldConfig("parent", &graphql.Field{Type: item})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+		"item":  &graphql.Field{Type: item},
+		"items": &graphql.Field{Type: graphql.NewList(item)},
+	}})})
+	assert.Nil(t, err)
+	return schema
+}
+
+func TestMeasure(t *testing.T) {
+	schema := testSchema(t)
+
+	tbl := []struct {
+		query     string
+		operation string
+		cost      Cost
+	}{
+		{`{ item { name } }`, "", Cost{Depth: 2, Complexity: 2}},
+		{`{ a: item { name } b: item { name parent { name } } }`, "", Cost{Depth: 3, Complexity: 6}},
+		{`{ items { name } }`, "", Cost{Depth: 2, Complexity: 11}},
+		{`{ items { children { name } } }`, "", Cost{Depth: 3, Complexity: 1 + 10*(1+10)}},
+		{`{ item { ...f } } fragment f on Item { name parent { ... on Item { name } } }`, "", Cost{Depth: 3, Complexity: 4}},
+		{`query A { item { name } } query B { items { name } }`, "B", Cost{Depth: 2, Complexity: 11}},
+		{`query A { item { name } }`, "C", Cost{}},
+		// introspection is limited by the depth only
+		{`{ __schema { types { name fields { name } } } item { name } }`, "", Cost{Depth: 4, Complexity: 2}},
+		{`{ __type(name: "Item") { ofType { ofType { ofType { ...t } } } } } fragment t on __Type { ofType { name } }`, "", Cost{Depth: 6}},
+		{`{ item { __typename name } }`, "", Cost{Depth: 2, Complexity: 2}},
+	}
+
+	for _, tt := range tbl {
+		doc, err := parser.Parse(parser.ParseParams{Source: tt.query})
+		assert.Nil(t, err, tt.query)
+		assert.Equal(t, tt.cost, Measure(&schema, doc, tt.operation), tt.query)
+	}
+}
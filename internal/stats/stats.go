@@ -0,0 +1,58 @@
+// Package stats computes descriptive statistics of rate series
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// Summary describes a series of values
+type Summary struct {
+	Open   float64
+	Close  float64
+	Min    float64
+	Max    float64
+	Mean   float64
+	Median float64
+	// StdDev is the sample standard deviation, 0 for a single value
+	StdDev float64
+	// Change is the percent change from Open to Close
+	Change float64
+}
+
+// Compute returns the summary of the values in chronological order, values should not be empty
+func Compute(values []float64) Summary {
+	if len(values) == 0 {
+		return Summary{}
+	}
+
+	res := Summary{Open: values[0], Close: values[len(values)-1], Min: values[0], Max: values[0]}
+	sum := 0.0
+	for _, v := range values {
+		res.Min = math.Min(res.Min, v)
+		res.Max = math.Max(res.Max, v)
+		sum += v
+	}
+	res.Mean = sum / float64(len(values))
+
+	if len(values) > 1 {
+		squares := 0.0
+		for _, v := range values {
+			squares += (v - res.Mean) * (v - res.Mean)
+		}
+		res.StdDev = math.Sqrt(squares / float64(len(values)-1))
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	if mid := len(sorted) / 2; len(sorted)%2 == 1 {
+		res.Median = sorted[mid]
+	} else {
+		res.Median = (sorted[mid-1] + sorted[mid]) / 2
+	}
+
+	if res.Open != 0 {
+		res.Change = (res.Close/res.Open - 1) * 100
+	}
+	return res
+}
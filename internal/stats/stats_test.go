@@ -0,0 +1,28 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompute(t *testing.T) {
+	res := Compute([]float64{40, 38, 42, 39, 41, 44})
+	assert.Equal(t, 40.0, res.Open)
+	assert.Equal(t, 44.0, res.Close)
+	assert.Equal(t, 38.0, res.Min)
+	assert.Equal(t, 44.0, res.Max)
+	assert.InDelta(t, 40.6667, res.Mean, 1e-4)
+	assert.Equal(t, 40.5, res.Median)
+	assert.InDelta(t, 2.1602, res.StdDev, 1e-4)
+	assert.InDelta(t, 10.0, res.Change, 1e-9)
+
+	res = Compute([]float64{2, 1, 3})
+	assert.Equal(t, 2.0, res.Median)
+	assert.Equal(t, 50.0, res.Change)
+
+	res = Compute([]float64{39.4})
+	assert.Equal(t, Summary{Open: 39.4, Close: 39.4, Min: 39.4, Max: 39.4, Mean: 39.4, Median: 39.4}, res)
+
+	assert.Equal(t, Summary{}, Compute(nil))
+}
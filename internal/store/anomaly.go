@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+)
+
+// previousWindow limits how far back the previous rate of a currency is looked up
+const previousWindow = 7
+
+// PreviousRates returns the latest rate of every currency of the base stored
+// within a week before the date
+func (s *SQLiteStorage) PreviousRates(ctx context.Context, base string, date time.Time) (map[string]data.FloatRate, error) {
+
+	// sqlite takes bare columns from the row with the max value of the aggregate
+	q := "SELECT `currency`, `rate`, MAX(`date`) FROM `rates` WHERE `base` = $1 AND `date` < $2 AND `date` >= $3 AND (`source` != 'seed' OR $4) GROUP BY `currency`"
+	rows, err := s.DB.QueryContext(ctx, q, base, date.Format("2006-01-02"), date.AddDate(0, 0, -previousWindow).Format("2006-01-02"), s.ServeSeed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := map[string]data.FloatRate{}
+	var currency, latest string
+	var rate float64
+	for rows.Next() {
+		if err = rows.Scan(&currency, &rate, &latest); err != nil {
+			return nil, err
+		}
+		res[currency] = data.FloatRate(rate)
+	}
+	return res, rows.Err()
+}
+
+// Quarantine stores rates rejected on ingest
+func (s *SQLiteStorage) Quarantine(ctx context.Context, anomalies []data.Anomaly) error {
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	q := "INSERT INTO `quarantine` (`date`, `base`, `currency`, `rate`, `previous`, `reason`, `created`) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	for _, a := range anomalies {
+		if _, err = tx.ExecContext(ctx, q, a.Date, a.Base, a.Currency, a.Rate, a.Previous, a.Reason, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Quarantined returns the most recent quarantined rates
+func (s *SQLiteStorage) Quarantined(ctx context.Context, limit int) (res []data.Anomaly, err error) {
+
+	q := "SELECT `id`, `date`, `base`, `currency`, `rate`, `previous`, `reason`, `created` FROM `quarantine` ORDER BY `id` DESC LIMIT $1"
+	rows, err := s.DB.QueryContext(ctx, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := data.Anomaly{}
+		if err = rows.Scan(&a.ID, &a.Date, &a.Base, &a.Currency, &a.Rate, &a.Previous, &a.Reason, &a.Created); err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
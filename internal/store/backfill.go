@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+)
+
+// CreateJob creates a backfill job for the date range with all days pending
+func (s *SQLiteStorage) CreateJob(ctx context.Context, start, end time.Time) (job data.Job, err error) {
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return job, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	q := `INSERT INTO backfill_jobs(start, end, status, created, updated) VALUES ($1, $2, $3, $4, $4)`
+	res, err := tx.ExecContext(ctx, q, start.Format("2006-01-02"), end.Format("2006-01-02"), data.JobPending, now)
+	if err != nil {
+		return job, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return job, err
+	}
+
+	q = `INSERT INTO backfill_days(job_id, date, status, error) VALUES ($1, $2, $3, '')`
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if _, err = tx.ExecContext(ctx, q, id, d.Format("2006-01-02"), data.JobPending); err != nil {
+			return job, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return job, err
+	}
+	return s.Job(ctx, id)
+}
+
+// Job returns the backfill job with its progress
+func (s *SQLiteStorage) Job(ctx context.Context, id int64) (job data.Job, err error) {
+
+	var start, end string
+	q := `SELECT id, start, end, status, created, updated FROM backfill_jobs WHERE id = $1`
+	err = s.DB.QueryRowContext(ctx, q, id).Scan(&job.ID, &start, &end, &job.Status, &job.Created, &job.Updated)
+	if err == sql.ErrNoRows {
+		return job, ErrNotFound
+	}
+	if err != nil {
+		return job, err
+	}
+	if err = job.Start.ParseDate(start); err != nil {
+		return job, err
+	}
+	if err = job.End.ParseDate(end); err != nil {
+		return job, err
+	}
+
+	q = `SELECT status, COUNT(*) FROM backfill_days WHERE job_id = $1 GROUP BY status`
+	rows, err := s.DB.QueryContext(ctx, q, id)
+	if err != nil {
+		return job, err
+	}
+	defer rows.Close()
+
+	var status string
+	var cnt int
+	for rows.Next() {
+		if err = rows.Scan(&status, &cnt); err != nil {
+			return job, err
+		}
+		switch status {
+		case data.JobDone:
+			job.Done = cnt
+		case data.JobFailed:
+			job.Failed = cnt
+		default:
+			job.Pending += cnt
+		}
+	}
+	return job, rows.Err()
+}
+
+// UnfinishedJobs returns ids of the backfill jobs to be resumed
+func (s *SQLiteStorage) UnfinishedJobs(ctx context.Context) (ids []int64, err error) {
+
+	q := `SELECT id FROM backfill_jobs WHERE status IN ($1, $2, $3) ORDER BY id`
+	rows, err := s.DB.QueryContext(ctx, q, data.JobPending, data.JobRunning, data.JobPaused)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	var id int64
+	for rows.Next() {
+		if err = rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// PendingDays returns the days of the backfill job not processed yet
+func (s *SQLiteStorage) PendingDays(ctx context.Context, id int64) (days []time.Time, err error) {
+
+	q := `SELECT date FROM backfill_days WHERE job_id = $1 AND status = $2 ORDER BY date`
+	rows, err := s.DB.QueryContext(ctx, q, id, data.JobPending)
+	if err != nil {
+		return days, err
+	}
+	defer rows.Close()
+
+	var date string
+	for rows.Next() {
+		if err = rows.Scan(&date); err != nil {
+			return days, err
+		}
+		d, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return days, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+// SetJobStatus updates the status of the backfill job
+func (s *SQLiteStorage) SetJobStatus(ctx context.Context, id int64, status string) error {
+
+	q := `UPDATE backfill_jobs SET status = $1, updated = $2 WHERE id = $3`
+	_, err := s.DB.ExecContext(ctx, q, status, time.Now().Format("2006-01-02 15:04:05"), id)
+	return err
+}
+
+// SetDayStatus updates the status of the day of the backfill job
+func (s *SQLiteStorage) SetDayStatus(ctx context.Context, id int64, date time.Time, status, errMsg string) error {
+
+	q := `UPDATE backfill_days SET status = $1, error = $2 WHERE job_id = $3 AND date = $4`
+	_, err := s.DB.ExecContext(ctx, q, status, errMsg, id, date.Format("2006-01-02"))
+	return err
+}
+
+// RetryFailedDays makes the failed days of the backfill job pending again
+func (s *SQLiteStorage) RetryFailedDays(ctx context.Context, id int64) error {
+
+	q := `UPDATE backfill_days SET status = $1, error = '' WHERE job_id = $2 AND status = $3`
+	_, err := s.DB.ExecContext(ctx, q, data.JobPending, id, data.JobFailed)
+	return err
+}
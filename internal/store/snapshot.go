@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+)
+
+// snapshotTime is the format of the snapshot time, sortable as text
+const snapshotTime = "2006-01-02 15:04:05"
+
+// WriteSnapshot stores the rates as an intraday snapshot taken at the time of the rates
+func (s *SQLiteStorage) WriteSnapshot(ctx context.Context, rates data.Rates) error {
+
+	at := rates.Date.UTC().Format(snapshotTime)
+	return s.inTx(ctx, s.stmt.snapshot, func(stmt *sql.Stmt) error {
+		for currency, rate := range rates.Rates {
+			if _, err := stmt.ExecContext(ctx, at, rates.Base, currency, float64(rate)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Snapshots returns the snapshots of the currencies taken in [start, end), oldest first
+func (s *SQLiteStorage) Snapshots(ctx context.Context, currencies []string, start, end time.Time) ([]data.Rates, error) {
+
+	args := []any{start.UTC().Format(snapshotTime), end.UTC().Format(snapshotTime)}
+	placeholders := make([]string, 0, len(currencies))
+	for _, c := range currencies {
+		args = append(args, c)
+		placeholders = append(placeholders, "?")
+	}
+	q := "SELECT `time`, `base`, `currency`, `rate` FROM `snapshots` WHERE `time` >= ? AND `time` < ? AND `currency` IN (" +
+		strings.Join(placeholders, ", ") + ") ORDER BY `time`, `base`"
+	rows, err := s.DB.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []data.Rates{}
+	var at, base, currency string
+	var rate float64
+	for rows.Next() {
+		if err = rows.Scan(&at, &base, &currency, &rate); err != nil {
+			return nil, err
+		}
+		if n := len(res); n == 0 || res[n-1].Date.Format(snapshotTime) != at || res[n-1].Base != base {
+			t, err := time.Parse(snapshotTime, at)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, data.Rates{Date: data.Date{Time: t}, Base: base, Rates: map[string]data.FloatRate{}})
+		}
+		res[len(res)-1].Rates[currency] = data.FloatRate(rate)
+	}
+	return res, rows.Err()
+}
+
+// PruneSnapshots removes the snapshots taken before the time
+func (s *SQLiteStorage) PruneSnapshots(ctx context.Context, before time.Time) (int64, error) {
+
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM `snapshots` WHERE `time` < $1", before.UTC().Format(snapshotTime))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -11,8 +11,62 @@ import (
 )
 
 type SQLiteStorage struct {
-	DB  *sql.DB
-	ctx context.Context
+	DB *sql.DB
+	// ServeSeed includes seeded rates in the results of Read, LatestDate and Export
+	ServeSeed bool
+
+	stmt statements
+}
+
+// statements are prepared once and reused by every call
+type statements struct {
+	read     *sql.Stmt
+	upsert   *sql.Stmt
+	seed     *sql.Stmt
+	log      *sql.Stmt
+	readLogs *sql.Stmt
+	usage    *sql.Stmt
+	rollup   *sql.Stmt
+	latest   *sql.Stmt
+	export   *sql.Stmt
+	snapshot *sql.Stmt
+}
+
+// queries of the statements, seeded rates are filtered out unless the last parameter is true
+const (
+	qRead     = "SELECT `date`, `base`, `currency`, `rate` FROM `rates` WHERE `date` = $1 AND (`source` != 'seed' OR $2)"
+	qUpsert   = "REPLACE INTO `rates` (`date`, `base`, `currency`, `rate`, `source`) VALUES ($1, $2, $3, $4, $5)"
+	qSeed     = "INSERT OR IGNORE INTO `rates` (`date`, `base`, `currency`, `rate`, `source`) VALUES ($1, $2, $3, $4, 'seed')"
+	qLog      = "INSERT INTO `log` (`dateTime`, `tenant`, `route`, `type`, `request`) VALUES ($1, $2, $3, $4, $5)"
+	qReadLogs = "SELECT `dateTime`, `type`, `request` FROM `log` ORDER BY `id` DESC LIMIT 10"
+	qUsage    = "SELECT COUNT(*) FROM `log` WHERE `tenant` = $1 AND `dateTime` >= $2"
+	qRollup   = "INSERT INTO `usage_daily` (`day`, `tenant`, `route`, `requests`) VALUES ($1, $2, $3, 1) ON CONFLICT (`tenant`, `day`, `route`) DO UPDATE SET `requests` = `requests` + 1"
+	qLatest   = "SELECT MAX(`date`) FROM `rates` WHERE `source` != 'seed' OR $1"
+	qExport   = "SELECT `date`, `base`, `currency`, `rate` FROM `rates` WHERE `date` >= $1 AND `date` <= $2 AND (`source` != 'seed' OR $3) AND (`date`, `base`, `currency`) > ($4, $5, $6) ORDER BY `date`, `base`, `currency` LIMIT $7"
+	qSnapshot = "REPLACE INTO `snapshots` (`time`, `base`, `currency`, `rate`) VALUES ($1, $2, $3, $4)"
+)
+
+func prepare(ctx context.Context, db *sql.DB) (st statements, err error) {
+	for _, p := range []struct {
+		stmt **sql.Stmt
+		q    string
+	}{
+		{&st.read, qRead},
+		{&st.upsert, qUpsert},
+		{&st.seed, qSeed},
+		{&st.log, qLog},
+		{&st.readLogs, qReadLogs},
+		{&st.usage, qUsage},
+		{&st.rollup, qRollup},
+		{&st.latest, qLatest},
+		{&st.export, qExport},
+		{&st.snapshot, qSnapshot},
+	} {
+		if *p.stmt, err = db.PrepareContext(ctx, p.q); err != nil {
+			return st, fmt.Errorf("failed to prepare %q: %w", p.q, err)
+		}
+	}
+	return st, nil
 }
 
 func NewSQLite(ctx context.Context, path string) (*SQLiteStorage, error) {
@@ -21,104 +75,305 @@ func NewSQLite(ctx context.Context, path string) (*SQLiteStorage, error) {
 	if err != nil {
 		return nil, err
 	}
+	// sqlite allows a single writer, and every connection to ":memory:" is a separate database
+	sqliteDatabase.SetMaxOpenConns(1)
 
 	go func() {
 		<-ctx.Done()
 		sqliteDatabase.Close()
 	}()
 
-	initialData := ``
-	// check if the rates table exists
-	q := `SELECT name FROM sqlite_master WHERE type='table' AND name='rates';`
-	row := sqliteDatabase.QueryRowContext(ctx, q)
-	if err := row.Scan(); err == sql.ErrNoRows {
-		initialData = `
-		INSERT INTO rates (date, base, currency, rate) VALUES
-		('2024-04-20', 'USD', 'UAH', 39.4),
-		('2024-04-20', 'USD', 'EUR', 0.8),
-		('2024-04-20', 'USD', 'RON', 4.7),
-		('2024-04-21', 'USD', 'UAH', 39.5),
-		('2024-04-21', 'USD', 'EUR', 0.9),
-		('2024-04-21', 'USD', 'RON', 4.8);
-	`
-	}
-
-	q = `
+	q := `
 	CREATE TABLE IF NOT EXISTS rates (
 		date TEXT,
 		base TEXT,
 		currency TEXT,
 		rate REAL,
+		source TEXT NOT NULL DEFAULT 'api',
 		PRIMARY KEY (date, base, currency)
 	);
 	CREATE TABLE IF NOT EXISTS log (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		dateTime TEXT,
+		tenant TEXT NOT NULL DEFAULT '',
+		route TEXT NOT NULL DEFAULT '',
 		type TEXT,
 		request TEXT
 	);
+	-- requests per day of the log, updated with every logged request
+	CREATE TABLE IF NOT EXISTS usage_daily (
+		day TEXT,
+		tenant TEXT,
+		route TEXT,
+		requests INTEGER,
+		PRIMARY KEY (tenant, day, route)
+	);
+	CREATE TABLE IF NOT EXISTS backfill_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		start TEXT,
+		end TEXT,
+		status TEXT,
+		created TEXT,
+		updated TEXT
+	);
+	CREATE TABLE IF NOT EXISTS backfill_days (
+		job_id INTEGER,
+		date TEXT,
+		status TEXT,
+		error TEXT,
+		PRIMARY KEY (job_id, date)
+	);
+	CREATE TABLE IF NOT EXISTS quarantine (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT,
+		base TEXT,
+		currency TEXT,
+		rate TEXT,
+		previous TEXT,
+		reason TEXT,
+		created TEXT
+	);
+	CREATE TABLE IF NOT EXISTS snapshots (
+		time TEXT,
+		base TEXT,
+		currency TEXT,
+		rate REAL,
+		PRIMARY KEY (currency, time, base)
+	);
+	CREATE INDEX IF NOT EXISTS snapshots_time ON snapshots (time);
+	CREATE TABLE IF NOT EXISTS subscriptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		pair TEXT,
+		condition TEXT,
+		value REAL,
+		url TEXT,
+		secret TEXT,
+		active INTEGER,
+		failures INTEGER,
+		reference REAL,
+		created TEXT,
+		updated TEXT
+	);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		subscription_id INTEGER,
+		event TEXT,
+		attempt INTEGER,
+		status INTEGER,
+		error TEXT,
+		created TEXT
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription ON webhook_deliveries (subscription_id, id);
+	-- date lookups and ranges use the primary key, these cover per-currency ranges and log periods
+	CREATE INDEX IF NOT EXISTS rates_currency_date ON rates (currency, date);
+	CREATE INDEX IF NOT EXISTS log_datetime ON log (dateTime);
+	CREATE INDEX IF NOT EXISTS backfill_days_status ON backfill_days (job_id, status);
 	`
 	_, err = sqliteDatabase.ExecContext(ctx, q)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = sqliteDatabase.ExecContext(ctx, initialData)
+	err = migrateSource(ctx, sqliteDatabase)
 	if err != nil {
 		return nil, err
 	}
 
-	return &SQLiteStorage{DB: sqliteDatabase, ctx: ctx}, nil
+	err = migrateLog(ctx, sqliteDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := prepare(ctx, sqliteDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLiteStorage{DB: sqliteDatabase, stmt: st}, nil
 }
 
-func (s *SQLiteStorage) Write(d data.Rates) error {
+// migrateSource adds the source column to the rates table created by older versions
+// and marks the sample rows they inserted into every new database as seeded
+func migrateSource(ctx context.Context, db *sql.DB) error {
 
-	for currency, rate := range d.Rates {
-		q := `REPLACE INTO rates VALUES ($1, $2, $3, $4)`
-		_, err := s.DB.ExecContext(s.ctx, q, d.Date.String(), d.Base, currency, rate)
-		if err != nil {
+	var cnt int
+	q := `SELECT COUNT(*) FROM pragma_table_info('rates') WHERE name = 'source'`
+	if err := db.QueryRowContext(ctx, q).Scan(&cnt); err != nil || cnt > 0 {
+		return err
+	}
+
+	q = `
+	ALTER TABLE rates ADD COLUMN source TEXT NOT NULL DEFAULT 'api';
+	UPDATE rates SET source = 'seed' WHERE (date, base, currency, rate) IN (VALUES
+		('2024-04-20', 'USD', 'UAH', 39.4),
+		('2024-04-20', 'USD', 'EUR', 0.8),
+		('2024-04-20', 'USD', 'RON', 4.7),
+		('2024-04-21', 'USD', 'UAH', 39.5),
+		('2024-04-21', 'USD', 'EUR', 0.9),
+		('2024-04-21', 'USD', 'RON', 4.8)
+	);
+	`
+	_, err := db.ExecContext(ctx, q)
+	return err
+}
+
+// migrateLog adds the tenant and route columns to the log table created by older versions
+// and rolls up the requests logged before the usage_daily table was created. The index
+// is created here as the tenant column may be missing before the migration
+func migrateLog(ctx context.Context, db *sql.DB) error {
+
+	for _, column := range []string{"tenant", "route"} {
+		var cnt int
+		q := `SELECT COUNT(*) FROM pragma_table_info('log') WHERE name = $1`
+		if err := db.QueryRowContext(ctx, q, column).Scan(&cnt); err != nil {
+			return err
+		}
+		if cnt > 0 {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `ALTER TABLE log ADD COLUMN `+column+` TEXT NOT NULL DEFAULT ''`); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	q := `
+	CREATE INDEX IF NOT EXISTS log_tenant_datetime ON log (tenant, dateTime);
+	INSERT INTO usage_daily (day, tenant, route, requests)
+		SELECT substr(dateTime, 1, 10), tenant, route, COUNT(*) FROM log
+		WHERE NOT EXISTS (SELECT 1 FROM usage_daily)
+		GROUP BY 1, 2, 3;
+	`
+	_, err := db.ExecContext(ctx, q)
+	return err
+}
+
+// inTx runs fn with the statement bound to a transaction, the transaction is committed if fn succeeds
+func (s *SQLiteStorage) inTx(ctx context.Context, stmt *sql.Stmt, fn func(*sql.Stmt) error) error {
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	txStmt := tx.StmtContext(ctx, stmt)
+	defer txStmt.Close()
+	if err = fn(txStmt); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (s *SQLiteStorage) Log(reqType, request string) error {
+// Seed inserts sample rates marked as seeded, existing rates are never overwritten
+func (s *SQLiteStorage) Seed(ctx context.Context, rows []data.Row) error {
+	return s.inTx(ctx, s.stmt.seed, func(stmt *sql.Stmt) error {
+		for _, row := range rows {
+			if _, err := stmt.ExecContext(ctx, row.Date, row.Base, row.Currency, float64(row.Rate)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
-	q := `INSERT INTO log(dateTime, type, request) VALUES ($1, $2, $3) `
-	_, err := s.DB.ExecContext(s.ctx, q, time.Now().Format("2006-01-02 15:04:05"), reqType, request)
+// Write stores the rates of the day atomically, either all of them or none
+func (s *SQLiteStorage) Write(ctx context.Context, d data.Rates) error {
+	return s.inTx(ctx, s.stmt.upsert, func(stmt *sql.Stmt) error {
+		for currency, rate := range d.Rates {
+			if _, err := stmt.ExecContext(ctx, d.Date.String(), d.Base, currency, float64(rate), data.SourceAPI); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Log stores the request and counts it in the daily usage in a single transaction,
+// log times are stored in the local time zone
+func (s *SQLiteStorage) Log(ctx context.Context, e data.LogEntry) error {
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	dateTime := e.Time.Local().Format("2006-01-02 15:04:05")
+
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	if _, err = tx.StmtContext(ctx, s.stmt.log).ExecContext(ctx, dateTime, e.Tenant, e.Route, e.Type, e.Request); err != nil {
+		return err
+	}
+	if _, err = tx.StmtContext(ctx, s.stmt.rollup).ExecContext(ctx, dateTime[:10], e.Tenant, e.Route); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UsageReport returns the numbers of requests in the date range by API key and day or route,
+// of all keys if the key is empty. Days are in the local time zone of the log
+func (s *SQLiteStorage) UsageReport(ctx context.Context, key string, from, to time.Time, groupBy string) ([]data.UsageRow, error) {
+
+	group := "`day`"
+	if groupBy == data.UsageByRoute {
+		group = "`route`"
+	}
+	q := "SELECT `tenant`, " + group + ", SUM(`requests`) FROM `usage_daily` WHERE `day` >= $1 AND `day` <= $2 AND ($3 = '' OR `tenant` = $3) " +
+		"GROUP BY `tenant`, " + group + " ORDER BY `tenant`, " + group
+	rows, err := s.DB.QueryContext(ctx, q, from.Format("2006-01-02"), to.Format("2006-01-02"), key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []data.UsageRow{}
+	for rows.Next() {
+		var row data.UsageRow
+		var value string
+		if err = rows.Scan(&row.Key, &value, &row.Requests); err != nil {
+			return nil, err
+		}
+		if groupBy == data.UsageByRoute {
+			row.Route = value
+		} else {
+			row.Day = value
+		}
+		res = append(res, row)
+	}
+	return res, rows.Err()
+}
 
-	return nil
+// Usage returns the number of logged requests of the tenant since the time
+func (s *SQLiteStorage) Usage(ctx context.Context, tenant string, since time.Time) (cnt int64, err error) {
+	// log times are stored in the local time zone
+	err = s.stmt.usage.QueryRowContext(ctx, tenant, since.Local().Format("2006-01-02 15:04:05")).Scan(&cnt)
+	return cnt, err
 }
 
-func (s *SQLiteStorage) ReadLogs() (logs []string, err error) {
+func (s *SQLiteStorage) ReadLogs(ctx context.Context) (logs []string, err error) {
 
-	q := "SELECT * FROM `log` ORDER BY `id` DESC LIMIT 10"
-	rows, err := s.DB.QueryContext(s.ctx, q)
+	rows, err := s.stmt.readLogs.QueryContext(ctx)
 	if err != nil {
 		return logs, err
 	}
 	defer rows.Close()
 
 	line := struct {
-		id       int
 		dateTime string
 		reqType  string
 		request  string
 	}{}
 	for rows.Next() {
-		err = rows.Scan(&line.id, &line.dateTime, &line.reqType, &line.request)
+		err = rows.Scan(&line.dateTime, &line.reqType, &line.request)
 		if err != nil {
 			return logs, err
 		}
 
 		logs = append(logs, fmt.Sprintf("%s | %s | %s", line.dateTime, line.reqType, line.request))
 	}
-	return
+	return logs, rows.Err()
 }
 
 type line struct {
@@ -129,10 +384,9 @@ type line struct {
 }
 
 // Read reads rates from the database
-func (s *SQLiteStorage) Read(date time.Time) (res data.Rates, err error) {
+func (s *SQLiteStorage) Read(ctx context.Context, date time.Time) (res data.Rates, err error) {
 
-	q := fmt.Sprintf("SELECT * FROM `rates` WHERE `date` = '%s'", date.Format("2006-01-02"))
-	rows, err := s.DB.QueryContext(s.ctx, q)
+	rows, err := s.stmt.read.QueryContext(ctx, date.Format("2006-01-02"), s.ServeSeed)
 	if err != nil {
 		return res, err
 	}
@@ -147,9 +401,10 @@ func (s *SQLiteStorage) Read(date time.Time) (res data.Rates, err error) {
 			return res, err
 		}
 
-		err = res.Date.ParseDate(line.date)
-		if err != nil {
-			return res, err
+		if res.Date.IsZero() {
+			if err = res.Date.ParseDate(line.date); err != nil {
+				return res, err
+			}
 		}
 		res.Base = line.base
 		res.Rates[line.currency] = data.FloatRate(line.rate)
@@ -165,3 +420,85 @@ func (s *SQLiteStorage) Read(date time.Time) (res data.Rates, err error) {
 func (s *SQLiteStorage) cleanup() {
 	s.DB.Exec("DROP TABLE `rates`")
 }
+
+// Ping checks that the database is reachable
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+// LatestDate returns the date of the newest stored rates
+func (s *SQLiteStorage) LatestDate(ctx context.Context) (time.Time, error) {
+
+	var date sql.NullString
+	err := s.stmt.latest.QueryRowContext(ctx, s.ServeSeed).Scan(&date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !date.Valid {
+		return time.Time{}, ErrNotFound
+	}
+
+	return time.Parse("2006-01-02", date.String)
+}
+
+// exportPage is the number of rows read at once by Export
+const exportPage = 1000
+
+// Export calls fn for every stored rate in the date range, ordered by date, base and currency.
+// Zero start or end leaves the range open. Rows are read by pages, the connection is not held
+// while fn is called, so a slow consumer doesn't block other queries
+func (s *SQLiteStorage) Export(ctx context.Context, start, end time.Time, fn func(data.Row) error) error {
+
+	from, to := "0000-00-00", "9999-99-99"
+	if !start.IsZero() {
+		from = start.Format("2006-01-02")
+	}
+	if !end.IsZero() {
+		to = end.Format("2006-01-02")
+	}
+
+	last := data.Row{}
+	page := make([]data.Row, 0, exportPage)
+	for {
+		page = page[:0]
+		rows, err := s.stmt.export.QueryContext(ctx, from, to, s.ServeSeed, last.Date, last.Base, last.Currency, exportPage)
+		if err != nil {
+			return err
+		}
+		row := data.Row{}
+		for rows.Next() {
+			if err = rows.Scan(&row.Date, &row.Base, &row.Currency, &row.Rate); err != nil {
+				rows.Close()
+				return err
+			}
+			page = append(page, row)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, row := range page {
+			if err = fn(row); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPage {
+			return nil
+		}
+		last = page[len(page)-1]
+	}
+}
+
+// Import upserts the rows in a single transaction
+func (s *SQLiteStorage) Import(ctx context.Context, rows []data.Row) error {
+
+	return s.inTx(ctx, s.stmt.upsert, func(stmt *sql.Stmt) error {
+		for _, row := range rows {
+			if _, err := stmt.ExecContext(ctx, row.Date, row.Base, row.Currency, float64(row.Rate), data.SourceImport); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -2,6 +2,9 @@ package store
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -9,9 +12,9 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-// Test_Sqlite_InitData tests the initialization of the SQLite storage
-// with the initial data
-func Test_Sqlite_InitData(t *testing.T) {
+// Test_Sqlite_Seed tests that a new SQLite storage is empty and seeded rates
+// are excluded from the results unless served
+func Test_Sqlite_Seed(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -19,16 +22,145 @@ func Test_Sqlite_InitData(t *testing.T) {
 	store, err := NewSQLite(ctx, ":memory:")
 	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
 
-	// Check if there are 6 initial rows in the rates table
-	cnt := 0
-	row := store.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM rates")
-	err = row.Scan(&cnt)
+	count := func(source string) int {
+		cnt := 0
+		row := store.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM rates WHERE source = $1", source)
+		assert.Nil(t, row.Scan(&cnt))
+		return cnt
+	}
+
+	// No rates in a new database
+	assert.Equal(t, 0, count(data.SourceSeed))
+
+	err = store.Seed(ctx, DemoData)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, count(data.SourceSeed))
+
+	date := time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC)
+	_, err = store.Read(ctx, date)
+	assert.Equal(t, ErrNotFound, err, "seeded rates are not served")
+	_, err = store.LatestDate(ctx)
+	assert.Equal(t, ErrNotFound, err)
+
+	store.ServeSeed = true
+	rates, err := store.Read(ctx, date)
+	assert.Nil(t, err)
+	assert.Len(t, rates.Rates, 3)
+	store.ServeSeed = false
+
+	// real rates replace seeded ones and are not overwritten by seeding
+	err = store.Write(ctx, data.Rates{Date: data.Date{Time: date}, Base: "USD", Rates: map[string]data.FloatRate{"UAH": 39.9}})
+	assert.Nil(t, err)
+	err = store.Seed(ctx, DemoData)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count(data.SourceSeed))
+	assert.Equal(t, 1, count(data.SourceAPI))
+	rates, err = store.Read(ctx, date)
 	assert.Nil(t, err)
-	assert.Equal(t, 6, cnt)
+	assert.Equal(t, map[string]data.FloatRate{"UAH": 39.9}, rates.Rates)
 
 	// Tear down the storage
 	store.cleanup()
 }
+
+// Test_Sqlite_MigrateSource tests the migration of a database created by older versions
+func Test_Sqlite_MigrateSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := "file:" + t.TempDir() + "/old.db?mode=rwc"
+	db, err := sql.Open("sqlite3", path)
+	assert.Nil(t, err)
+	_, err = db.Exec(`CREATE TABLE rates (date TEXT, base TEXT, currency TEXT, rate REAL, PRIMARY KEY (date, base, currency));
+		INSERT INTO rates VALUES ('2024-04-20', 'USD', 'UAH', 39.4), ('2024-04-22', 'USD', 'UAH', 39.6);`)
+	assert.Nil(t, err)
+	db.Close()
+
+	store, err := NewSQLite(ctx, path)
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	_, err = store.Read(ctx, time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, ErrNotFound, err, "sample rows of older versions are marked as seeded")
+	rates, err := store.Read(ctx, time.Date(2024, 4, 22, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, data.FloatRate(39.6), rates.Rates["UAH"])
+
+	// reopening is a no-op
+	_, err = NewSQLite(ctx, path)
+	assert.Nil(t, err)
+}
+
+// Test_Sqlite_Usage tests counting and reports of the requests of tenants in a log table created by older versions
+func Test_Sqlite_Usage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := "file:" + t.TempDir() + "/old.db?mode=rwc"
+	db, err := sql.Open("sqlite3", path)
+	assert.Nil(t, err)
+	_, err = db.Exec(`CREATE TABLE log (id INTEGER PRIMARY KEY AUTOINCREMENT, dateTime TEXT, type TEXT, request TEXT);
+		INSERT INTO log (dateTime, type, request) VALUES ('2024-04-20 10:00:00', 'rates', 'date: , symbols: '),
+			('2024-04-20 11:00:00', 'pair', 'pair: EUR-RON');`)
+	assert.Nil(t, err)
+	db.Close()
+
+	store, err := NewSQLite(ctx, path)
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	day := func(d, h int) time.Time { return time.Date(2024, 4, d, h, 0, 0, 0, time.Local) }
+	for _, e := range []data.LogEntry{
+		{Time: day(20, 12), Tenant: "billing", Route: "GET /v1/rates", Type: "rates", Request: "date: , symbols: EUR"},
+		{Time: day(21, 9), Tenant: "billing", Route: "GET /v1/pair/:pair", Type: "pair", Request: "pair: EUR-RON"},
+		{Time: day(21, 10), Tenant: "billing", Route: "GET /v1/pair/:pair", Type: "pair", Request: "pair: EUR-UAH"},
+		{Time: day(22, 10), Tenant: "reports", Route: "GET /v1/pair/:pair", Type: "pair", Request: "pair: EUR-RON"},
+	} {
+		assert.Nil(t, store.Log(ctx, e))
+	}
+
+	for tenant, want := range map[string]int64{"billing": 3, "reports": 1, "": 2, "other": 0} {
+		cnt, err := store.Usage(ctx, tenant, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		assert.Nil(t, err)
+		assert.Equal(t, want, cnt, tenant)
+	}
+	cnt, err := store.Usage(ctx, "billing", day(21, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), cnt, "requests before the time are not counted")
+
+	// requests logged by older versions are rolled up on upgrade
+	rows, err := store.UsageReport(ctx, "", day(20, 0), day(21, 0), data.UsageByDay)
+	assert.Nil(t, err)
+	assert.Equal(t, []data.UsageRow{
+		{Key: "", Day: "2024-04-20", Requests: 2},
+		{Key: "billing", Day: "2024-04-20", Requests: 1},
+		{Key: "billing", Day: "2024-04-21", Requests: 2},
+	}, rows)
+
+	rows, err = store.UsageReport(ctx, "billing", day(1, 0), day(30, 0), data.UsageByRoute)
+	assert.Nil(t, err)
+	assert.Equal(t, []data.UsageRow{
+		{Key: "billing", Route: "GET /v1/pair/:pair", Requests: 2},
+		{Key: "billing", Route: "GET /v1/rates", Requests: 1},
+	}, rows)
+
+	rows, err = store.UsageReport(ctx, "other", day(1, 0), day(30, 0), data.UsageByDay)
+	assert.Nil(t, err)
+	assert.Empty(t, rows)
+
+	// reopening doesn't roll up the log again
+	store, err = NewSQLite(ctx, path)
+	assert.Nil(t, err)
+	rows, err = store.UsageReport(ctx, "", day(20, 0), day(20, 0), data.UsageByRoute)
+	assert.Nil(t, err)
+	assert.Equal(t, []data.UsageRow{
+		{Key: "", Route: "", Requests: 2},
+		{Key: "billing", Route: "GET /v1/rates", Requests: 1},
+	}, rows)
+
+	logs, err := store.ReadLogs(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, logs, 6)
+}
+
 func Test_Sqlite_Full(t *testing.T) {
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -48,10 +180,10 @@ func Test_Sqlite_Full(t *testing.T) {
 		},
 	}
 
-	err = store.Write(ratesInit)
+	err = store.Write(ctx, ratesInit)
 	assert.Nil(t, err)
 
-	rates, err := store.Read(time.Now())
+	rates, err := store.Read(ctx, time.Now())
 	assert.Nil(t, err)
 	assert.Equal(t, ratesInit.Base, rates.Base)
 	assert.Equal(t, ratesInit.Date.String(), rates.Date.String())
@@ -60,18 +192,18 @@ func Test_Sqlite_Full(t *testing.T) {
 	// New rates for the previous day
 	rates.Date = data.Date{Time: time.Now().AddDate(0, 0, -1)}
 	rates.Rates["UAH"] = 27.6
-	err = store.Write(rates)
+	err = store.Write(ctx, rates)
 	assert.Nil(t, err)
 
 	// Read rates for the previous day
-	ratesChanged, err := store.Read(time.Now().AddDate(0, 0, -1))
+	ratesChanged, err := store.Read(ctx, time.Now().AddDate(0, 0, -1))
 	assert.Nil(t, err)
 	assert.Equal(t, rates.Base, ratesChanged.Base)
 	assert.Equal(t, rates.Date.String(), time.Now().AddDate(0, 0, -1).Format("2006-01-02"))
 	assert.Equal(t, rates.Rates, ratesChanged.Rates)
 
 	// Rates for the current day should not be changed
-	ratesCurrent, err := store.Read(time.Now())
+	ratesCurrent, err := store.Read(ctx, time.Now())
 	assert.Nil(t, err)
 	assert.Equal(t, ratesInit.Base, ratesCurrent.Base)
 	assert.Equal(t, ratesInit.Date.String(), ratesCurrent.Date.String())
@@ -79,10 +211,10 @@ func Test_Sqlite_Full(t *testing.T) {
 
 	// Change rates for the current day and check
 	ratesCurrent.Rates["UAH"] = 27.7
-	err = store.Write(ratesCurrent)
+	err = store.Write(ctx, ratesCurrent)
 	assert.Nil(t, err)
 
-	ratesChanged, err = store.Read(time.Now())
+	ratesChanged, err = store.Read(ctx, time.Now())
 	assert.Nil(t, err)
 	assert.Equal(t, ratesCurrent.Base, ratesChanged.Base)
 	assert.Equal(t, ratesCurrent.Date.String(), ratesChanged.Date.String())
@@ -91,3 +223,336 @@ func Test_Sqlite_Full(t *testing.T) {
 	// Teardown
 	store.cleanup()
 }
+
+func Test_Sqlite_LatestDate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+	assert.Nil(t, store.Ping(ctx))
+
+	_, err = store.LatestDate(ctx)
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Nil(t, store.Import(ctx, DemoData))
+	latest, err := store.LatestDate(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-04-21", latest.Format("2006-01-02"))
+
+	store.cleanup()
+	_, err = store.LatestDate(ctx)
+	assert.NotNil(t, err)
+}
+
+func Test_Sqlite_ImportExport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	assert.Nil(t, store.Import(ctx, DemoData))
+	err = store.Import(ctx, []data.Row{
+		{Date: "2024-04-21", Base: "USD", Currency: "UAH", Rate: 39.6},
+		{Date: "2024-04-22", Base: "USD", Currency: "UAH", Rate: 39.7},
+	})
+	assert.Nil(t, err)
+
+	rows := []data.Row{}
+	start := time.Date(2024, 4, 21, 0, 0, 0, 0, time.UTC)
+	err = store.Export(ctx, start, time.Time{}, func(row data.Row) error {
+		rows = append(rows, row)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []data.Row{
+		{Date: "2024-04-21", Base: "USD", Currency: "EUR", Rate: 0.9},
+		{Date: "2024-04-21", Base: "USD", Currency: "RON", Rate: 4.8},
+		{Date: "2024-04-21", Base: "USD", Currency: "UAH", Rate: 39.6},
+		{Date: "2024-04-22", Base: "USD", Currency: "UAH", Rate: 39.7},
+	}, rows)
+
+	// exported by pages, the connection is free while rows are consumed
+	many := []data.Row{}
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < exportPage+5; i++ {
+		many = append(many, data.Row{Date: day.AddDate(0, 0, i).Format("2006-01-02"), Base: "USD", Currency: "UAH", Rate: 30})
+	}
+	assert.Nil(t, store.Import(ctx, many))
+	cnt := 0
+	err = store.Export(ctx, day, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), func(row data.Row) error {
+		assert.Equal(t, many[cnt], row)
+		cnt++
+		_, err := store.LatestDate(ctx)
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, len(many), cnt)
+
+	store.cleanup()
+}
+
+func Test_Sqlite_WriteAtomic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	_, err = store.DB.ExecContext(ctx, `CREATE TRIGGER fail_bad BEFORE INSERT ON rates WHEN NEW.currency = 'BAD'
+		BEGIN SELECT RAISE(ABORT, 'bad currency'); END`)
+	assert.Nil(t, err)
+
+	date := time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC)
+	rates := data.Rates{Date: data.Date{Time: date}, Base: "USD", Rates: map[string]data.FloatRate{}}
+	for _, c := range []string{"UAH", "EUR", "RON", "GBP", "BAD", "PLN", "CZK"} {
+		rates.Rates[c] = 1
+	}
+	assert.NotNil(t, store.Write(ctx, rates))
+	_, err = store.Read(ctx, date)
+	assert.Equal(t, ErrNotFound, err, "failed write leaves no rates of the day")
+
+	delete(rates.Rates, "BAD")
+	assert.Nil(t, store.Write(ctx, rates))
+	stored, err := store.Read(ctx, date)
+	assert.Nil(t, err)
+	assert.Len(t, stored.Rates, 6)
+
+	// values are bound, not interpolated
+	_, err = store.Read(ctx, time.Time{})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func Test_Sqlite_Quarantine(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	assert.Nil(t, store.Seed(ctx, DemoData))
+	assert.Nil(t, store.Write(ctx, data.Rates{
+		Date: data.Date{Time: time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC)}, Base: "USD",
+		Rates: map[string]data.FloatRate{"UAH": 38.1, "PLN": 4.0},
+	}))
+	assert.Nil(t, store.Write(ctx, data.Rates{
+		Date: data.Date{Time: time.Date(2024, 4, 19, 0, 0, 0, 0, time.UTC)}, Base: "USD",
+		Rates: map[string]data.FloatRate{"UAH": 39.1},
+	}))
+
+	date := time.Date(2024, 4, 22, 0, 0, 0, 0, time.UTC)
+	previous, err := store.PreviousRates(ctx, "USD", date)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]data.FloatRate{"UAH": 39.1}, previous, "seeded and old rates are skipped")
+
+	store.ServeSeed = true
+	previous, err = store.PreviousRates(ctx, "USD", date)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]data.FloatRate{"UAH": 39.5, "EUR": 0.9, "RON": 4.8}, previous)
+	previous, err = store.PreviousRates(ctx, "EUR", date)
+	assert.Nil(t, err)
+	assert.Empty(t, previous)
+
+	quarantined, err := store.Quarantined(ctx, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, quarantined)
+
+	assert.Nil(t, store.Quarantine(ctx, []data.Anomaly{
+		{Date: "2024-04-22", Base: "USD", Currency: "UAH", Rate: "3950", Previous: "39.5", Reason: "change 9900.0% exceeds 50.0%"},
+		{Date: "2024-04-22", Base: "USD", Currency: "EUR", Rate: "NaN", Reason: "invalid rate"},
+	}))
+	quarantined, err = store.Quarantined(ctx, 1)
+	assert.Nil(t, err)
+	assert.Len(t, quarantined, 1)
+	assert.Equal(t, "EUR", quarantined[0].Currency, "newest first")
+	assert.Equal(t, "NaN", quarantined[0].Rate)
+	assert.NotEmpty(t, quarantined[0].Created)
+
+	_, err = store.Read(ctx, date)
+	assert.Equal(t, ErrNotFound, err, "quarantined rates are not served")
+}
+
+func Test_Sqlite_Snapshots(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	snapshot := func(at time.Time, uah, eur float64) data.Rates {
+		return data.Rates{Date: data.Date{Time: at}, Base: "USD", Rates: map[string]data.FloatRate{"UAH": data.FloatRate(uah), "EUR": data.FloatRate(eur), "RON": 4.7}}
+	}
+	day := time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, store.WriteSnapshot(ctx, snapshot(day.Add(-time.Hour), 39.1, 0.8)))
+	assert.Nil(t, store.WriteSnapshot(ctx, snapshot(day.Add(10*time.Hour), 39.3, 0.81)))
+	assert.Nil(t, store.WriteSnapshot(ctx, snapshot(day.Add(9*time.Hour), 39.2, 0.82)))
+	// the same upstream time replaces the snapshot
+	assert.Nil(t, store.WriteSnapshot(ctx, snapshot(day.Add(10*time.Hour), 39.4, 0.83)))
+
+	snapshots, err := store.Snapshots(ctx, []string{"UAH", "EUR"}, day, day.AddDate(0, 0, 1))
+	assert.Nil(t, err)
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, day.Add(9*time.Hour), snapshots[0].Date.Time, "oldest first")
+	assert.Equal(t, map[string]data.FloatRate{"UAH": 39.2, "EUR": 0.82}, snapshots[0].Rates)
+	assert.Equal(t, map[string]data.FloatRate{"UAH": 39.4, "EUR": 0.83}, snapshots[1].Rates)
+	assert.Equal(t, "USD", snapshots[1].Base)
+
+	n, err := store.PruneSnapshots(ctx, day)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), n)
+	snapshots, err = store.Snapshots(ctx, []string{"UAH"}, day.AddDate(0, 0, -1), day.AddDate(0, 0, 1))
+	assert.Nil(t, err)
+	assert.Len(t, snapshots, 2)
+}
+
+func Test_Sqlite_Subscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := NewSQLite(ctx, ":memory:")
+	assert.Nil(t, err, "Failed to open SQLite storage: %e", err)
+
+	sub, err := store.CreateSubscription(ctx, data.Subscription{Pair: "USD-UAH", Condition: data.ConditionAbove, Value: 42,
+		URL: "http://localhost/hook", Secret: "s3cret", Active: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), sub.ID)
+	assert.Equal(t, "s3cret", sub.Secret)
+	assert.True(t, sub.Active)
+	assert.NotEmpty(t, sub.Created)
+	_, err = store.CreateSubscription(ctx, data.Subscription{Pair: "EUR-UAH", Condition: data.ConditionChange, Value: 1, URL: "http://localhost/hook"})
+	assert.Nil(t, err)
+
+	active, err := store.Subscriptions(ctx, true)
+	assert.Nil(t, err)
+	assert.Len(t, active, 1)
+	all, err := store.Subscriptions(ctx, false)
+	assert.Nil(t, err)
+	assert.Len(t, all, 2)
+
+	assert.Nil(t, store.SetReference(ctx, sub.ID, 41.5))
+	sub.Value = 43
+	sub.Reference = 41.5
+	sub, err = store.UpdateSubscription(ctx, sub)
+	assert.Nil(t, err)
+	assert.Equal(t, 43.0, sub.Value)
+	assert.Equal(t, 41.5, sub.Reference)
+	_, err = store.UpdateSubscription(ctx, data.Subscription{ID: 42})
+	assert.Equal(t, ErrNotFound, err)
+
+	// disabled after 2 consecutive failures, a delivery resets the counter
+	ok, err := store.RecordDelivery(ctx, sub.ID, false, 2)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = store.RecordDelivery(ctx, sub.ID, true, 2)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, _ = store.RecordDelivery(ctx, sub.ID, false, 2)
+	assert.True(t, ok)
+	ok, err = store.RecordDelivery(ctx, sub.ID, false, 2)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	sub, err = store.Subscription(ctx, sub.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, sub.Failures)
+	assert.False(t, sub.Active)
+	_, err = store.RecordDelivery(ctx, 42, true, 2)
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Nil(t, store.LogDelivery(ctx, data.Delivery{SubscriptionID: sub.ID, Event: "e1", Attempt: 1, Status: 503, Error: "unexpected status 503"}))
+	assert.Nil(t, store.LogDelivery(ctx, data.Delivery{SubscriptionID: sub.ID, Event: "e1", Attempt: 2, Status: 200}))
+	deliveries, err := store.Deliveries(ctx, sub.ID, 10)
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 2)
+	assert.Equal(t, 2, deliveries[0].Attempt, "newest first")
+	assert.Equal(t, "unexpected status 503", deliveries[1].Error)
+
+	assert.Nil(t, store.DeleteSubscription(ctx, sub.ID))
+	assert.Equal(t, ErrNotFound, store.DeleteSubscription(ctx, sub.ID))
+	_, err = store.Subscription(ctx, sub.ID)
+	assert.Equal(t, ErrNotFound, err)
+	deliveries, err = store.Deliveries(ctx, sub.ID, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, deliveries)
+}
+
+// benchRates makes rates of n currencies for the day
+func benchRates(day, n int) data.Rates {
+	rates := data.Rates{Date: data.Date{Time: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, day)}, Base: "USD", Rates: map[string]data.FloatRate{}}
+	for i := 0; i < n; i++ {
+		rates.Rates[fmt.Sprintf("C%02d", i)] = data.FloatRate(i + 1)
+	}
+	return rates
+}
+
+func benchStore(b *testing.B) (*SQLiteStorage, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(cancel)
+	store, err := NewSQLite(ctx, "file:"+filepath.Join(b.TempDir(), "bench.db")+"?mode=rwc&_journal_mode=WAL")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return store, ctx
+}
+
+// BenchmarkSqlite_Write compares a statement per rate outside of a transaction,
+// as rates were written before, with a prepared statement in a transaction
+func BenchmarkSqlite_Write(b *testing.B) {
+	b.Run("unprepared", func(b *testing.B) {
+		store, ctx := benchStore(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			d := benchRates(i, 30)
+			for currency, rate := range d.Rates {
+				q := `REPLACE INTO rates (date, base, currency, rate, source) VALUES ($1, $2, $3, $4, $5)`
+				if _, err := store.DB.ExecContext(ctx, q, d.Date.String(), d.Base, currency, float64(rate), data.SourceAPI); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("prepared-tx", func(b *testing.B) {
+		store, ctx := benchStore(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := store.Write(ctx, benchRates(i, 30)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkSqlite_Read compares a query built with Sprintf, as rates were read before, with a prepared statement
+func BenchmarkSqlite_Read(b *testing.B) {
+	store, ctx := benchStore(b)
+	for i := 0; i < 100; i++ {
+		if err := store.Write(ctx, benchRates(i, 30)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run("unprepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			date := benchRates(i%100, 0).Date.String()
+			q := fmt.Sprintf("SELECT `date`, `base`, `currency`, `rate` FROM `rates` WHERE `date` = '%s' AND `source` != 'seed'", date)
+			rows, err := store.DB.QueryContext(ctx, q)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var l line
+			for rows.Next() {
+				if err = rows.Scan(&l.date, &l.base, &l.currency, &l.rate); err != nil {
+					b.Fatal(err)
+				}
+			}
+			rows.Close()
+		}
+	})
+	b.Run("prepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.Read(ctx, benchRates(i%100, 0).Date.Time); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
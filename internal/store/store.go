@@ -13,15 +13,83 @@ var (
 	ErrNotFound = errors.New("no data found")
 )
 
+// DemoData is a sample set of rates for a demo database
+var DemoData = []data.Row{
+	{Date: "2024-04-20", Base: "USD", Currency: "UAH", Rate: 39.4},
+	{Date: "2024-04-20", Base: "USD", Currency: "EUR", Rate: 0.8},
+	{Date: "2024-04-20", Base: "USD", Currency: "RON", Rate: 4.7},
+	{Date: "2024-04-21", Base: "USD", Currency: "UAH", Rate: 39.5},
+	{Date: "2024-04-21", Base: "USD", Currency: "EUR", Rate: 0.9},
+	{Date: "2024-04-21", Base: "USD", Currency: "RON", Rate: 4.8},
+}
+
 type Storer interface {
 	// Read reads records for the given module from the database
-	Read(time.Time) (data.Rates, error)
+	Read(ctx context.Context, date time.Time) (data.Rates, error)
 	// Write writes the data to the database
-	Write(data.Rates) error
+	Write(ctx context.Context, rates data.Rates) error
 	// Log requests to the database
-	Log(string, string) error
+	Log(ctx context.Context, entry data.LogEntry) error
+	// Usage returns the number of logged requests of the tenant since the time
+	Usage(ctx context.Context, tenant string, since time.Time) (int64, error)
+	// UsageReport returns the numbers of requests in the date range by API key and day or route
+	UsageReport(ctx context.Context, key string, from, to time.Time, groupBy string) ([]data.UsageRow, error)
 	// ReadLogs return 10 most recent logs from the database
-	ReadLogs() ([]string, error)
+	ReadLogs(ctx context.Context) ([]string, error)
+	// Ping checks the database connection
+	Ping(ctx context.Context) error
+	// LatestDate returns the date of the newest stored rates
+	LatestDate(ctx context.Context) (time.Time, error)
+	// Export calls fn for every stored rate in the date range
+	Export(ctx context.Context, start, end time.Time, fn func(data.Row) error) error
+	// Import upserts the rows in a single transaction
+	Import(ctx context.Context, rows []data.Row) error
+	// Seed inserts sample rates marked as seeded
+	Seed(ctx context.Context, rows []data.Row) error
+	// PreviousRates returns the latest rate of every currency of the base stored shortly before the date
+	PreviousRates(ctx context.Context, base string, date time.Time) (map[string]data.FloatRate, error)
+	// Quarantine stores rates rejected on ingest
+	Quarantine(ctx context.Context, anomalies []data.Anomaly) error
+	// Quarantined returns the most recent quarantined rates
+	Quarantined(ctx context.Context, limit int) ([]data.Anomaly, error)
+	// WriteSnapshot stores the rates as an intraday snapshot taken at the time of the rates
+	WriteSnapshot(ctx context.Context, rates data.Rates) error
+	// Snapshots returns the snapshots of the currencies taken in [start, end), oldest first
+	Snapshots(ctx context.Context, currencies []string, start, end time.Time) ([]data.Rates, error)
+	// PruneSnapshots removes the snapshots taken before the time
+	PruneSnapshots(ctx context.Context, before time.Time) (int64, error)
+	// CreateSubscription stores a new webhook subscription
+	CreateSubscription(ctx context.Context, sub data.Subscription) (data.Subscription, error)
+	// Subscription returns the webhook subscription
+	Subscription(ctx context.Context, id int64) (data.Subscription, error)
+	// Subscriptions returns all webhook subscriptions or only the active ones
+	Subscriptions(ctx context.Context, activeOnly bool) ([]data.Subscription, error)
+	// UpdateSubscription replaces the settings and the state of the webhook subscription
+	UpdateSubscription(ctx context.Context, sub data.Subscription) (data.Subscription, error)
+	// DeleteSubscription removes the webhook subscription with its deliveries
+	DeleteSubscription(ctx context.Context, id int64) error
+	// SetReference updates the rate the next one is compared with
+	SetReference(ctx context.Context, id int64, reference float64) error
+	// RecordDelivery counts the delivery result and disables the subscription after maxFailures consecutive failures
+	RecordDelivery(ctx context.Context, id int64, delivered bool, maxFailures int) (active bool, err error)
+	// LogDelivery stores the delivery attempt
+	LogDelivery(ctx context.Context, d data.Delivery) error
+	// Deliveries returns the most recent delivery attempts of the subscription
+	Deliveries(ctx context.Context, id int64, limit int) ([]data.Delivery, error)
+	// CreateJob creates a backfill job for the date range
+	CreateJob(ctx context.Context, start, end time.Time) (data.Job, error)
+	// Job returns the backfill job with its progress
+	Job(ctx context.Context, id int64) (data.Job, error)
+	// UnfinishedJobs returns ids of the backfill jobs to be resumed
+	UnfinishedJobs(ctx context.Context) ([]int64, error)
+	// PendingDays returns the days of the backfill job not processed yet
+	PendingDays(ctx context.Context, id int64) ([]time.Time, error)
+	// SetJobStatus updates the status of the backfill job
+	SetJobStatus(ctx context.Context, id int64, status string) error
+	// SetDayStatus updates the status of the day of the backfill job
+	SetDayStatus(ctx context.Context, id int64, date time.Time, status, errMsg string) error
+	// RetryFailedDays makes the failed days of the backfill job pending again
+	RetryFailedDays(ctx context.Context, id int64) error
 }
 
 func Load(ctx context.Context, path string, s *Storer) error {
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+)
+
+const subscriptionColumns = "`id`, `pair`, `condition`, `value`, `url`, `secret`, `active`, `failures`, `reference`, `created`, `updated`"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSubscription(row scanner) (sub data.Subscription, err error) {
+	err = row.Scan(&sub.ID, &sub.Pair, &sub.Condition, &sub.Value, &sub.URL, &sub.Secret,
+		&sub.Active, &sub.Failures, &sub.Reference, &sub.Created, &sub.Updated)
+	return sub, err
+}
+
+// CreateSubscription stores a new webhook subscription
+func (s *SQLiteStorage) CreateSubscription(ctx context.Context, sub data.Subscription) (data.Subscription, error) {
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	q := "INSERT INTO `subscriptions` (`pair`, `condition`, `value`, `url`, `secret`, `active`, `failures`, `reference`, `created`, `updated`) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, 0, 0, $7, $7)"
+	res, err := s.DB.ExecContext(ctx, q, sub.Pair, sub.Condition, sub.Value, sub.URL, sub.Secret, sub.Active, now)
+	if err != nil {
+		return sub, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return sub, err
+	}
+	return s.Subscription(ctx, id)
+}
+
+// Subscription returns the webhook subscription
+func (s *SQLiteStorage) Subscription(ctx context.Context, id int64) (data.Subscription, error) {
+
+	q := "SELECT " + subscriptionColumns + " FROM `subscriptions` WHERE `id` = $1"
+	sub, err := scanSubscription(s.DB.QueryRowContext(ctx, q, id))
+	if err == sql.ErrNoRows {
+		return sub, ErrNotFound
+	}
+	return sub, err
+}
+
+// Subscriptions returns all webhook subscriptions or only the active ones
+func (s *SQLiteStorage) Subscriptions(ctx context.Context, activeOnly bool) ([]data.Subscription, error) {
+
+	q := "SELECT " + subscriptionColumns + " FROM `subscriptions` WHERE `active` OR NOT $1 ORDER BY `id`"
+	rows, err := s.DB.QueryContext(ctx, q, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []data.Subscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sub)
+	}
+	return res, rows.Err()
+}
+
+// UpdateSubscription replaces the settings and the state of the webhook subscription
+func (s *SQLiteStorage) UpdateSubscription(ctx context.Context, sub data.Subscription) (data.Subscription, error) {
+
+	q := "UPDATE `subscriptions` SET `pair` = $1, `condition` = $2, `value` = $3, `url` = $4, `secret` = $5, " +
+		"`active` = $6, `failures` = $7, `reference` = $8, `updated` = $9 WHERE `id` = $10"
+	res, err := s.DB.ExecContext(ctx, q, sub.Pair, sub.Condition, sub.Value, sub.URL, sub.Secret,
+		sub.Active, sub.Failures, sub.Reference, time.Now().Format("2006-01-02 15:04:05"), sub.ID)
+	if err != nil {
+		return sub, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return sub, err
+	}
+	return s.Subscription(ctx, sub.ID)
+}
+
+// DeleteSubscription removes the webhook subscription with its deliveries
+func (s *SQLiteStorage) DeleteSubscription(ctx context.Context, id int64) error {
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM `subscriptions` WHERE `id` = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM `webhook_deliveries` WHERE `subscription_id` = $1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetReference updates the rate the next one is compared with
+func (s *SQLiteStorage) SetReference(ctx context.Context, id int64, reference float64) error {
+
+	_, err := s.DB.ExecContext(ctx, "UPDATE `subscriptions` SET `reference` = $1 WHERE `id` = $2", reference, id)
+	return err
+}
+
+// RecordDelivery resets the failures of the subscription if the alert was delivered, otherwise counts
+// the failure and disables the subscription after maxFailures consecutive failures. Returns whether
+// the subscription is active
+func (s *SQLiteStorage) RecordDelivery(ctx context.Context, id int64, delivered bool, maxFailures int) (active bool, err error) {
+
+	q := "UPDATE `subscriptions` SET " +
+		"`failures` = CASE WHEN $1 THEN 0 ELSE `failures` + 1 END, " +
+		"`active` = CASE WHEN NOT $1 AND `failures` + 1 >= $2 THEN 0 ELSE `active` END, " +
+		"`updated` = $3 WHERE `id` = $4 RETURNING `active`"
+	err = s.DB.QueryRowContext(ctx, q, delivered, maxFailures, time.Now().Format("2006-01-02 15:04:05"), id).Scan(&active)
+	if err == sql.ErrNoRows {
+		return false, ErrNotFound
+	}
+	return active, err
+}
+
+// LogDelivery stores the delivery attempt
+func (s *SQLiteStorage) LogDelivery(ctx context.Context, d data.Delivery) error {
+
+	q := "INSERT INTO `webhook_deliveries` (`subscription_id`, `event`, `attempt`, `status`, `error`, `created`) VALUES ($1, $2, $3, $4, $5, $6)"
+	_, err := s.DB.ExecContext(ctx, q, d.SubscriptionID, d.Event, d.Attempt, d.Status, d.Error, time.Now().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// Deliveries returns the most recent delivery attempts of the subscription
+func (s *SQLiteStorage) Deliveries(ctx context.Context, id int64, limit int) ([]data.Delivery, error) {
+
+	q := "SELECT `id`, `subscription_id`, `event`, `attempt`, `status`, `error`, `created` FROM `webhook_deliveries` " +
+		"WHERE `subscription_id` = $1 ORDER BY `id` DESC LIMIT $2"
+	rows, err := s.DB.QueryContext(ctx, q, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []data.Delivery{}
+	for rows.Next() {
+		d := data.Delivery{}
+		if err = rows.Scan(&d.ID, &d.SubscriptionID, &d.Event, &d.Attempt, &d.Status, &d.Error, &d.Created); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,210 @@
+// Package tenant authenticates the API keys of the tenants and enforces their rate limits and monthly quotas
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/currency"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrRateLimited   = errors.New("rate limit exceeded")
+	ErrQuotaExceeded = errors.New("monthly quota exceeded")
+)
+
+// Tenant is a client of the API identified by its API key
+type Tenant struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// Currencies the tenant may request, all enabled currencies if empty
+	Currencies []string `json:"currencies,omitempty"`
+	// Base of the rates returned to the tenant, the base of the stored rates if empty
+	Base string `json:"base,omitempty"`
+	// RateLimit is the number of requests per second, unlimited if 0
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	// Burst is the number of requests allowed at once, the rate limit rounded up if 0
+	Burst int `json:"burst,omitempty"`
+	// MonthlyQuota is the number of requests per calendar month (UTC), unlimited if 0
+	MonthlyQuota int64 `json:"monthly_quota,omitempty"`
+}
+
+// Load reads the tenants from the JSON file with an array of tenants, there are no tenants if the path is empty
+func Load(path string) ([]Tenant, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tenants: %w", err)
+	}
+	defer f.Close()
+
+	var tenants []Tenant
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&tenants); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants %s: %w", path, err)
+	}
+	return tenants, Validate(tenants)
+}
+
+// Validate checks that names and keys of the tenants are unique, currencies are known ISO 4217 codes,
+// the base is one of the currencies of the tenant and the limits are not negative
+func Validate(tenants []Tenant) error {
+	names, keys := map[string]bool{}, map[string]bool{}
+	for i, t := range tenants {
+		switch {
+		case t.Name == "":
+			return fmt.Errorf("tenant %d: empty name", i)
+		case names[t.Name]:
+			return fmt.Errorf("tenant %s: duplicate name", t.Name)
+		case t.Key == "":
+			return fmt.Errorf("tenant %s: empty key", t.Name)
+		case keys[t.Key]:
+			return fmt.Errorf("tenant %s: duplicate key", t.Name)
+		case t.RateLimit < 0 || t.Burst < 0 || t.MonthlyQuota < 0:
+			return fmt.Errorf("tenant %s: limits should not be negative", t.Name)
+		}
+		names[t.Name], keys[t.Key] = true, true
+
+		for _, c := range t.Currencies {
+			if !currency.Known(c) {
+				return fmt.Errorf("tenant %s: unknown currency code %q", t.Name, c)
+			}
+		}
+		if t.Base != "" && !currency.Known(t.Base) {
+			return fmt.Errorf("tenant %s: unknown base currency code %q", t.Name, t.Base)
+		}
+		if t.Base != "" && !t.Allowed(t.Base) {
+			return fmt.Errorf("tenant %s: base %s is not one of the currencies %s", t.Name, t.Base, strings.Join(t.Currencies, ","))
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether the tenant may request the currency
+func (t Tenant) Allowed(code string) bool {
+	if len(t.Currencies) == 0 {
+		return true
+	}
+	for _, c := range t.Currencies {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// UsageFunc returns the number of requests of the tenant made since the time
+type UsageFunc func(ctx context.Context, tenant string, since time.Time) (int64, error)
+
+// Registry looks up tenants by their keys and tracks their limits. The usage of a tenant is
+// read once a month with the UsageFunc and counted in memory afterwards
+type Registry struct {
+	usage  UsageFunc
+	byKey  map[string]*entry
+	byName map[string]*entry
+	now    func() time.Time
+}
+
+type entry struct {
+	Tenant
+	limiter *rate.Limiter
+
+	mu    sync.Mutex
+	month time.Time // start of the month of the usage, zero until the usage is read
+	used  int64
+}
+
+// NewRegistry makes a registry of the validated tenants
+func NewRegistry(tenants []Tenant, usage UsageFunc) *Registry {
+	r := &Registry{usage: usage, byKey: map[string]*entry{}, byName: map[string]*entry{}, now: time.Now}
+	for _, t := range tenants {
+		e := &entry{Tenant: t}
+		if t.RateLimit > 0 {
+			burst := t.Burst
+			if burst == 0 {
+				burst = int(math.Ceil(t.RateLimit))
+			}
+			e.limiter = rate.NewLimiter(rate.Limit(t.RateLimit), burst)
+		}
+		r.byKey[t.Key], r.byName[t.Name] = e, e
+	}
+	return r
+}
+
+// Lookup returns the tenant of the API key
+func (r *Registry) Lookup(key string) (Tenant, bool) {
+	e, ok := r.byKey[key]
+	if !ok {
+		return Tenant{}, false
+	}
+	return e.Tenant, true
+}
+
+// Allow checks the monthly quota and the rate limit of the tenant with the key. Rejected requests
+// get ErrQuotaExceeded or ErrRateLimited with the time to wait before the next attempt
+func (r *Registry) Allow(ctx context.Context, key string) (time.Duration, error) {
+	e, ok := r.byKey[key]
+	if !ok {
+		return 0, errors.New("unknown tenant key")
+	}
+	now := r.now().UTC()
+
+	if e.MonthlyQuota > 0 {
+		e.mu.Lock()
+		month := monthStart(now)
+		if !e.month.Equal(month) {
+			used, err := r.usage(ctx, e.Name, month)
+			if err != nil {
+				e.mu.Unlock()
+				return 0, fmt.Errorf("failed to read the usage of %s: %w", e.Name, err)
+			}
+			e.month, e.used = month, used
+		}
+		exceeded := e.used >= e.MonthlyQuota
+		e.mu.Unlock()
+		if exceeded {
+			return month.AddDate(0, 1, 0).Sub(now), ErrQuotaExceeded
+		}
+	}
+
+	if e.limiter != nil {
+		res := e.limiter.ReserveN(now, 1)
+		if delay := res.DelayFrom(now); delay > 0 {
+			res.CancelAt(now)
+			return delay, ErrRateLimited
+		}
+	}
+	return 0, nil
+}
+
+// Count adds a request of the tenant to the usage of the current month,
+// requests are not counted until the usage of the month is read
+func (r *Registry) Count(name string) {
+	e, ok := r.byName[name]
+	if !ok {
+		return
+	}
+	month := monthStart(r.now())
+	e.mu.Lock()
+	if e.month.Equal(month) {
+		e.used++
+	}
+	e.mu.Unlock()
+}
+
+// monthStart returns the start of the calendar month (UTC) of the time
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
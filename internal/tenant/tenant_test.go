@@ -0,0 +1,119 @@
+package tenant
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	tenants, err := Load("")
+	assert.Nil(t, err)
+	assert.Nil(t, tenants)
+
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "tenants.json")
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	tenants, err = Load(write(`[
+		{"name": "billing", "key": "k1", "currencies": ["EUR", "RON"], "base": "EUR", "rate_limit": 2.5, "monthly_quota": 1000},
+		{"name": "reports", "key": "k2"}
+	]`))
+	assert.Nil(t, err)
+	assert.Equal(t, []Tenant{
+		{Name: "billing", Key: "k1", Currencies: []string{"EUR", "RON"}, Base: "EUR", RateLimit: 2.5, MonthlyQuota: 1000},
+		{Name: "reports", Key: "k2"},
+	}, tenants)
+
+	tbl := []struct {
+		content string
+		err     string
+	}{
+		{`{"name": "a"}`, "failed to parse tenants"},
+		{`[{"name": "a", "key": "k", "quota": 1}]`, `unknown field "quota"`},
+		{`[{"key": "k"}]`, "tenant 0: empty name"},
+		{`[{"name": "a", "key": "k"}, {"name": "a", "key": "l"}]`, "tenant a: duplicate name"},
+		{`[{"name": "a"}]`, "tenant a: empty key"},
+		{`[{"name": "a", "key": "k"}, {"name": "b", "key": "k"}]`, "tenant b: duplicate key"},
+		{`[{"name": "a", "key": "k", "monthly_quota": -1}]`, "tenant a: limits should not be negative"},
+		{`[{"name": "a", "key": "k", "currencies": ["EUR", "XYZ"]}]`, `tenant a: unknown currency code "XYZ"`},
+		{`[{"name": "a", "key": "k", "base": "XYZ"}]`, `tenant a: unknown base currency code "XYZ"`},
+		{`[{"name": "a", "key": "k", "currencies": ["EUR"], "base": "USD"}]`, "tenant a: base USD is not one of the currencies EUR"},
+	}
+	for _, tt := range tbl {
+		_, err = Load(write(tt.content))
+		if assert.NotNil(t, err, tt.content) {
+			assert.Contains(t, err.Error(), tt.err, tt.content)
+		}
+	}
+
+	_, err = Load(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestRegistry_Allow(t *testing.T) {
+	ctx := context.Background()
+	reads := 0
+	usage := func(_ context.Context, tenant string, since time.Time) (int64, error) {
+		reads++
+		assert.Equal(t, "billing", tenant)
+		if since.Month() == time.April {
+			return 2, nil
+		}
+		return 0, nil
+	}
+	r := NewRegistry([]Tenant{
+		{Name: "billing", Key: "k1", MonthlyQuota: 3},
+		{Name: "reports", Key: "k2", RateLimit: 1, Burst: 2},
+	}, usage)
+	now := time.Date(2024, 4, 30, 23, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	tenant, ok := r.Lookup("k1")
+	assert.True(t, ok)
+	assert.Equal(t, "billing", tenant.Name)
+	_, ok = r.Lookup("k3")
+	assert.False(t, ok)
+	_, err := r.Allow(ctx, "k3")
+	assert.NotNil(t, err)
+
+	// the usage is read once a month and counted afterwards
+	_, err = r.Allow(ctx, "k1")
+	assert.Nil(t, err)
+	r.Count("billing")
+	retry, err := r.Allow(ctx, "k1")
+	assert.Equal(t, ErrQuotaExceeded, err)
+	assert.Equal(t, time.Hour, retry)
+	assert.Equal(t, 1, reads)
+
+	// the quota is renewed in the next month
+	now = now.Add(time.Hour)
+	_, err = r.Allow(ctx, "k1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, reads)
+
+	// requests over the burst wait for the rate
+	for i := 0; i < 2; i++ {
+		_, err = r.Allow(ctx, "k2")
+		assert.Nil(t, err)
+	}
+	retry, err = r.Allow(ctx, "k2")
+	assert.Equal(t, ErrRateLimited, err)
+	assert.Equal(t, time.Second, retry)
+	now = now.Add(time.Second)
+	_, err = r.Allow(ctx, "k2")
+	assert.Nil(t, err)
+}
+
+func TestTenant_Allowed(t *testing.T) {
+	assert.True(t, Tenant{}.Allowed("EUR"))
+	assert.True(t, Tenant{Currencies: []string{"EUR", "RON"}}.Allowed("RON"))
+	assert.False(t, Tenant{Currencies: []string{"EUR", "RON"}}.Allowed("USD"))
+}
@@ -2,10 +2,11 @@ package validator
 
 type Validator struct {
 	Errors map[string]string
+	Codes  map[string]string
 }
 
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(map[string]string), Codes: make(map[string]string)}
 }
 
 func (v *Validator) Valid() bool {
@@ -18,12 +19,44 @@ func (v *Validator) AddError(key, message string) {
 	}
 }
 
+// AddErrorCode adds an error with a machine-readable code
+func (v *Validator) AddErrorCode(key, code, message string) {
+	if _, exists := v.Errors[key]; !exists {
+		v.Errors[key] = message
+		v.Codes[key] = code
+	}
+}
+
 func (v *Validator) Check(ok bool, key, message string) {
 	if !ok {
 		v.AddError(key, message)
 	}
 }
 
+// CheckCode adds an error with a machine-readable code if ok is false
+func (v *Validator) CheckCode(ok bool, key, code, message string) {
+	if !ok {
+		v.AddErrorCode(key, code, message)
+	}
+}
+
+// Code returns the code shared by all errors, or the fallback
+// if the errors have different codes or some have no code
+func (v *Validator) Code(fallback string) string {
+	code := ""
+	for key := range v.Errors {
+		c, ok := v.Codes[key]
+		if !ok || (code != "" && c != code) {
+			return fallback
+		}
+		code = c
+	}
+	if code == "" {
+		return fallback
+	}
+	return code
+}
+
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	for i := range permittedValues {
 		if value == permittedValues[i] {
@@ -21,3 +21,25 @@ func Test_Validator(t *testing.T) {
 	assert.True(t, PermittedValue("a", "a", "b", "c"), "Permitted value should return true")
 	assert.False(t, PermittedValue("d", "a", "b", "c"), "Permitted value should return false")
 }
+
+func Test_ValidatorCodes(t *testing.T) {
+	v := New()
+	assert.Equal(t, "invalid", v.Code("invalid"), "no errors")
+
+	v.CheckCode(true, "date", "invalid_date", "invalid date")
+	assert.True(t, v.Valid())
+	v.CheckCode(false, "date", "invalid_date", "invalid date")
+	v.CheckCode(false, "date", "other", "other error")
+	assert.Equal(t, "invalid date", v.Errors["date"], "first error is kept")
+	assert.Equal(t, "invalid_date", v.Code("invalid"))
+
+	v.AddErrorCode("end", "invalid_date", "invalid end date")
+	assert.Equal(t, "invalid_date", v.Code("invalid"), "same code")
+
+	v.AddErrorCode("pair", "unknown_currency", "unknown currency")
+	assert.Equal(t, "invalid", v.Code("invalid"), "different codes")
+
+	v = New()
+	v.AddError("test", "test error")
+	assert.Equal(t, "invalid", v.Code("invalid"), "error without a code")
+}
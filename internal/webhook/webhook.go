@@ -0,0 +1,117 @@
+// Package webhook evaluates rate alerts and delivers signed payloads
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+)
+
+// SignatureHeader carries the HMAC-SHA256 signature of the payload
+const SignatureHeader = "X-Signature"
+
+// Evaluate reports whether the rate triggers the alert and returns the reference rate for the next evaluation.
+// Above and below fire when the rate crosses the value since the reference rate, or when the condition holds
+// on the first evaluation. Change fires when the rate moved by more than value percent since the reference,
+// which is the rate of the first evaluation or of the last alert
+func Evaluate(condition string, value, reference, rate float64) (bool, float64) {
+	switch condition {
+	case data.ConditionAbove:
+		return rate > value && (reference == 0 || reference <= value), rate
+	case data.ConditionBelow:
+		return rate < value && (reference == 0 || reference >= value), rate
+	case data.ConditionChange:
+		if reference == 0 {
+			return false, rate
+		}
+		if math.Abs(rate/reference-1)*100 > value {
+			return true, rate
+		}
+		return false, reference
+	}
+	return false, reference
+}
+
+// Sign returns the signature of the payload in the form "sha256=<hex>"
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the signature matches the payload
+func Verify(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, payload)), []byte(signature))
+}
+
+// Sender posts payloads with retries
+type Sender struct {
+	Client *http.Client
+	// Retries is the number of attempts after the first one
+	Retries int
+	// Backoff is the delay before the first retry, doubled on every next one
+	Backoff time.Duration
+}
+
+// Send posts the signed payload till the receiver responds with a 2xx status or the retries are exhausted.
+// report is called after every attempt with its number starting from 1, the response status, 0 if there
+// was no response, and the error of the attempt. Returns the error of the last attempt
+func (s Sender) Send(ctx context.Context, url, secret, event string, payload []byte, report func(attempt, status int, err error)) (err error) {
+	delay := s.Backoff
+	for attempt := 1; attempt <= s.Retries+1; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+
+		var status int
+		status, err = s.post(ctx, url, secret, event, payload)
+		if report != nil {
+			report(attempt, status, err)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s Sender) post(ctx context.Context, url, secret, event string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "currency-api-webhook")
+	req.Header.Set("X-Event-ID", event)
+	req.Header.Set(SignatureHeader, Sign(secret, payload))
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluate(t *testing.T) {
+	tbl := []struct {
+		condition              string
+		value, reference, rate float64
+		fire                   bool
+		reference2             float64
+	}{
+		{data.ConditionAbove, 42, 41.9, 42.1, true, 42.1},
+		{data.ConditionAbove, 42, 42.1, 42.3, false, 42.3},
+		{data.ConditionAbove, 42, 42.3, 41.8, false, 41.8},
+		{data.ConditionAbove, 42, 0, 42.1, true, 42.1},
+		{data.ConditionAbove, 42, 0, 41, false, 41},
+		{data.ConditionBelow, 40, 40.2, 39.9, true, 39.9},
+		{data.ConditionBelow, 40, 39.9, 39.5, false, 39.5},
+		{data.ConditionChange, 5, 0, 40, false, 40},
+		{data.ConditionChange, 5, 40, 41, false, 40},
+		{data.ConditionChange, 5, 40, 42.5, true, 42.5},
+		{data.ConditionChange, 5, 40, 37.5, true, 37.5},
+		{"unknown", 5, 40, 80, false, 40},
+	}
+	for i, tt := range tbl {
+		fire, reference := Evaluate(tt.condition, tt.value, tt.reference, tt.rate)
+		assert.Equal(t, tt.fire, fire, i)
+		assert.Equal(t, tt.reference2, reference, i)
+	}
+}
+
+func TestSign(t *testing.T) {
+	payload := []byte(`{"event":"abc"}`)
+	sig := Sign("secret", payload)
+	assert.Equal(t, "sha256=", sig[:7])
+	assert.Len(t, sig, 7+64)
+	assert.True(t, Verify("secret", payload, sig))
+	assert.False(t, Verify("other", payload, sig))
+	assert.False(t, Verify("secret", []byte(`{"event":"abd"}`), sig))
+}
+
+func TestSender_Send(t *testing.T) {
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.True(t, Verify("secret", body, r.Header.Get(SignatureHeader)))
+		assert.Equal(t, "evt-1", r.Header.Get("X-Event-ID"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	type attempt struct{ n, status int }
+	var attempts []attempt
+	report := func(n, status int, err error) { attempts = append(attempts, attempt{n, status}) }
+
+	s := Sender{Retries: 3, Backoff: time.Millisecond}
+	err := s.Send(context.Background(), receiver.URL, "secret", "evt-1", []byte(`{}`), report)
+	assert.Nil(t, err)
+	assert.Equal(t, []attempt{{1, 503}, {2, 503}, {3, 204}}, attempts)
+
+	// retries are exhausted
+	atomic.StoreInt32(&calls, -10)
+	attempts = nil
+	s.Retries = 1
+	err = s.Send(context.Background(), receiver.URL, "secret", "evt-1", []byte(`{}`), report)
+	assert.EqualError(t, err, "unexpected status 503")
+	assert.Len(t, attempts, 2)
+
+	// no response
+	attempts = nil
+	err = s.Send(context.Background(), "http://127.0.0.1:1", "secret", "evt-1", []byte(`{}`), report)
+	assert.NotNil(t, err)
+	assert.Equal(t, []attempt{{1, 0}, {2, 0}}, attempts)
+
+	// canceled while waiting for a retry
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Backoff = time.Hour
+	attempts = nil
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err = s.Send(ctx, "http://127.0.0.1:1", "secret", "evt-1", []byte(`{}`), report)
+	assert.Equal(t, context.Canceled, err)
+	assert.Len(t, attempts, 1)
+}
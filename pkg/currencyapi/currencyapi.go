@@ -0,0 +1,232 @@
+// Package currencyapi is a client of the currency exchange rates API
+package currencyapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/parmaster/currency-api/internal/data"
+)
+
+// Response types shared with the server
+type (
+	Rates     = data.Rates
+	Pair      = data.PairResponse
+	Row       = data.Row
+	FloatRate = data.FloatRate
+)
+
+// Status is the service status
+type Status struct {
+	Status  string         `json:"status"`
+	Version string         `json:"version"`
+	Config  map[string]any `json:"config"`
+	Logs    []string       `json:"logs"`
+}
+
+// Conversion is an amount converted by the latest rate of the pair
+type Conversion struct {
+	Date   string  `json:"date"`
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+	Rate   float64 `json:"rate"`
+	Result float64 `json:"result"`
+}
+
+// Client calls the API, safe for concurrent use
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+	// Retries is the number of retries of requests failed with network errors or 429, 502, 503 and 504 statuses
+	Retries int
+	// RetryDelay is the delay before the first retry, doubled for every next one
+	RetryDelay time.Duration
+	UserAgent  string
+}
+
+// New makes a client of the API at baseURL, e.g. http://localhost:8080
+func New(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Retries:    2,
+		RetryDelay: 200 * time.Millisecond,
+		UserAgent:  "currencyapi-go",
+	}
+}
+
+// Latest returns the latest rates, limited to the symbols if any
+func (c *Client) Latest(ctx context.Context, symbols ...string) (Rates, error) {
+	var rates Rates
+	err := c.get(ctx, "/v1/rates", symbolsQuery(symbols), &rates)
+	return rates, err
+}
+
+// Historical returns the rates for the date, limited to the symbols if any
+func (c *Client) Historical(ctx context.Context, date time.Time, symbols ...string) (Rates, error) {
+	var rates Rates
+	err := c.get(ctx, "/v1/rates/"+date.Format("2006-01-02"), symbolsQuery(symbols), &rates)
+	return rates, err
+}
+
+// Pair returns the latest exchange rate of the pair: 1 from = rate to
+func (c *Client) Pair(ctx context.Context, from, to string) (Pair, error) {
+	var pair Pair
+	err := c.get(ctx, "/v1/pair/"+url.PathEscape(from+"-"+to), nil, &pair)
+	return pair, err
+}
+
+// Convert converts the amount by the latest exchange rate of the pair
+func (c *Client) Convert(ctx context.Context, amount float64, from, to string) (Conversion, error) {
+	pair, err := c.Pair(ctx, from, to)
+	if err != nil {
+		return Conversion{}, err
+	}
+	rate := float64(pair.Rate)
+	return Conversion{Date: pair.Date, From: from, To: to, Amount: amount, Rate: rate, Result: amount * rate}, nil
+}
+
+// TimeSeries returns stored rates for every available date of the range, oldest first,
+// limited to the symbols if any
+func (c *Client) TimeSeries(ctx context.Context, start, end time.Time, symbols ...string) ([]Rates, error) {
+	query := url.Values{}
+	query.Set("start", start.Format("2006-01-02"))
+	query.Set("end", end.Format("2006-01-02"))
+	query.Set("format", "json")
+	var rows []Row
+	if err := c.get(ctx, "/v1/export", query, &rows); err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{}
+	for _, s := range symbols {
+		wanted[s] = true
+	}
+	byDate := map[string]*Rates{}
+	for _, row := range rows {
+		if len(wanted) > 0 && !wanted[row.Currency] {
+			continue
+		}
+		rates, ok := byDate[row.Date+row.Base]
+		if !ok {
+			rates = &Rates{Base: row.Base, Rates: map[string]FloatRate{}}
+			if err := rates.Date.ParseDate(row.Date); err != nil {
+				return nil, fmt.Errorf("invalid date %q: %w", row.Date, err)
+			}
+			byDate[row.Date+row.Base] = rates
+		}
+		rates.Rates[row.Currency] = row.Rate
+	}
+
+	res := make([]Rates, 0, len(byDate))
+	for _, rates := range byDate {
+		res = append(res, *rates)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Date.Equal(res[j].Date.Time) {
+			return res[i].Base < res[j].Base
+		}
+		return res[i].Date.Before(res[j].Date.Time)
+	})
+	return res, nil
+}
+
+// Status returns the service status
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	var status Status
+	err := c.get(ctx, "/v1/status", nil, &status)
+	return status, err
+}
+
+func symbolsQuery(symbols []string) url.Values {
+	if len(symbols) == 0 {
+		return nil
+	}
+	return url.Values{"symbols": {strings.Join(symbols, ",")}}
+}
+
+// get requests the path with retries and decodes the JSON response into v
+func (c *Client) get(ctx context.Context, path string, query url.Values, v any) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, u, v)
+		if err == nil || attempt >= c.Retries || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) do(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("X-Api-Key", c.APIKey)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return parseError(resp, body)
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// retryable reports whether the request can be retried: transport errors and temporary server errors
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
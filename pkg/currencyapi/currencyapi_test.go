@@ -0,0 +1,69 @@
+package currencyapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Retries(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "key", r.Header.Get("X-Api-Key"))
+		if calls.Add(1) < 3 {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"date":"2024-04-20","pair":"USD-UAH","rate":39.4}`))
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL+"/", "key")
+	c.RetryDelay = time.Millisecond
+	pair, err := c.Pair(context.Background(), "USD", "UAH")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Equal(t, FloatRate(39.4), pair.Rate)
+
+	// retries exhausted
+	calls.Store(0)
+	c.Retries = 1
+	_, err = c.Pair(context.Background(), "USD", "UAH")
+	assert.True(t, errors.Is(err, ErrUpstream), "non-envelope 502 is an upstream error")
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestClient_Errors(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"code":"unknown_currency","message":"validation errors","details":{"pair":"unknown currency code: XYZ"},"request_id":"r1"}}`))
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL, "")
+	_, err := c.Convert(context.Background(), 10, "USD", "XYZ")
+	assert.True(t, errors.Is(err, ErrUnknownCurrency))
+	assert.False(t, errors.Is(err, ErrRatesUnavailable))
+	var apiErr *Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "r1", apiErr.RequestID)
+	assert.Equal(t, map[string]string{"pair": "unknown currency code: XYZ"}, apiErr.Details)
+	assert.Equal(t, "currencyapi: 400 unknown_currency: validation errors, pair: unknown currency code: XYZ", err.Error())
+	assert.Equal(t, int32(1), calls.Load(), "client errors are not retried")
+
+	// cancelled context stops retries
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Status(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,103 @@
+package currencyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Error codes returned by the API
+const (
+	CodeInvalidRequest      = "invalid_request"
+	CodeInvalidBody         = "invalid_body"
+	CodeInvalidDate         = "invalid_date"
+	CodeInvalidPair         = "invalid_pair"
+	CodeInvalidFormat       = "invalid_format"
+	CodeInvalidInterval     = "invalid_interval"
+	CodeUnknownCurrency     = "unknown_currency"
+	CodeUnsupportedCurrency = "unsupported_currency"
+	CodeRatesUnavailable    = "rates_unavailable"
+	CodeUpstreamError       = "upstream_error"
+	CodeTooManySubscribers  = "too_many_subscribers"
+	CodeInvalidQuery        = "invalid_query"
+	CodeQueryTooComplex     = "query_too_complex"
+	CodeRateLimited         = "rate_limited"
+	CodeQuotaExceeded       = "quota_exceeded"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeConflict            = "conflict"
+	CodeInternalError       = "internal_error"
+)
+
+// Errors to match with errors.Is, an *Error matches them by the code
+var (
+	ErrInvalidDate         = &Error{Code: CodeInvalidDate}
+	ErrInvalidPair         = &Error{Code: CodeInvalidPair}
+	ErrUnknownCurrency     = &Error{Code: CodeUnknownCurrency}
+	ErrUnsupportedCurrency = &Error{Code: CodeUnsupportedCurrency}
+	ErrRatesUnavailable    = &Error{Code: CodeRatesUnavailable}
+	ErrUpstream            = &Error{Code: CodeUpstreamError}
+	ErrNotFound            = &Error{Code: CodeNotFound}
+	ErrUnauthorized        = &Error{Code: CodeUnauthorized}
+	ErrForbidden           = &Error{Code: CodeForbidden}
+)
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode int               `json:"-"`
+	Code       string            `json:"code"`
+	Message    string            `json:"message"`
+	Details    map[string]string `json:"details,omitempty"`
+	RequestID  string            `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("currencyapi: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	keys := make([]string, 0, len(e.Details))
+	for k := range e.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		msg += fmt.Sprintf(", %s: %s", k, e.Details[k])
+	}
+	return msg
+}
+
+// Is matches errors with the same code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// parseError makes an *Error of the error response, responses without
+// the error envelope (e.g. from a proxy) get the code by the status
+func parseError(resp *http.Response, body []byte) error {
+	envelope := struct {
+		Error *Error `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error != nil && envelope.Error.Code != "" {
+		envelope.Error.StatusCode = resp.StatusCode
+		return envelope.Error
+	}
+
+	e := &Error{StatusCode: resp.StatusCode, Code: CodeInternalError, Message: http.StatusText(resp.StatusCode)}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		e.Code = CodeNotFound
+	case http.StatusUnauthorized:
+		e.Code = CodeUnauthorized
+	case http.StatusForbidden:
+		e.Code = CodeForbidden
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		e.Code = CodeUpstreamError
+	}
+	if resp.StatusCode < http.StatusInternalServerError && e.Code == CodeInternalError {
+		e.Code = CodeInvalidRequest
+	}
+	e.RequestID = resp.Header.Get("X-Request-ID")
+	return e
+}